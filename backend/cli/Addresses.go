@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+const addressUsage = `usage: address <command>
+
+commands:
+  export USER [-format csv|ndjson] [-output FILE]
+
+USER is a user ID or email. The export goes to stdout unless -output is given.
+`
+
+func runAddress(app *App, args []string) int {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprint(app.Stderr, addressUsage)
+		return 2
+	}
+
+	flags := app.newFlagSet("address export", "address export USER [-format csv|ndjson] [-output FILE]")
+	format := flags.String("format", services.FormatCSV, "csv or ndjson")
+	output := flags.String("output", "", "file to write instead of stdout")
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	user, err := app.resolveUser(positional[0])
+	if err != nil {
+		return app.fail(err)
+	}
+
+	var w io.Writer = app.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return app.fail(err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := app.AddressService.ExportAddresses(app.Context, user.ID, *format, w); err != nil {
+		return app.fail(err)
+	}
+	return 0
+}
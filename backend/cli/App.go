@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/database"
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/logging"
+	"github.com/arthur-tragante/liven-code-test/mail"
+	"github.com/arthur-tragante/liven-code-test/metrics"
+	"github.com/arthur-tragante/liven-code-test/migrations"
+	"github.com/arthur-tragante/liven-code-test/passwords"
+	"github.com/arthur-tragante/liven-code-test/ratelimit"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// App holds what every subcommand shares: output streams, the database and the services built on it
+type App struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	// Context is passed to every service call
+	Context context.Context
+
+	// ConfigFile is the YAML or TOML file given with -config
+	ConfigFile string
+	Config     *config.Config
+	// Logger writes JSON to Stderr, it is also the slog default
+	Logger *slog.Logger
+	// Metrics is served on /metrics by serve
+	Metrics *metrics.Metrics
+
+	DB                 *gorm.DB
+	Migrator           *migrations.Migrator
+	UserService        *services.UserService
+	AddressService     *services.AddressService
+	AuditService       *services.AuditService
+	PurgeService       *services.PurgeService
+	IdempotencyService *services.IdempotencyService
+	// OAuthService is the OpenID Connect provider, nil when no issuer is configured
+	OAuthService *services.OAuthService
+	// RateLimits holds the rate limit buckets, nil when rate limiting is disabled
+	RateLimits ratelimit.Store
+}
+
+type command struct {
+	summary string
+	// offline commands run without a database connection
+	offline bool
+	run     func(app *App, args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":   {summary: "run the HTTP API (default)", run: runServe},
+		"migrate": {summary: "manage database migrations (up, down, status, create)", run: runMigrate},
+		"user":    {summary: "manage users (create, list, disable, enable, reset-password, role)", run: runUser},
+		"address": {summary: "manage addresses (export)", run: runAddress},
+		"token":   {summary: "manage issued tokens (revoke)", run: runToken},
+		"client":  {summary: "manage OAuth clients of the OpenID Connect provider (create, list, delete)", run: runClient},
+		"seed":    {summary: "create demo users and addresses", run: runSeed},
+		"config":  {summary: "check or print the effective configuration", offline: true, run: runConfig},
+	}
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit code
+func Run(args []string) int {
+	app := &App{Stdout: os.Stdout, Stderr: os.Stderr, Context: context.Background()}
+	return app.Run(args)
+}
+
+func (app *App) Run(args []string) int {
+	if app.Context == nil {
+		app.Context = context.Background()
+	}
+
+	global := app.newFlagSet("global", "[-config FILE] <command> [arguments]")
+	global.StringVar(&app.ConfigFile, "config", "", "YAML or TOML configuration file (default $CONFIG_FILE)")
+	global.Usage = app.usage
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	args = global.Args()
+
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		app.usage()
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(app.Stderr, "unknown command %q\n\n", args[0])
+		app.usage()
+		return 2
+	}
+
+	// Creating a migration only touches the source tree
+	if args[0] == "migrate" && len(args) > 1 && args[1] == "create" {
+		return cmd.run(app, args[1:])
+	}
+
+	cfg, err := config.Load(config.Options{File: app.ConfigFile})
+	if err != nil {
+		fmt.Fprintf(app.Stderr, "invalid configuration:\n%s\n", indent(err.Error()))
+		return 1
+	}
+	app.Config = cfg
+	app.Logger = logging.New(app.Stderr, cfg.LogLevel)
+	slog.SetDefault(app.Logger)
+
+	if !cmd.offline {
+		if err := app.connect(); err != nil {
+			fmt.Fprintln(app.Stderr, err)
+			return 1
+		}
+	}
+	return cmd.run(app, args[1:])
+}
+
+func (app *App) usage() {
+	fmt.Fprintf(app.Stderr, "usage: %s [-config FILE] <command> [arguments]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, name := range []string{"serve", "migrate", "user", "address", "token", "client", "seed", "config"} {
+		fmt.Fprintf(app.Stderr, "  %-8s %s\n", name, commands[name].summary)
+	}
+}
+
+// connect opens the database and wires the services every command works with
+func (app *App) connect() error {
+	db, err := database.Open(app.Config.Database, app.Logger)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	app.Metrics = metrics.New()
+	if err := app.Metrics.RegisterDatabase(db); err != nil {
+		return fmt.Errorf("failed to register database metrics: %w", err)
+	}
+
+	app.DB = db
+	app.Migrator = migrator
+	app.AuditService = &services.AuditService{Events: &repositories.GormAuditRepository{DB: db}, Logger: app.Logger}
+	app.UserService = &services.UserService{
+		Users:               &repositories.GormUserRepository{DB: db},
+		JWTSecret:           app.Config.JWTSecret,
+		DeletionGracePeriod: app.Config.DeletionRetention,
+		Logger:              app.Logger,
+		Metrics:             app.Metrics,
+		Hasher: &hashing.Pool{
+			Workers:      app.Config.Hashing.Workers,
+			QueueTimeout: app.Config.Hashing.QueueTimeout,
+			Hasher:       newPasswordHasher(app.Config.Hashing),
+			Metrics:      app.Metrics,
+		},
+		PasswordPolicy: newPasswordPolicy(app.Config.PasswordPolicy, app.Config.Hashing),
+		Sessions:       &repositories.GormSessionRepository{DB: db},
+		Mailer:         newMailer(app.Config.Mail, app.Logger),
+		Audit:          app.AuditService,
+		Identities:     &repositories.GormIdentityRepository{DB: db},
+	}
+	app.AddressService = &services.AddressService{
+		Addresses: &repositories.GormAddressRepository{DB: db},
+		Logger:    app.Logger,
+		Metrics:   app.Metrics,
+		Audit:     app.AuditService,
+	}
+	app.PurgeService = &services.PurgeService{DB: db, Retention: app.Config.DeletionRetention, Logger: app.Logger}
+	app.IdempotencyService = &services.IdempotencyService{DB: db}
+	if app.Config.OAuth.Issuer != "" {
+		if app.OAuthService, err = newOAuthService(app.Config.OAuth, db, app.UserService, app.AuditService, app.Logger); err != nil {
+			return err
+		}
+	}
+	switch app.Config.RateLimit.Store {
+	case config.RateLimitMemory:
+		app.RateLimits = &ratelimit.MemoryStore{}
+	case config.RateLimitDatabase:
+		app.RateLimits = &ratelimit.GormStore{DB: db}
+	}
+	return nil
+}
+
+func newOAuthService(cfg config.OAuth, db *gorm.DB, users *services.UserService, audit *services.AuditService, logger *slog.Logger) (*services.OAuthService, error) {
+	pem, err := os.ReadFile(cfg.SigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the OAuth signing key: %w", err)
+	}
+	key, err := services.ParseSigningKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OAuth signing key: %w", err)
+	}
+	return &services.OAuthService{
+		OAuth:                &repositories.GormOAuthRepository{DB: db},
+		Users:                users,
+		Issuer:               cfg.Issuer,
+		SigningKey:           key,
+		AccessTokenLifetime:  cfg.AccessTokenLifetime,
+		RefreshTokenLifetime: cfg.RefreshTokenLifetime,
+		Logger:               logger,
+		Audit:                audit,
+	}, nil
+}
+
+// newPasswordHasher returns the algorithm making new password hashes
+func newPasswordHasher(cfg config.Hashing) hashing.PasswordHasher {
+	if cfg.Algorithm == config.HashBcrypt {
+		return &hashing.Bcrypt{Cost: cfg.BcryptCost}
+	}
+	return &hashing.Argon2id{Memory: cfg.Argon2Memory, Iterations: cfg.Argon2Iterations, Parallelism: cfg.Argon2Parallelism}
+}
+
+// newMailer returns the sender of notification emails, nil when they are turned off
+func newMailer(cfg config.Mail, logger *slog.Logger) mail.Sender {
+	switch cfg.Sender {
+	case config.MailSMTP:
+		return &mail.SMTPSender{Host: cfg.SMTPHost, Port: cfg.SMTPPort, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.From}
+	case config.MailLog:
+		return &mail.LogSender{Logger: logger}
+	}
+	return nil
+}
+
+func newPasswordPolicy(cfg config.PasswordPolicy, hash config.Hashing) *passwords.Policy {
+	policy := &passwords.Policy{
+		MinLength:           cfg.MinLength,
+		MaxLength:           cfg.MaxLength,
+		MinCharacterClasses: cfg.MinCharacterClasses,
+		History:             cfg.History,
+	}
+	if hash.Algorithm == config.HashBcrypt {
+		policy.MaxBytes = hashing.BcryptMaxBytes
+	}
+	if cfg.BreachedDir != "" {
+		policy.Breached = &passwords.BreachedDirectory{Dir: cfg.BreachedDir}
+	}
+	return policy
+}
+
+// closeDB releases the connection pool
+func (app *App) closeDB() {
+	if sqlDB, err := app.DB.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// parseFlags parses args with flags allowed before, between and after positional arguments,
+// and returns the positional ones
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func (app *App) newFlagSet(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(app.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(app.Stderr, "usage: %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// indent prefixes every line of text, used to list validation errors under a heading
+func indent(text string) string {
+	return "  " + strings.ReplaceAll(text, "\n", "\n  ")
+}
+
+func (app *App) fail(err error) int {
+	fmt.Fprintln(app.Stderr, strings.TrimSpace(err.Error()))
+	return 1
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/passwords"
+)
+
+func TestParseFlagsInterleaved(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	asJSON := flags.Bool("json", false, "")
+	format := flags.String("format", "csv", "")
+
+	positional, err := parseFlags(flags, []string{"jane@example.com", "-format", "ndjson", "extra", "-json"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jane@example.com", "extra"}, positional)
+	assert.True(t, *asJSON)
+	assert.Equal(t, "ndjson", *format)
+}
+
+func TestParseFlagsUnknownFlag(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	_, err := parseFlags(flags, []string{"-nope"})
+
+	assert.Error(t, err)
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	app := &App{Stdout: &stdout, Stderr: &stderr}
+
+	code := app.Run([]string{"frobnicate"})
+
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr.String(), `unknown command "frobnicate"`)
+	assert.Empty(t, stdout.String())
+}
+
+func TestRunHelp(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	app := &App{Stdout: &stdout, Stderr: &stderr}
+
+	code := app.Run([]string{"help"})
+
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr.String(), "migrate")
+	assert.Contains(t, stderr.String(), "seed")
+}
+
+func TestNewPasswordPolicyCapsBcryptLength(t *testing.T) {
+	cfg := config.Default()
+	password := strings.Repeat("Ab1!", 18) + "x"
+
+	assert.NoError(t, newPasswordPolicy(cfg.PasswordPolicy, cfg.Hashing).Check(password, "Jane Doe", "jdoe@example.com"))
+
+	cfg.Hashing.Algorithm = config.HashBcrypt
+	err := newPasswordPolicy(cfg.PasswordPolicy, cfg.Hashing).Check(password, "Jane Doe", "jdoe@example.com")
+	assert.ErrorIs(t, err, passwords.ErrRejected)
+	assert.NoError(t, newPasswordPolicy(cfg.PasswordPolicy, cfg.Hashing).Check(password[:hashing.BcryptMaxBytes], "Jane Doe", "jdoe@example.com"))
+}
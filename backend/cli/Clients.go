@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+const clientUsage = `usage: client <command>
+
+commands:
+  create -name NAME [-redirect-uri URIS] [-scopes SCOPES] [-grants GRANTS] [-public] [-json]
+  list [-json]
+  delete CLIENT_ID [-json]
+
+URIS, SCOPES and GRANTS are comma separated. The secret of a confidential client is only printed on creation.
+The commands need OAUTH_ISSUER to be set.
+`
+
+// clientSummary is the CLI view of an OAuth client
+type clientSummary struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func summarizeClient(client *models.OAuthClient) clientSummary {
+	return clientSummary{
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		Public:       client.Public(),
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		GrantTypes:   client.GrantTypes,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func runClient(app *App, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(app.Stderr, clientUsage)
+		return 2
+	}
+	if app.OAuthService == nil {
+		return app.fail(errors.New("the OpenID Connect provider is disabled, set OAUTH_ISSUER and OAUTH_SIGNING_KEY_FILE"))
+	}
+
+	switch args[0] {
+	case "create":
+		return runClientCreate(app, args[1:])
+	case "list":
+		return runClientList(app, args[1:])
+	case "delete":
+		return runClientDelete(app, args[1:])
+	default:
+		fmt.Fprint(app.Stderr, clientUsage)
+		return 2
+	}
+}
+
+func runClientCreate(app *App, args []string) int {
+	flags := app.newFlagSet("client create", "client create -name NAME [-redirect-uri URIS] [-scopes SCOPES] [-grants GRANTS] [-public] [-json]")
+	name := flags.String("name", "", "name shown to users on the consent page")
+	redirectURIs := flags.String("redirect-uri", "", "comma separated redirect URIs")
+	scopes := flags.String("scopes", "", "comma separated scopes the client may request (default openid,profile,email)")
+	grants := flags.String("grants", "", "comma separated grant types (default authorization_code,refresh_token)")
+	public := flags.Bool("public", false, "client without secret, like a single page or mobile app, which must use PKCE")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+	if *name == "" {
+		flags.Usage()
+		return 2
+	}
+
+	client, secret, err := app.OAuthService.RegisterClient(app.Context, services.NewOAuthClient{
+		Name:         *name,
+		RedirectURIs: splitList(*redirectURIs),
+		Scopes:       splitList(*scopes),
+		GrantTypes:   splitList(*grants),
+		Public:       *public,
+	})
+	if err != nil {
+		return app.fail(err)
+	}
+
+	summary := summarizeClient(client)
+	summary.Secret = secret
+	if *asJSON {
+		return app.printJSON(summary)
+	}
+	fmt.Fprintf(app.Stdout, "created client %s (%s)\n", client.ClientID, client.Name)
+	if secret != "" {
+		fmt.Fprintf(app.Stdout, "secret: %s\n", secret)
+	}
+	return 0
+}
+
+func runClientList(app *App, args []string) int {
+	flags := app.newFlagSet("client list", "client list [-json]")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+
+	clients, err := app.OAuthService.ListClients(app.Context)
+	if err != nil {
+		return app.fail(err)
+	}
+	summaries := make([]clientSummary, 0, len(clients))
+	for i := range clients {
+		summaries = append(summaries, summarizeClient(&clients[i]))
+	}
+	if *asJSON {
+		return app.printJSON(summaries)
+	}
+
+	rows := make([][]string, 0, len(summaries))
+	for _, summary := range summaries {
+		kind := "confidential"
+		if summary.Public {
+			kind = "public"
+		}
+		rows = append(rows, []string{
+			summary.ClientID,
+			summary.Name,
+			kind,
+			strings.Join(summary.GrantTypes, ","),
+			summary.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return app.printTable([]string{"CLIENT ID", "NAME", "TYPE", "GRANTS", "CREATED"}, rows)
+}
+
+func runClientDelete(app *App, args []string) int {
+	flags := app.newFlagSet("client delete", "client delete CLIENT_ID [-json]")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	err = app.OAuthService.DeleteClient(app.Context, positional[0])
+	if errors.Is(err, repositories.ErrNotFound) {
+		return app.fail(fmt.Errorf("client %q not found", positional[0]))
+	}
+	if err != nil {
+		return app.fail(err)
+	}
+	if *asJSON {
+		return app.printJSON(map[string]string{"deleted": positional[0]})
+	}
+	fmt.Fprintf(app.Stdout, "deleted client %s\n", positional[0])
+	return 0
+}
+
+// splitList splits a comma separated flag value, ignoring blanks
+func splitList(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
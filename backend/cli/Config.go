@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const configUsage = `usage: config <command>
+
+commands:
+  check          validate the configuration and exit
+  show [-json]   print the effective configuration with secrets redacted
+`
+
+func runConfig(app *App, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(app.Stderr, configUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "check":
+		// Run already refused an invalid configuration
+		fmt.Fprintln(app.Stdout, "configuration is valid")
+		return 0
+	case "show":
+		flags := app.newFlagSet("config show", "config show [-json]")
+		asJSON := flags.Bool("json", false, "print the result as JSON")
+		if _, err := parseFlags(flags, args[1:]); err != nil {
+			return 2
+		}
+		redacted := app.Config.Redacted()
+		if *asJSON {
+			return app.printJSON(redacted)
+		}
+		db := redacted.Database
+		rows := [][]string{
+			{"port", redacted.Port},
+			{"jwt_secret", redacted.JWTSecret},
+			{"deletion_retention", redacted.DeletionRetention.String()},
+			{"log_level", redacted.LogLevel.String()},
+			{"server.read_timeout", redacted.Server.ReadTimeout.String()},
+			{"server.write_timeout", redacted.Server.WriteTimeout.String()},
+			{"server.idle_timeout", redacted.Server.IdleTimeout.String()},
+			{"server.shutdown_timeout", redacted.Server.ShutdownTimeout.String()},
+			{"server.shutdown_drain_delay", redacted.Server.ShutdownDrainDelay.String()},
+			{"server.trusted_proxies", strings.Join(redacted.Server.TrustedProxies, ",")},
+			{"database.driver", db.Driver},
+			{"database.path", db.Path},
+			{"database.url", db.URL},
+			{"database.host", db.Host},
+			{"database.port", db.Port},
+			{"database.user", db.User},
+			{"database.password", db.Password},
+			{"database.name", db.Name},
+			{"database.sslmode", db.SSLMode},
+			{"database.sslrootcert", db.SSLRootCert},
+			{"database.sslcert", db.SSLCert},
+			{"database.sslkey", db.SSLKey},
+			{"database.max_open_conns", strconv.Itoa(db.MaxOpenConns)},
+			{"database.max_idle_conns", strconv.Itoa(db.MaxIdleConns)},
+			{"database.conn_max_lifetime", db.ConnMaxLifetime.String()},
+			{"database.conn_max_idle_time", db.ConnMaxIdleTime.String()},
+			{"tracing.exporter", redacted.Tracing.Exporter},
+			{"tracing.otlp_endpoint", redacted.Tracing.OTLPEndpoint},
+			{"tracing.sample_ratio", strconv.FormatFloat(redacted.Tracing.SampleRatio, 'g', -1, 64)},
+			{"rate_limit.store", redacted.RateLimit.Store},
+			{"hashing.algorithm", redacted.Hashing.Algorithm},
+			{"hashing.workers", strconv.Itoa(redacted.Hashing.Workers)},
+			{"hashing.queue_timeout", redacted.Hashing.QueueTimeout.String()},
+			{"hashing.bcrypt_cost", strconv.Itoa(redacted.Hashing.BcryptCost)},
+			{"hashing.argon2_memory", strconv.FormatUint(uint64(redacted.Hashing.Argon2Memory), 10)},
+			{"hashing.argon2_iterations", strconv.FormatUint(uint64(redacted.Hashing.Argon2Iterations), 10)},
+			{"hashing.argon2_parallelism", strconv.FormatUint(uint64(redacted.Hashing.Argon2Parallelism), 10)},
+			{"password_policy.min_length", strconv.Itoa(redacted.PasswordPolicy.MinLength)},
+			{"password_policy.max_length", strconv.Itoa(redacted.PasswordPolicy.MaxLength)},
+			{"password_policy.min_character_classes", strconv.Itoa(redacted.PasswordPolicy.MinCharacterClasses)},
+			{"password_policy.history", strconv.Itoa(redacted.PasswordPolicy.History)},
+			{"password_policy.breached_dir", redacted.PasswordPolicy.BreachedDir},
+			{"cors.allowed_origins", strings.Join(redacted.CORS.AllowedOrigins, ",")},
+			{"cors.allow_credentials", strconv.FormatBool(redacted.CORS.AllowCredentials)},
+			{"cors.max_age", redacted.CORS.MaxAge.String()},
+			{"security_headers.hsts_max_age", redacted.SecurityHeaders.HSTSMaxAge.String()},
+			{"security_headers.hsts_include_subdomains", strconv.FormatBool(redacted.SecurityHeaders.HSTSIncludeSubdomains)},
+			{"security_headers.frame_options", redacted.SecurityHeaders.FrameOptions},
+			{"security_headers.content_security_policy", redacted.SecurityHeaders.ContentSecurityPolicy},
+			{"session.cookie_domain", redacted.Session.CookieDomain},
+			{"session.cookie_secure", strconv.FormatBool(redacted.Session.CookieSecure)},
+			{"session.cookie_samesite", redacted.Session.CookieSameSite},
+			{"mail.sender", redacted.Mail.Sender},
+			{"mail.from", redacted.Mail.From},
+			{"mail.smtp_host", redacted.Mail.SMTPHost},
+			{"mail.smtp_port", strconv.Itoa(redacted.Mail.SMTPPort)},
+			{"mail.smtp_username", redacted.Mail.SMTPUsername},
+			{"mail.smtp_password", redacted.Mail.SMTPPassword},
+			{"oauth.issuer", redacted.OAuth.Issuer},
+			{"oauth.signing_key_file", redacted.OAuth.SigningKeyFile},
+			{"oauth.access_token_lifetime", redacted.OAuth.AccessTokenLifetime.String()},
+			{"oauth.refresh_token_lifetime", redacted.OAuth.RefreshTokenLifetime.String()},
+			{"metrics.token", redacted.Metrics.Token},
+			{"metrics.allowed_ips", strings.Join(redacted.Metrics.AllowedIPs, ",")},
+			{"oidc.redirect_base_url", redacted.OIDC.RedirectBaseURL},
+			{"oidc.frontend_url", redacted.OIDC.FrontendURL},
+		}
+		var providers []string
+		for _, provider := range redacted.OIDC.Providers {
+			providers = append(providers, provider.Name)
+		}
+		rows = append(rows, []string{"oidc.providers", strings.Join(providers, ",")})
+		for _, provider := range redacted.OIDC.Providers {
+			prefix := "oidc." + provider.Name + "."
+			rows = append(rows,
+				[]string{prefix + "issuer", provider.Issuer},
+				[]string{prefix + "client_id", provider.ClientID},
+				[]string{prefix + "client_secret", provider.ClientSecret},
+				[]string{prefix + "scopes", strings.Join(provider.Scopes, ",")},
+			)
+		}
+		return app.printTable([]string{"SETTING", "VALUE"}, rows)
+	default:
+		fmt.Fprint(app.Stderr, configUsage)
+		return 2
+	}
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arthur-tragante/liven-code-test/migrations"
+)
+
+const migrateUsage = `usage: migrate <command>
+
+commands:
+  up [-json]                apply every pending migration
+  down [-steps N] [-json]   revert the last N applied migrations (default 1)
+  status [-json]            list migrations and whether they are applied
+  create [-dir DIR] NAME    add an empty up/down pair to the migrations source
+`
+
+type migrationResult struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+}
+
+func runMigrate(app *App, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(app.Stderr, migrateUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		flags := app.newFlagSet("migrate up", "migrate up [-json]")
+		asJSON := flags.Bool("json", false, "print the result as JSON")
+		if _, err := parseFlags(flags, args[1:]); err != nil {
+			return 2
+		}
+		applied, err := app.Migrator.Up()
+		if err != nil {
+			return app.fail(err)
+		}
+		return app.printMigrations(applied, "applied", "database is up to date", *asJSON)
+	case "down":
+		flags := app.newFlagSet("migrate down", "migrate down [-steps N] [-json]")
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		asJSON := flags.Bool("json", false, "print the result as JSON")
+		if _, err := parseFlags(flags, args[1:]); err != nil {
+			return 2
+		}
+		if *steps < 1 {
+			fmt.Fprintln(app.Stderr, "-steps must be at least 1")
+			return 2
+		}
+		reverted, err := app.Migrator.Down(*steps)
+		if err != nil {
+			return app.fail(err)
+		}
+		return app.printMigrations(reverted, "reverted", "no migration to revert", *asJSON)
+	case "status":
+		flags := app.newFlagSet("migrate status", "migrate status [-json]")
+		asJSON := flags.Bool("json", false, "print the result as JSON")
+		if _, err := parseFlags(flags, args[1:]); err != nil {
+			return 2
+		}
+		statuses, err := app.Migrator.Status()
+		if err != nil {
+			return app.fail(err)
+		}
+		if *asJSON {
+			return app.printJSON(statuses)
+		}
+		rows := make([][]string, 0, len(statuses))
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Missing {
+				state += " (source missing)"
+			}
+			rows = append(rows, []string{fmt.Sprintf("%04d", status.Version), status.Name, state})
+		}
+		return app.printTable([]string{"VERSION", "NAME", "STATUS"}, rows)
+	case "create":
+		flags := app.newFlagSet("migrate create", "migrate create [-dir DIR] NAME")
+		dir := flags.String("dir", "migrations/sql", "directory holding the migration scripts")
+		positional, err := parseFlags(flags, args[1:])
+		if err != nil {
+			return 2
+		}
+		if len(positional) == 0 {
+			flags.Usage()
+			return 2
+		}
+		paths, err := migrations.Create(*dir, strings.Join(positional, "_"))
+		if err != nil {
+			return app.fail(err)
+		}
+		for _, path := range paths {
+			fmt.Fprintln(app.Stdout, "created", path)
+		}
+		return 0
+	default:
+		fmt.Fprint(app.Stderr, migrateUsage)
+		return 2
+	}
+}
+
+func (app *App) printMigrations(list []migrations.Migration, verb, empty string, asJSON bool) int {
+	if asJSON {
+		results := make([]migrationResult, 0, len(list))
+		for _, migration := range list {
+			results = append(results, migrationResult{Version: migration.Version, Name: migration.Name})
+		}
+		return app.printJSON(results)
+	}
+	if len(list) == 0 {
+		fmt.Fprintln(app.Stdout, empty)
+	}
+	for _, migration := range list {
+		fmt.Fprintf(app.Stdout, "%s %04d_%s\n", verb, migration.Version, migration.Name)
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+)
+
+// printJSON writes value as indented JSON, the machine-readable output of every command
+func (app *App) printJSON(value interface{}) int {
+	encoder := json.NewEncoder(app.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return app.fail(err)
+	}
+	return 0
+}
+
+// printTable writes rows under the given header with aligned columns
+func (app *App) printTable(header []string, rows [][]string) int {
+	w := tabwriter.NewWriter(app.Stdout, 0, 0, 2, ' ', 0)
+	for i, column := range header {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, column)
+	}
+	fmt.Fprintln(w)
+	for _, row := range rows {
+		for i, column := range row {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, column)
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		return app.fail(err)
+	}
+	return 0
+}
+
+func generatePassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
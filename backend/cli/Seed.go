@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+type seedResult struct {
+	Users     []userSummary `json:"users"`
+	Addresses int           `json:"addresses"`
+	Skipped   []string      `json:"skipped,omitempty"`
+}
+
+// runSeed creates demo accounts, skipping the ones that already exist so it can be run repeatedly
+func runSeed(app *App, args []string) int {
+	flags := app.newFlagSet("seed", "seed [-users N] [-addresses N] [-password PASSWORD] [-json]")
+	users := flags.Int("users", 3, "number of demo users")
+	addresses := flags.Int("addresses", 2, "number of addresses per demo user")
+	password := flags.String("password", "password123", "password of the demo users")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+
+	var result seedResult
+	for i := 1; i <= *users; i++ {
+		email := fmt.Sprintf("demo.user%d@example.com", i)
+		if _, err := app.UserService.GetUserByEmail(app.Context, email); err == nil {
+			result.Skipped = append(result.Skipped, email)
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return app.fail(err)
+		}
+
+		user := &models.User{Name: fmt.Sprintf("Demo User %d", i), Email: email, Password: *password}
+		if err := app.UserService.Register(app.Context, user); err != nil {
+			return app.fail(err)
+		}
+		for j := 1; j <= *addresses; j++ {
+			address := &models.Address{
+				UserID:  user.ID,
+				Street:  fmt.Sprintf("%d Demo Street", j*100),
+				Number:  fmt.Sprintf("%d", j),
+				City:    "Demo City",
+				State:   "Demo State",
+				Zipcode: fmt.Sprintf("%05d", i*100+j),
+				Country: "Demo Country",
+			}
+			if err := app.AddressService.CreateAddress(app.Context, address); err != nil {
+				return app.fail(err)
+			}
+			result.Addresses++
+		}
+		result.Users = append(result.Users, summarize(user))
+	}
+
+	if *asJSON {
+		return app.printJSON(result)
+	}
+	for _, user := range result.Users {
+		fmt.Fprintf(app.Stdout, "created user %d <%s>\n", user.ID, user.Email)
+	}
+	for _, email := range result.Skipped {
+		fmt.Fprintf(app.Stdout, "skipped existing user <%s>\n", email)
+	}
+	fmt.Fprintf(app.Stdout, "created %d users and %d addresses\n", len(result.Users), result.Addresses)
+	return 0
+}
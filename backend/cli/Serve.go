@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/routes"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+func runServe(app *App, args []string) int {
+	flags := app.newFlagSet("serve", "serve")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+
+	if _, err := app.Migrator.Up(); err != nil {
+		return app.fail(fmt.Errorf("failed to migrate database: %w", err))
+	}
+
+	ctx, stop := signal.NotifyContext(app.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, app.Config.Tracing, app.Stdout)
+	if err != nil {
+		return app.fail(err)
+	}
+	defer func() {
+		// The context of the signal is already cancelled by now
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			app.Logger.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	purgeDone := make(chan struct{})
+	go func() {
+		app.PurgeService.Run(ctx, time.Hour)
+		close(purgeDone)
+	}()
+
+	userController := &controllers.UserController{
+		UserService:     app.UserService,
+		Logger:          app.Logger,
+		Session:         app.Config.Session,
+		OIDCProviders:   newOIDCProviders(app.Config.OIDC),
+		OIDCFrontendURL: app.Config.OIDC.FrontendURL,
+	}
+	addressController := &controllers.AddressController{AddressService: app.AddressService, Logger: app.Logger}
+	auditController := &controllers.AuditController{AuditService: app.AuditService, Logger: app.Logger}
+	healthController := &controllers.HealthController{DB: app.DB, Migrator: app.Migrator}
+	var oauthController *controllers.OAuthController
+	if app.OAuthService != nil {
+		oauthController = &controllers.OAuthController{OAuthService: app.OAuthService, Logger: app.Logger, Session: app.Config.Session}
+	}
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(app.Config.Server.TrustedProxies); err != nil {
+		return app.fail(err)
+	}
+	// Handlers pass the gin context to the services, this lets its Value reach the request context
+	// and the request ID and span stored there
+	r.ContextWithFallback = true
+	r.Use(middlewares.TracingMiddleware(), middlewares.RequestIDMiddleware(), middlewares.AccessLogMiddleware(app.Logger), middlewares.MetricsMiddleware(app.Metrics))
+	r.Use(middlewares.SecurityHeadersMiddleware(app.Config.SecurityHeaders), middlewares.CORSMiddleware(app.Config.CORS))
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		app.Logger.ErrorContext(c, "handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	routes.SetupRoutes(r, app.Config, userController, addressController, auditController, oauthController, healthController, app.IdempotencyService, app.RateLimits, app.Metrics)
+
+	timeouts := app.Config.Server
+	server := &http.Server{
+		Addr:              app.Config.Address(),
+		Handler:           r,
+		ReadHeaderTimeout: timeouts.ReadTimeout,
+		ReadTimeout:       timeouts.ReadTimeout,
+		WriteTimeout:      timeouts.WriteTimeout,
+		IdleTimeout:       timeouts.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		stop()
+		<-purgeDone
+		app.closeDB()
+		return app.fail(fmt.Errorf("failed to run server: %w", err))
+	case <-ctx.Done():
+	}
+
+	app.Logger.Info("shutting down, draining in-flight requests")
+	err = shutdown(server, healthController, timeouts)
+	<-purgeDone
+	app.closeDB()
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return app.fail(fmt.Errorf("graceful shutdown failed: %w", err))
+	}
+	return 0
+}
+
+// newOIDCProviders returns the OpenID Connect providers by name. Their discovery documents are
+// only fetched on the first login, so a provider being down doesn't keep the server from starting.
+func newOIDCProviders(cfg config.OIDC) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, provider := range cfg.Providers {
+		providers[provider.Name] = &oidc.Provider{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(cfg.RedirectBaseURL, "/") + "/auth/" + provider.Name + "/callback",
+			Scopes:       provider.Scopes,
+		}
+	}
+	return providers
+}
+
+// shutdown fails the readiness probe and keeps serving for the drain delay, so the load balancer stops
+// routing requests to the instance first, then stops accepting requests and lets the in-flight ones finish
+func shutdown(server *http.Server, health *controllers.HealthController, timeouts config.Server) error {
+	health.Drain()
+	time.Sleep(timeouts.ShutdownDrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeouts.ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/controllers"
+)
+
+func TestShutdownDrainsBeforeClosing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	health := &controllers.HealthController{}
+	r := gin.New()
+	r.GET("/readyz", health.Readiness)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: r}
+	go server.Serve(listener)
+	url := "http://" + listener.Addr().String() + "/readyz"
+
+	done := make(chan error, 1)
+	started := time.Now()
+	go func() {
+		done <- shutdown(server, health, config.Server{ShutdownTimeout: time.Second, ShutdownDrainDelay: 300 * time.Millisecond})
+	}()
+
+	// The server still answers during the delay, with a failing readiness probe
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusServiceUnavailable
+	}, 200*time.Millisecond, 10*time.Millisecond)
+
+	require.NoError(t, <-done)
+	assert.GreaterOrEqual(t, time.Since(started), 300*time.Millisecond)
+	_, err = http.Get(url)
+	assert.Error(t, err)
+}
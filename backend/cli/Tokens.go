@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+const tokenUsage = `usage: token <command>
+
+commands:
+  revoke -token JWT [-json]          revoke a single token
+  revoke -jti ID -user USER [-json]  revoke a single token by its ID
+  revoke -user USER [-json]          revoke every token issued to the user so far
+`
+
+type revocationResult struct {
+	UserID  uint   `json:"user_id"`
+	TokenID string `json:"token_id,omitempty"`
+	All     bool   `json:"all"`
+}
+
+func runToken(app *App, args []string) int {
+	if len(args) == 0 || args[0] != "revoke" {
+		fmt.Fprint(app.Stderr, tokenUsage)
+		return 2
+	}
+
+	flags := app.newFlagSet("token revoke", "token revoke (-token JWT | -jti ID -user USER | -user USER) [-json]")
+	tokenString := flags.String("token", "", "token to revoke")
+	tokenID := flags.String("jti", "", "ID of the token to revoke")
+	userRef := flags.String("user", "", "user ID or email")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if _, err := parseFlags(flags, args[1:]); err != nil {
+		return 2
+	}
+
+	var result revocationResult
+	switch {
+	case *tokenString != "":
+		claims, err := app.UserService.ParseToken(*tokenString)
+		if err != nil {
+			return app.fail(fmt.Errorf("invalid token: %w", err))
+		}
+		userID, _ := claims["userID"].(float64)
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		if jti == "" {
+			return app.fail(errors.New("token has no ID, revoke every token of its user with -user instead"))
+		}
+		if err := app.UserService.RevokeToken(app.Context, jti, uint(userID), time.Unix(int64(exp), 0)); err != nil {
+			return app.fail(err)
+		}
+		result = revocationResult{UserID: uint(userID), TokenID: jti}
+	case *userRef != "":
+		user, err := app.resolveUser(*userRef)
+		if err != nil {
+			return app.fail(err)
+		}
+		if *tokenID != "" {
+			// The expiry is unknown, so keep the revocation for as long as any token can live
+			err = app.UserService.RevokeToken(app.Context, *tokenID, user.ID, time.Now().Add(services.TokenLifetime))
+			result = revocationResult{UserID: user.ID, TokenID: *tokenID}
+		} else {
+			err = app.UserService.RevokeUserTokens(app.Context, user.ID)
+			result = revocationResult{UserID: user.ID, All: true}
+		}
+		if err != nil {
+			return app.fail(err)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	if *asJSON {
+		return app.printJSON(result)
+	}
+	if result.All {
+		fmt.Fprintf(app.Stdout, "revoked every token of user %d\n", result.UserID)
+	} else {
+		fmt.Fprintf(app.Stdout, "revoked token %s of user %d\n", result.TokenID, result.UserID)
+	}
+	return 0
+}
@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+const userUsage = `usage: user <command>
+
+commands:
+  create -name NAME -email EMAIL [-password PASSWORD] [-json]
+  list [-deleted] [-limit N] [-offset N] [-json]
+  disable USER [-json]
+  enable USER [-json]
+  reset-password USER [-password PASSWORD] [-json]
+  role USER user|admin [-json]
+
+USER is a user ID or email. A random password is generated and printed when none is given.
+`
+
+// userSummary is the CLI view of a user, without the password hash and addresses
+type userSummary struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Status     string     `json:"status"`
+	Role       string     `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	Password   string     `json:"password,omitempty"`
+}
+
+func summarize(user *models.User) userSummary {
+	summary := userSummary{
+		ID:         user.ID,
+		Name:       user.Name,
+		Email:      user.Email,
+		Status:     "active",
+		Role:       user.Role,
+		CreatedAt:  user.CreatedAt,
+		DisabledAt: user.DisabledAt,
+	}
+	if user.DisabledAt != nil {
+		summary.Status = "disabled"
+	}
+	if user.DeletedAt.Valid {
+		deletedAt := user.DeletedAt.Time
+		summary.DeletedAt = &deletedAt
+		summary.Status = "deleted"
+	}
+	return summary
+}
+
+func runUser(app *App, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(app.Stderr, userUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return runUserCreate(app, args[1:])
+	case "list":
+		return runUserList(app, args[1:])
+	case "disable", "enable":
+		return runUserToggle(app, args[0], args[1:])
+	case "reset-password":
+		return runUserResetPassword(app, args[1:])
+	case "role":
+		return runUserRole(app, args[1:])
+	default:
+		fmt.Fprint(app.Stderr, userUsage)
+		return 2
+	}
+}
+
+func runUserCreate(app *App, args []string) int {
+	flags := app.newFlagSet("user create", "user create -name NAME -email EMAIL [-password PASSWORD] [-json]")
+	name := flags.String("name", "", "name of the user")
+	email := flags.String("email", "", "email of the user")
+	password := flags.String("password", "", "password, generated when empty")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+	if *name == "" || *email == "" {
+		flags.Usage()
+		return 2
+	}
+
+	generated := ""
+	if *password == "" {
+		var err error
+		if generated, err = generatePassword(); err != nil {
+			return app.fail(err)
+		}
+		*password = generated
+	}
+
+	user := &models.User{Name: *name, Email: *email, Password: *password}
+	if err := app.UserService.Register(app.Context, user); err != nil {
+		return app.fail(err)
+	}
+
+	summary := summarize(user)
+	summary.Password = generated
+	if *asJSON {
+		return app.printJSON(summary)
+	}
+	fmt.Fprintf(app.Stdout, "created user %d <%s>\n", user.ID, user.Email)
+	if generated != "" {
+		fmt.Fprintf(app.Stdout, "password: %s\n", generated)
+	}
+	return 0
+}
+
+func runUserList(app *App, args []string) int {
+	flags := app.newFlagSet("user list", "user list [-deleted] [-limit N] [-offset N] [-json]")
+	deleted := flags.Bool("deleted", false, "include soft-deleted users")
+	limit := flags.Int("limit", 0, "maximum number of users to list")
+	offset := flags.Int("offset", 0, "number of users to skip")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+
+	users, err := app.UserService.ListUsers(app.Context, services.ListUsersOptions{IncludeDeleted: *deleted, Limit: *limit, Offset: *offset})
+	if err != nil {
+		return app.fail(err)
+	}
+
+	summaries := make([]userSummary, 0, len(users))
+	for i := range users {
+		summaries = append(summaries, summarize(&users[i]))
+	}
+	if *asJSON {
+		return app.printJSON(summaries)
+	}
+
+	rows := make([][]string, 0, len(summaries))
+	for _, summary := range summaries {
+		rows = append(rows, []string{
+			strconv.FormatUint(uint64(summary.ID), 10),
+			summary.Name,
+			summary.Email,
+			summary.Status,
+			summary.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return app.printTable([]string{"ID", "NAME", "EMAIL", "STATUS", "CREATED"}, rows)
+}
+
+func runUserToggle(app *App, action string, args []string) int {
+	flags := app.newFlagSet("user "+action, "user "+action+" USER [-json]")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	user, err := app.resolveUser(positional[0])
+	if err != nil {
+		return app.fail(err)
+	}
+	if action == "disable" {
+		err = app.UserService.DisableUser(app.Context, user.ID)
+	} else {
+		err = app.UserService.EnableUser(app.Context, user.ID)
+	}
+	if err != nil {
+		return app.fail(err)
+	}
+
+	if user, err = app.resolveUser(positional[0]); err != nil {
+		return app.fail(err)
+	}
+	if *asJSON {
+		return app.printJSON(summarize(user))
+	}
+	fmt.Fprintf(app.Stdout, "%sd user %d <%s>\n", action, user.ID, user.Email)
+	return 0
+}
+
+func runUserResetPassword(app *App, args []string) int {
+	flags := app.newFlagSet("user reset-password", "user reset-password USER [-password PASSWORD] [-json]")
+	password := flags.String("password", "", "new password, generated when empty")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	user, err := app.resolveUser(positional[0])
+	if err != nil {
+		return app.fail(err)
+	}
+
+	generated := ""
+	if *password == "" {
+		if generated, err = generatePassword(); err != nil {
+			return app.fail(err)
+		}
+		*password = generated
+	}
+	if err := app.UserService.ResetPassword(app.Context, user.ID, *password); err != nil {
+		return app.fail(err)
+	}
+
+	summary := summarize(user)
+	summary.Password = generated
+	if *asJSON {
+		return app.printJSON(summary)
+	}
+	fmt.Fprintf(app.Stdout, "password reset for user %d <%s>, existing tokens revoked\n", user.ID, user.Email)
+	if generated != "" {
+		fmt.Fprintf(app.Stdout, "password: %s\n", generated)
+	}
+	return 0
+}
+
+func runUserRole(app *App, args []string) int {
+	flags := app.newFlagSet("user role", "user role USER user|admin [-json]")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	user, err := app.resolveUser(positional[0])
+	if err != nil {
+		return app.fail(err)
+	}
+	if err := app.UserService.SetRole(app.Context, user.ID, positional[1]); err != nil {
+		return app.fail(err)
+	}
+
+	if user, err = app.resolveUser(positional[0]); err != nil {
+		return app.fail(err)
+	}
+	if *asJSON {
+		return app.printJSON(summarize(user))
+	}
+	fmt.Fprintf(app.Stdout, "user %d <%s> is now %s\n", user.ID, user.Email, user.Role)
+	return 0
+}
+
+// resolveUser finds a user by ID or email
+func (app *App) resolveUser(reference string) (*models.User, error) {
+	var user *models.User
+	var err error
+	if id, parseErr := strconv.ParseUint(reference, 10, 64); parseErr == nil {
+		user, err = app.UserService.GetUserByID(app.Context, uint(id))
+	} else {
+		user, err = app.UserService.GetUserByEmail(app.Context, reference)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("user %q not found", reference)
+	}
+	return user, err
+}
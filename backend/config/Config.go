@@ -0,0 +1,892 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinJWTSecretLength is the shortest JWT secret accepted, 256 bits as recommended for HS256
+const MinJWTSecretLength = 32
+
+const redacted = "[REDACTED]"
+
+// urlMask stands in for secrets inside URLs, where the brackets of redacted would be escaped
+const urlMask = "REDACTEDMASK"
+
+// Database drivers the application can run on
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// SSL modes understood by the Postgres driver
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Well-known placeholder secrets, including the one in the README and config.example.yaml, refused whatever their length
+var weakSecrets = []string{"secret", "jwtsecret", "changeme", "password", "test", "testsecret", "replace-with-the-output-of-openssl-rand-base64-32"}
+
+// Config holds every setting the application reads at startup
+type Config struct {
+	Port              string          `json:"port"`
+	JWTSecret         string          `json:"jwt_secret"`
+	DeletionRetention time.Duration   `json:"deletion_retention"`
+	LogLevel          slog.Level      `json:"log_level"`
+	Server            Server          `json:"server"`
+	Database          Database        `json:"database"`
+	Tracing           Tracing         `json:"tracing"`
+	RateLimit         RateLimit       `json:"rate_limit"`
+	Hashing           Hashing         `json:"hashing"`
+	PasswordPolicy    PasswordPolicy  `json:"password_policy"`
+	CORS              CORS            `json:"cors"`
+	SecurityHeaders   SecurityHeaders `json:"security_headers"`
+	Session           Session         `json:"session"`
+	Mail              Mail            `json:"mail"`
+	OIDC              OIDC            `json:"oidc"`
+	OAuth             OAuth           `json:"oauth"`
+	Metrics           Metrics         `json:"metrics"`
+}
+
+// Metrics restricts /metrics to the scrapers: requests from the AllowedIPs (IPs or CIDRs, loopback by
+// default) and, when Token is set, requests with it as bearer token.
+type Metrics struct {
+	Token      string   `json:"token,omitempty"`
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+}
+
+// OAuth makes the API an OpenID Connect provider for other applications once Issuer, the public URL
+// of the API, is set. SigningKeyFile is the PEM encoded RSA private key signing the tokens.
+type OAuth struct {
+	Issuer               string        `json:"issuer,omitempty"`
+	SigningKeyFile       string        `json:"signing_key_file,omitempty"`
+	AccessTokenLifetime  time.Duration `json:"access_token_lifetime"`
+	RefreshTokenLifetime time.Duration `json:"refresh_token_lifetime"`
+}
+
+// DefaultOIDCScopes are requested from the OpenID Connect providers unless their scopes are set
+var DefaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// oidcProviderName keeps the provider names usable in environment variables and URLs
+var oidcProviderName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// OIDC lists the OpenID Connect providers users can log in with. Each one is registered with the
+// redirect URL RedirectBaseURL/auth/<name>/callback. With FrontendURL set the callback starts a
+// cookie session and redirects the browser there, otherwise it answers with a token like POST /login.
+type OIDC struct {
+	RedirectBaseURL string         `json:"redirect_base_url,omitempty"`
+	FrontendURL     string         `json:"frontend_url,omitempty"`
+	Providers       []OIDCProvider `json:"providers,omitempty"`
+}
+
+// OIDCProvider is an OpenID Connect provider, Name is the one of its /auth/<name> routes
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Mail senders
+const (
+	MailNone = "none"
+	MailLog  = "log"
+	MailSMTP = "smtp"
+)
+
+// Mail selects how notification emails leave. The log sender only writes them to the log.
+type Mail struct {
+	Sender       string `json:"sender"`
+	From         string `json:"from,omitempty"`
+	SMTPHost     string `json:"smtp_host,omitempty"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username,omitempty"`
+	SMTPPassword string `json:"smtp_password,omitempty"`
+}
+
+// SameSite values of the session cookies
+const (
+	SameSiteStrict = "strict"
+	SameSiteLax    = "lax"
+	SameSiteNone   = "none"
+)
+
+// Session sets the attributes of the cookies of cookie sessions. A frontend on another site than the
+// API needs SameSite none, which browsers only accept on Secure cookies, and CORS credentials.
+type Session struct {
+	CookieDomain   string `json:"cookie_domain,omitempty"`
+	CookieSecure   bool   `json:"cookie_secure"`
+	CookieSameSite string `json:"cookie_samesite"`
+}
+
+// CORS lets the browser frontends listed in AllowedOrigins call the API. Without origins no CORS
+// headers are sent, "*" allows any origin but can't be combined with AllowCredentials.
+type CORS struct {
+	AllowedOrigins   []string `json:"allowed_origins,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	// MaxAge is how long browsers cache a preflight response
+	MaxAge time.Duration `json:"max_age"`
+}
+
+// SecurityHeaders are sent with every response. HSTSMaxAge at 0 leaves Strict-Transport-Security out,
+// as do an empty FrameOptions and ContentSecurityPolicy for their headers.
+type SecurityHeaders struct {
+	HSTSMaxAge            time.Duration `json:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `json:"hsts_include_subdomains"`
+	FrameOptions          string        `json:"frame_options,omitempty"`
+	ContentSecurityPolicy string        `json:"content_security_policy,omitempty"`
+}
+
+// PasswordPolicy is what new passwords must satisfy. BreachedDir is a local copy of the Have I Been
+// Pwned dataset with one file per hash prefix, the breach check is off when it is empty.
+type PasswordPolicy struct {
+	MinLength           int    `json:"min_length"`
+	MaxLength           int    `json:"max_length"`
+	MinCharacterClasses int    `json:"min_character_classes"`
+	History             int    `json:"history"`
+	BreachedDir         string `json:"breached_dir,omitempty"`
+}
+
+// Password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// Hashing selects the algorithm of new password hashes and bounds the hashing work. Workers at 0
+// means one per CPU. Hashes made with another algorithm or other parameters are upgraded on login.
+type Hashing struct {
+	Algorithm string `json:"algorithm"`
+	Workers   int    `json:"workers"`
+	// QueueTimeout is how long a password operation waits for a worker before the request gets a 503
+	QueueTimeout time.Duration `json:"queue_timeout"`
+	BcryptCost   int           `json:"bcrypt_cost"`
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32 `json:"argon2_memory"`
+	Argon2Iterations  uint32 `json:"argon2_iterations"`
+	Argon2Parallelism uint8  `json:"argon2_parallelism"`
+}
+
+// X-Frame-Options values
+const (
+	FrameOptionsDeny       = "DENY"
+	FrameOptionsSameOrigin = "SAMEORIGIN"
+)
+
+// Rate limit stores
+const (
+	RateLimitNone     = "none"
+	RateLimitMemory   = "memory"
+	RateLimitDatabase = "database"
+)
+
+// RateLimit selects where the rate limit buckets live. The policies themselves are declared with the routes.
+// The memory store counts per instance, the database store is shared by every instance.
+type RateLimit struct {
+	Store string `json:"store"`
+}
+
+// Tracing exporters
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingOTLP   = "otlp"
+)
+
+// Tracing selects where OpenTelemetry spans go. OTLP uses HTTP and, without OTLPEndpoint,
+// the standard OTEL_EXPORTER_OTLP_* variables.
+type Tracing struct {
+	Exporter     string  `json:"exporter"`
+	OTLPEndpoint string  `json:"otlp_endpoint,omitempty"`
+	SampleRatio  float64 `json:"sample_ratio"`
+}
+
+// Server holds the HTTP server timeouts. WriteTimeout bounds the whole response,
+// address exports included, so it must leave room for the largest export.
+type Server struct {
+	ReadTimeout  time.Duration `json:"read_timeout"`
+	WriteTimeout time.Duration `json:"write_timeout"`
+	IdleTimeout  time.Duration `json:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests get to finish after SIGTERM
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	// ShutdownDrainDelay is how long the instance keeps serving with a failing readiness probe after
+	// SIGTERM, for the load balancer to notice and stop routing requests to it
+	ShutdownDrainDelay time.Duration `json:"shutdown_drain_delay"`
+	// TrustedProxies are the IPs and CIDRs whose X-Forwarded-For header is believed when
+	// resolving the client IP, the one rate limits and logs use
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// Database holds the connection settings. Postgres takes either a single URL or separate fields,
+// SQLite only needs the path of the database file.
+type Database struct {
+	Driver      string `json:"driver"`
+	Path        string `json:"path,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Port        string `json:"port,omitempty"`
+	User        string `json:"user,omitempty"`
+	Password    string `json:"password,omitempty"`
+	Name        string `json:"name,omitempty"`
+	SSLMode     string `json:"sslmode"`
+	SSLRootCert string `json:"sslrootcert,omitempty"`
+	SSLCert     string `json:"sslcert,omitempty"`
+	SSLKey      string `json:"sslkey,omitempty"`
+
+	// Connection pool limits, SQLite always uses a single connection
+	MaxOpenConns    int           `json:"max_open_conns"`
+	MaxIdleConns    int           `json:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `json:"conn_max_idle_time"`
+}
+
+// Default returns the configuration used for every setting left unset
+func Default() *Config {
+	return &Config{
+		Port:              "8080",
+		DeletionRetention: 30 * 24 * time.Hour,
+		Server: Server{
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: Database{
+			Driver:          DriverPostgres,
+			Port:            "5432",
+			SSLMode:         "prefer",
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Tracing: Tracing{
+			Exporter:    TracingNone,
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimit{
+			Store: RateLimitMemory,
+		},
+		Hashing: Hashing{
+			Algorithm:    HashArgon2id,
+			QueueTimeout: 2 * time.Second,
+			BcryptCost:   bcrypt.DefaultCost,
+			// The first of the OWASP recommended Argon2id configurations
+			Argon2Memory:      19 * 1024,
+			Argon2Iterations:  2,
+			Argon2Parallelism: 1,
+		},
+		PasswordPolicy: PasswordPolicy{
+			MinLength: 8,
+			MaxLength: 128,
+			History:   5,
+		},
+		CORS: CORS{
+			MaxAge: 10 * time.Minute,
+		},
+		Metrics: Metrics{
+			AllowedIPs: []string{"127.0.0.1", "::1"},
+		},
+		// The API only serves JSON, nothing it returns should load resources or be framed
+		SecurityHeaders: SecurityHeaders{
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			HSTSIncludeSubdomains: true,
+			FrameOptions:          FrameOptionsDeny,
+			ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		},
+		Session: Session{
+			CookieSecure:   true,
+			CookieSameSite: SameSiteStrict,
+		},
+		Mail: Mail{
+			Sender:   MailLog,
+			SMTPPort: 587,
+		},
+		OAuth: OAuth{
+			AccessTokenLifetime:  15 * time.Minute,
+			RefreshTokenLifetime: 30 * 24 * time.Hour,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be a port number, got %q", c.Port))
+	}
+	if err := validateSecret(c.JWTSecret); err != nil {
+		errs = append(errs, err)
+	}
+	if c.DeletionRetention <= 0 {
+		errs = append(errs, errors.New("DELETION_RETENTION must be positive"))
+	}
+	if err := c.Server.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Database.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.RateLimit.Store {
+	case RateLimitNone, RateLimitMemory, RateLimitDatabase:
+	default:
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE must be %s, %s or %s, got %q", RateLimitMemory, RateLimitDatabase, RateLimitNone, c.RateLimit.Store))
+	}
+	if err := c.Hashing.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.PasswordPolicy.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.CORS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.SecurityHeaders.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Mail.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.OIDC.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.OAuth.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Metrics.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.Session.CookieSameSite {
+	case SameSiteStrict, SameSiteLax:
+	case SameSiteNone:
+		if !c.Session.CookieSecure {
+			errs = append(errs, errors.New("SESSION_COOKIE_SAMESITE none requires SESSION_COOKIE_SECURE"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("SESSION_COOKIE_SAMESITE must be %s, %s or %s, got %q", SameSiteStrict, SameSiteLax, SameSiteNone, c.Session.CookieSameSite))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t Tracing) validate() error {
+	var errs []error
+	switch t.Exporter {
+	case TracingNone, TracingStdout, TracingOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be %s, %s or %s, got %q", TracingNone, TracingStdout, TracingOTLP, t.Exporter))
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", t.SampleRatio))
+	}
+	if t.OTLPEndpoint != "" {
+		if parsed, err := url.Parse(t.OTLPEndpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, errors.New("TRACING_OTLP_ENDPOINT must be an http:// or https:// URL"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h Hashing) validate() error {
+	var errs []error
+	if h.Algorithm != HashBcrypt && h.Algorithm != HashArgon2id {
+		errs = append(errs, fmt.Errorf("PASSWORD_HASH_ALGORITHM must be %s or %s, got %q", HashArgon2id, HashBcrypt, h.Algorithm))
+	}
+	if h.Workers < 0 {
+		errs = append(errs, errors.New("HASH_WORKERS must not be negative"))
+	}
+	if h.QueueTimeout <= 0 {
+		errs = append(errs, errors.New("HASH_QUEUE_TIMEOUT must be positive"))
+	}
+	if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, h.BcryptCost))
+	}
+	if h.Argon2Memory < 8*uint32(h.Argon2Parallelism) {
+		errs = append(errs, errors.New("ARGON2_MEMORY must be at least 8 KiB per thread of ARGON2_PARALLELISM"))
+	}
+	if h.Argon2Iterations == 0 {
+		errs = append(errs, errors.New("ARGON2_ITERATIONS must be positive"))
+	}
+	if h.Argon2Parallelism == 0 {
+		errs = append(errs, errors.New("ARGON2_PARALLELISM must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+func (p PasswordPolicy) validate() error {
+	var errs []error
+	if p.MinLength < 1 {
+		errs = append(errs, errors.New("PASSWORD_MIN_LENGTH must be positive"))
+	}
+	if p.MaxLength < p.MinLength {
+		errs = append(errs, fmt.Errorf("PASSWORD_MAX_LENGTH must be at least PASSWORD_MIN_LENGTH (%d)", p.MinLength))
+	}
+	if p.MinCharacterClasses < 0 || p.MinCharacterClasses > 4 {
+		errs = append(errs, fmt.Errorf("PASSWORD_MIN_CHARACTER_CLASSES must be between 0 and 4, got %d", p.MinCharacterClasses))
+	}
+	if p.History < 0 {
+		errs = append(errs, errors.New("PASSWORD_HISTORY must not be negative"))
+	}
+	if p.BreachedDir != "" {
+		if info, err := os.Stat(p.BreachedDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("BREACHED_PASSWORDS_DIR must be a directory, got %q", p.BreachedDir))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c CORS) validate() error {
+	var errs []error
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS can't be * when CORS_ALLOW_CREDENTIALS is true, list the origins"))
+			}
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil || (parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "") {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS must list * or origins like https://app.example.com, got %q", origin))
+		}
+	}
+	if c.MaxAge < 0 {
+		errs = append(errs, errors.New("CORS_MAX_AGE must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func (m Metrics) validate() error {
+	var errs []error
+	for _, allowed := range m.AllowedIPs {
+		if _, _, err := net.ParseCIDR(allowed); err != nil && net.ParseIP(allowed) == nil {
+			errs = append(errs, fmt.Errorf("METRICS_ALLOWED_IPS must list IPs or CIDRs, got %q", allowed))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h SecurityHeaders) validate() error {
+	var errs []error
+	if h.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("HSTS_MAX_AGE must not be negative"))
+	}
+	switch h.FrameOptions {
+	case "", FrameOptionsDeny, FrameOptionsSameOrigin:
+	default:
+		errs = append(errs, fmt.Errorf("FRAME_OPTIONS must be %s, %s or empty, got %q", FrameOptionsDeny, FrameOptionsSameOrigin, h.FrameOptions))
+	}
+	if strings.ContainsAny(h.ContentSecurityPolicy, "\r\n") {
+		errs = append(errs, errors.New("CONTENT_SECURITY_POLICY must fit on one line"))
+	}
+	return errors.Join(errs...)
+}
+
+func (m Mail) validate() error {
+	var errs []error
+	switch m.Sender {
+	case MailNone, MailLog:
+		return nil
+	case MailSMTP:
+	default:
+		return fmt.Errorf("MAIL_SENDER must be %s, %s or %s, got %q", MailNone, MailLog, MailSMTP, m.Sender)
+	}
+	if m.SMTPHost == "" {
+		errs = append(errs, errors.New("SMTP_HOST is required when MAIL_SENDER is smtp"))
+	}
+	if m.SMTPPort < 1 || m.SMTPPort > 65535 {
+		errs = append(errs, fmt.Errorf("SMTP_PORT must be a port number, got %d", m.SMTPPort))
+	}
+	if _, err := netmail.ParseAddress(m.From); err != nil {
+		errs = append(errs, fmt.Errorf("MAIL_FROM must be an email address when MAIL_SENDER is smtp, got %q", m.From))
+	}
+	return errors.Join(errs...)
+}
+
+func (o OIDC) validate() error {
+	if len(o.Providers) == 0 {
+		return nil
+	}
+	var errs []error
+	if !isHTTPURL(o.RedirectBaseURL) {
+		errs = append(errs, errors.New("OIDC_REDIRECT_BASE_URL must be the http:// or https:// URL of the API when OIDC_PROVIDERS is set"))
+	}
+	if o.FrontendURL != "" && !isHTTPURL(o.FrontendURL) {
+		errs = append(errs, errors.New("OIDC_FRONTEND_URL must be an http:// or https:// URL"))
+	}
+	seen := map[string]bool{}
+	for _, provider := range o.Providers {
+		if !oidcProviderName.MatchString(provider.Name) || provider.Name == "providers" {
+			errs = append(errs, fmt.Errorf("OIDC_PROVIDERS must list lowercase alphanumeric names, got %q", provider.Name))
+			continue
+		}
+		if seen[provider.Name] {
+			errs = append(errs, fmt.Errorf("OIDC_PROVIDERS lists %q twice", provider.Name))
+			continue
+		}
+		seen[provider.Name] = true
+
+		prefix := "OIDC_" + strings.ToUpper(provider.Name) + "_"
+		// Plain http is only fine for a provider on the same machine, e.g. in development
+		if parsed, err := url.Parse(provider.Issuer); err != nil || parsed.Host == "" ||
+			(parsed.Scheme != "https" && (parsed.Scheme != "http" || !isLoopback(parsed.Hostname()))) {
+			errs = append(errs, fmt.Errorf("%sISSUER must be an https:// URL, got %q", prefix, provider.Issuer))
+		}
+		if provider.ClientID == "" {
+			errs = append(errs, fmt.Errorf("%sCLIENT_ID is required", prefix))
+		}
+		if !slices.Contains(provider.Scopes, "openid") {
+			errs = append(errs, fmt.Errorf("%sSCOPES must include openid", prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o OAuth) validate() error {
+	var errs []error
+	if o.AccessTokenLifetime <= 0 {
+		errs = append(errs, errors.New("OAUTH_ACCESS_TOKEN_LIFETIME must be positive"))
+	}
+	if o.RefreshTokenLifetime <= 0 {
+		errs = append(errs, errors.New("OAUTH_REFRESH_TOKEN_LIFETIME must be positive"))
+	}
+	if o.Issuer == "" {
+		return errors.Join(errs...)
+	}
+	// Clients compare the issuer of the tokens with the configured one, so it must be a plain URL
+	if parsed, err := url.Parse(o.Issuer); err != nil || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" ||
+		strings.HasSuffix(o.Issuer, "/") || (parsed.Scheme != "https" && (parsed.Scheme != "http" || !isLoopback(parsed.Hostname()))) {
+		errs = append(errs, fmt.Errorf("OAUTH_ISSUER must be an https:// URL without a trailing slash, got %q", o.Issuer))
+	}
+	if o.SigningKeyFile == "" {
+		errs = append(errs, errors.New("OAUTH_SIGNING_KEY_FILE is required when OAUTH_ISSUER is set"))
+	} else if info, err := os.Stat(o.SigningKeyFile); err != nil || info.IsDir() {
+		errs = append(errs, fmt.Errorf("OAUTH_SIGNING_KEY_FILE must be a file, got %q", o.SigningKeyFile))
+	}
+	return errors.Join(errs...)
+}
+
+func isHTTPURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s Server) validate() error {
+	var errs []error
+	for _, timeout := range []struct {
+		env   string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", s.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", s.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", s.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", s.ShutdownTimeout},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", timeout.env))
+		}
+	}
+	if s.ShutdownDrainDelay < 0 {
+		errs = append(errs, errors.New("SHUTDOWN_DRAIN_DELAY must not be negative"))
+	}
+	for _, proxy := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("HTTP_TRUSTED_PROXIES must list IPs or CIDRs, got %q", proxy))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateSecret(secret string) error {
+	if secret == "" {
+		return errors.New("JWT_SECRET is required")
+	}
+	for _, weak := range weakSecrets {
+		if strings.EqualFold(secret, weak) {
+			return errors.New("JWT_SECRET is a well-known placeholder, generate a random one (e.g. openssl rand -base64 32)")
+		}
+	}
+	if len(secret) < MinJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes long, got %d", MinJWTSecretLength, len(secret))
+	}
+	distinct := map[rune]bool{}
+	for _, r := range secret {
+		distinct[r] = true
+	}
+	if len(distinct) < 8 {
+		return errors.New("JWT_SECRET has too little variety, generate a random one (e.g. openssl rand -base64 32)")
+	}
+	return nil
+}
+
+func (d Database) validate() error {
+	if err := d.validatePool(); err != nil {
+		return err
+	}
+
+	switch d.Driver {
+	case DriverSQLite:
+		if d.Path == "" {
+			return errors.New("DB_PATH is required when DB_DRIVER is sqlite")
+		}
+		return nil
+	case DriverPostgres:
+	default:
+		return fmt.Errorf("DB_DRIVER must be %s or %s, got %q", DriverPostgres, DriverSQLite, d.Driver)
+	}
+
+	var errs []error
+	if d.URL != "" {
+		parsed, err := url.Parse(d.URL)
+		if err != nil || (parsed.Scheme != "postgres" && parsed.Scheme != "postgresql") || parsed.Host == "" {
+			errs = append(errs, errors.New("DATABASE_URL must be a postgres:// URL with a host"))
+		}
+	} else {
+		if d.Host == "" {
+			errs = append(errs, errors.New("DB_HOST is required when DATABASE_URL is not set"))
+		}
+		if port, err := strconv.Atoi(d.Port); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("DB_PORT must be a port number, got %q", d.Port))
+		}
+		if d.User == "" {
+			errs = append(errs, errors.New("DB_USER is required when DATABASE_URL is not set"))
+		}
+		if d.Name == "" {
+			errs = append(errs, errors.New("DB_NAME is required when DATABASE_URL is not set"))
+		}
+	}
+
+	valid := false
+	for _, mode := range sslModes {
+		valid = valid || d.SSLMode == mode
+	}
+	if !valid {
+		errs = append(errs, fmt.Errorf("DB_SSLMODE must be one of %s, got %q", strings.Join(sslModes, ", "), d.SSLMode))
+	}
+	if (d.SSLCert == "") != (d.SSLKey == "") {
+		errs = append(errs, errors.New("DB_SSLCERT and DB_SSLKEY must be set together"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d Database) validatePool() error {
+	var errs []error
+	if d.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("DB_MAX_OPEN_CONNS must not be negative"))
+	}
+	if d.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("DB_MAX_IDLE_CONNS must not be negative"))
+	} else if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("DB_MAX_IDLE_CONNS must not exceed DB_MAX_OPEN_CONNS (%d)", d.MaxOpenConns))
+	}
+	if d.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("DB_CONN_MAX_LIFETIME must not be negative"))
+	}
+	if d.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("DB_CONN_MAX_IDLE_TIME must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// DSN returns the connection string for the driver. SQLite gets the file path with the pragmas
+// the application relies on: enforced foreign keys and waiting on locks instead of failing.
+// For Postgres, settings given separately (SSL mode and certificates) are added to DATABASE_URL
+// unless the URL already carries them.
+func (d Database) DSN() string {
+	if d.Driver == DriverSQLite {
+		return "file:" + d.Path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+	if d.URL != "" {
+		parsed, err := url.Parse(d.URL)
+		if err != nil {
+			return d.URL
+		}
+		query := parsed.Query()
+		for key, value := range d.tlsParams() {
+			if value != "" && query.Get(key) == "" {
+				query.Set(key, value)
+			}
+		}
+		parsed.RawQuery = query.Encode()
+		return parsed.String()
+	}
+
+	params := []string{
+		"host=" + quoteDSN(d.Host),
+		"port=" + quoteDSN(d.Port),
+		"user=" + quoteDSN(d.User),
+		"password=" + quoteDSN(d.Password),
+		"dbname=" + quoteDSN(d.Name),
+	}
+	for _, key := range []string{"sslmode", "sslrootcert", "sslcert", "sslkey"} {
+		if value := d.tlsParams()[key]; value != "" {
+			params = append(params, key+"="+quoteDSN(value))
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+func (d Database) tlsParams() map[string]string {
+	return map[string]string{
+		"sslmode":     d.SSLMode,
+		"sslrootcert": d.SSLRootCert,
+		"sslcert":     d.SSLCert,
+		"sslkey":      d.SSLKey,
+	}
+}
+
+// quoteDSN quotes a key/value connection string value when it is empty or has spaces, quotes or backslashes
+func quoteDSN(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// Address returns the address the HTTP server listens on
+func (c *Config) Address() string {
+	return net.JoinHostPort("", c.Port)
+}
+
+// Redacted returns a copy that is safe to print or log, with every secret masked
+func (c *Config) Redacted() Config {
+	copy := *c
+	if copy.JWTSecret != "" {
+		copy.JWTSecret = redacted
+	}
+	if copy.Database.Password != "" {
+		copy.Database.Password = redacted
+	}
+	if copy.Mail.SMTPPassword != "" {
+		copy.Mail.SMTPPassword = redacted
+	}
+	if copy.Metrics.Token != "" {
+		copy.Metrics.Token = redacted
+	}
+	copy.OIDC.Providers = slices.Clone(copy.OIDC.Providers)
+	for i := range copy.OIDC.Providers {
+		if copy.OIDC.Providers[i].ClientSecret != "" {
+			copy.OIDC.Providers[i].ClientSecret = redacted
+		}
+	}
+	if copy.Database.URL != "" {
+		if parsed, err := url.Parse(copy.Database.URL); err == nil {
+			if _, ok := parsed.User.Password(); ok {
+				parsed.User = url.UserPassword(parsed.User.Username(), urlMask)
+			}
+			query := parsed.Query()
+			if query.Get("password") != "" {
+				query.Set("password", urlMask)
+				parsed.RawQuery = query.Encode()
+			}
+			copy.Database.URL = strings.ReplaceAll(parsed.String(), urlMask, redacted)
+		} else {
+			copy.Database.URL = redacted
+		}
+	}
+	return copy
+}
+
+// MarshalJSON writes durations in their readable form (e.g. "720h0m0s")
+func (c Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return json.Marshal(struct {
+		plain
+		DeletionRetention string `json:"deletion_retention"`
+	}{plain(c), c.DeletionRetention.String()})
+}
+
+// MarshalJSON writes the timeouts in their readable form
+func (s Server) MarshalJSON() ([]byte, error) {
+	type plain Server
+	return json.Marshal(struct {
+		plain
+		ReadTimeout        string `json:"read_timeout"`
+		WriteTimeout       string `json:"write_timeout"`
+		IdleTimeout        string `json:"idle_timeout"`
+		ShutdownTimeout    string `json:"shutdown_timeout"`
+		ShutdownDrainDelay string `json:"shutdown_drain_delay"`
+	}{plain(s), s.ReadTimeout.String(), s.WriteTimeout.String(), s.IdleTimeout.String(), s.ShutdownTimeout.String(), s.ShutdownDrainDelay.String()})
+}
+
+// MarshalJSON writes the pool durations in their readable form
+func (d Database) MarshalJSON() ([]byte, error) {
+	type plain Database
+	return json.Marshal(struct {
+		plain
+		ConnMaxLifetime string `json:"conn_max_lifetime"`
+		ConnMaxIdleTime string `json:"conn_max_idle_time"`
+	}{plain(d), d.ConnMaxLifetime.String(), d.ConnMaxIdleTime.String()})
+}
+
+// MarshalJSON writes the queue timeout in its readable form
+func (h Hashing) MarshalJSON() ([]byte, error) {
+	type plain Hashing
+	return json.Marshal(struct {
+		plain
+		QueueTimeout string `json:"queue_timeout"`
+	}{plain(h), h.QueueTimeout.String()})
+}
+
+// MarshalJSON writes the token lifetimes in their readable form
+func (o OAuth) MarshalJSON() ([]byte, error) {
+	type plain OAuth
+	return json.Marshal(struct {
+		plain
+		AccessTokenLifetime  string `json:"access_token_lifetime"`
+		RefreshTokenLifetime string `json:"refresh_token_lifetime"`
+	}{plain(o), o.AccessTokenLifetime.String(), o.RefreshTokenLifetime.String()})
+}
+
+// MarshalJSON writes the preflight cache duration in its readable form
+func (c CORS) MarshalJSON() ([]byte, error) {
+	type plain CORS
+	return json.Marshal(struct {
+		plain
+		MaxAge string `json:"max_age"`
+	}{plain(c), c.MaxAge.String()})
+}
+
+// MarshalJSON writes the HSTS max age in its readable form
+func (h SecurityHeaders) MarshalJSON() ([]byte, error) {
+	type plain SecurityHeaders
+	return json.Marshal(struct {
+		plain
+		HSTSMaxAge string `json:"hsts_max_age"`
+	}{plain(h), h.HSTSMaxAge.String()})
+}
+
+// String renders the redacted configuration, so printing a Config never leaks secrets
+func (c *Config) String() string {
+	data, err := json.Marshal(c.Redacted())
+	if err != nil {
+		return "config: " + err.Error()
+	}
+	return string(data)
+}
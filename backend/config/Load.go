@@ -0,0 +1,360 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Options selects the sources Load reads on top of the process environment
+type Options struct {
+	// File is an optional YAML (.yaml, .yml) or TOML (.toml) file, CONFIG_FILE is used when empty
+	File string
+	// EnvFile is the dotenv file, ".env" when empty. A missing one is ignored.
+	EnvFile string
+	// Lookup reads the process environment, os.LookupEnv when nil
+	Lookup func(key string) (string, bool)
+}
+
+// setting maps one configuration value to its environment variable and its key in the config file
+type setting struct {
+	env  string
+	file string
+	set  func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"PORT", "port", func(c *Config, v string) error { c.Port = v; return nil }},
+	{"JWT_SECRET", "jwt_secret", func(c *Config, v string) error { c.JWTSecret = v; return nil }},
+	{"DELETION_RETENTION", "deletion_retention", func(c *Config, v string) (err error) {
+		c.DeletionRetention, err = time.ParseDuration(v)
+		return err
+	}},
+	{"LOG_LEVEL", "log_level", func(c *Config, v string) error { return c.LogLevel.UnmarshalText([]byte(v)) }},
+	{"HTTP_READ_TIMEOUT", "server.read_timeout", func(c *Config, v string) (err error) {
+		c.Server.ReadTimeout, err = time.ParseDuration(v)
+		return err
+	}},
+	{"HTTP_WRITE_TIMEOUT", "server.write_timeout", func(c *Config, v string) (err error) {
+		c.Server.WriteTimeout, err = time.ParseDuration(v)
+		return err
+	}},
+	{"HTTP_IDLE_TIMEOUT", "server.idle_timeout", func(c *Config, v string) (err error) {
+		c.Server.IdleTimeout, err = time.ParseDuration(v)
+		return err
+	}},
+	{"SHUTDOWN_TIMEOUT", "server.shutdown_timeout", func(c *Config, v string) (err error) {
+		c.Server.ShutdownTimeout, err = time.ParseDuration(v)
+		return err
+	}},
+	{"SHUTDOWN_DRAIN_DELAY", "server.shutdown_drain_delay", func(c *Config, v string) (err error) {
+		c.Server.ShutdownDrainDelay, err = time.ParseDuration(v)
+		return err
+	}},
+	{"HTTP_TRUSTED_PROXIES", "server.trusted_proxies", func(c *Config, v string) error {
+		c.Server.TrustedProxies = splitList(v)
+		return nil
+	}},
+	{"DB_DRIVER", "database.driver", func(c *Config, v string) error { c.Database.Driver = v; return nil }},
+	{"DB_PATH", "database.path", func(c *Config, v string) error { c.Database.Path = v; return nil }},
+	{"DATABASE_URL", "database.url", func(c *Config, v string) error { c.Database.URL = v; return nil }},
+	{"DB_HOST", "database.host", func(c *Config, v string) error { c.Database.Host = v; return nil }},
+	{"DB_PORT", "database.port", func(c *Config, v string) error { c.Database.Port = v; return nil }},
+	{"DB_USER", "database.user", func(c *Config, v string) error { c.Database.User = v; return nil }},
+	{"DB_PASSWORD", "database.password", func(c *Config, v string) error { c.Database.Password = v; return nil }},
+	{"DB_NAME", "database.name", func(c *Config, v string) error { c.Database.Name = v; return nil }},
+	{"DB_SSLMODE", "database.sslmode", func(c *Config, v string) error { c.Database.SSLMode = v; return nil }},
+	{"DB_SSLROOTCERT", "database.sslrootcert", func(c *Config, v string) error { c.Database.SSLRootCert = v; return nil }},
+	{"DB_SSLCERT", "database.sslcert", func(c *Config, v string) error { c.Database.SSLCert = v; return nil }},
+	{"DB_SSLKEY", "database.sslkey", func(c *Config, v string) error { c.Database.SSLKey = v; return nil }},
+	{"DB_MAX_OPEN_CONNS", "database.max_open_conns", func(c *Config, v string) (err error) {
+		c.Database.MaxOpenConns, err = strconv.Atoi(v)
+		return err
+	}},
+	{"DB_MAX_IDLE_CONNS", "database.max_idle_conns", func(c *Config, v string) (err error) {
+		c.Database.MaxIdleConns, err = strconv.Atoi(v)
+		return err
+	}},
+	{"DB_CONN_MAX_LIFETIME", "database.conn_max_lifetime", func(c *Config, v string) (err error) {
+		c.Database.ConnMaxLifetime, err = time.ParseDuration(v)
+		return err
+	}},
+	{"DB_CONN_MAX_IDLE_TIME", "database.conn_max_idle_time", func(c *Config, v string) (err error) {
+		c.Database.ConnMaxIdleTime, err = time.ParseDuration(v)
+		return err
+	}},
+	{"TRACING_EXPORTER", "tracing.exporter", func(c *Config, v string) error { c.Tracing.Exporter = v; return nil }},
+	{"TRACING_OTLP_ENDPOINT", "tracing.otlp_endpoint", func(c *Config, v string) error { c.Tracing.OTLPEndpoint = v; return nil }},
+	{"TRACING_SAMPLE_RATIO", "tracing.sample_ratio", func(c *Config, v string) (err error) {
+		c.Tracing.SampleRatio, err = strconv.ParseFloat(v, 64)
+		return err
+	}},
+	{"PASSWORD_HASH_ALGORITHM", "hashing.algorithm", func(c *Config, v string) error { c.Hashing.Algorithm = v; return nil }},
+	{"HASH_WORKERS", "hashing.workers", func(c *Config, v string) (err error) {
+		c.Hashing.Workers, err = strconv.Atoi(v)
+		return err
+	}},
+	{"HASH_QUEUE_TIMEOUT", "hashing.queue_timeout", func(c *Config, v string) (err error) {
+		c.Hashing.QueueTimeout, err = time.ParseDuration(v)
+		return err
+	}},
+	{"BCRYPT_COST", "hashing.bcrypt_cost", func(c *Config, v string) (err error) {
+		c.Hashing.BcryptCost, err = strconv.Atoi(v)
+		return err
+	}},
+	{"ARGON2_MEMORY", "hashing.argon2_memory", func(c *Config, v string) error {
+		memory, err := strconv.ParseUint(v, 10, 32)
+		c.Hashing.Argon2Memory = uint32(memory)
+		return err
+	}},
+	{"ARGON2_ITERATIONS", "hashing.argon2_iterations", func(c *Config, v string) error {
+		iterations, err := strconv.ParseUint(v, 10, 32)
+		c.Hashing.Argon2Iterations = uint32(iterations)
+		return err
+	}},
+	{"ARGON2_PARALLELISM", "hashing.argon2_parallelism", func(c *Config, v string) error {
+		parallelism, err := strconv.ParseUint(v, 10, 8)
+		c.Hashing.Argon2Parallelism = uint8(parallelism)
+		return err
+	}},
+	{"PASSWORD_MIN_LENGTH", "password_policy.min_length", func(c *Config, v string) (err error) {
+		c.PasswordPolicy.MinLength, err = strconv.Atoi(v)
+		return err
+	}},
+	{"PASSWORD_MAX_LENGTH", "password_policy.max_length", func(c *Config, v string) (err error) {
+		c.PasswordPolicy.MaxLength, err = strconv.Atoi(v)
+		return err
+	}},
+	{"PASSWORD_MIN_CHARACTER_CLASSES", "password_policy.min_character_classes", func(c *Config, v string) (err error) {
+		c.PasswordPolicy.MinCharacterClasses, err = strconv.Atoi(v)
+		return err
+	}},
+	{"PASSWORD_HISTORY", "password_policy.history", func(c *Config, v string) (err error) {
+		c.PasswordPolicy.History, err = strconv.Atoi(v)
+		return err
+	}},
+	{"BREACHED_PASSWORDS_DIR", "password_policy.breached_dir", func(c *Config, v string) error { c.PasswordPolicy.BreachedDir = v; return nil }},
+	{"RATE_LIMIT_STORE", "rate_limit.store", func(c *Config, v string) error { c.RateLimit.Store = v; return nil }},
+	{"METRICS_TOKEN", "metrics.token", func(c *Config, v string) error { c.Metrics.Token = v; return nil }},
+	{"METRICS_ALLOWED_IPS", "metrics.allowed_ips", func(c *Config, v string) error {
+		c.Metrics.AllowedIPs = splitList(v)
+		return nil
+	}},
+	{"CORS_ALLOWED_ORIGINS", "cors.allowed_origins", func(c *Config, v string) error {
+		c.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"CORS_ALLOW_CREDENTIALS", "cors.allow_credentials", func(c *Config, v string) (err error) {
+		c.CORS.AllowCredentials, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"CORS_MAX_AGE", "cors.max_age", func(c *Config, v string) (err error) {
+		c.CORS.MaxAge, err = time.ParseDuration(v)
+		return err
+	}},
+	{"HSTS_MAX_AGE", "security_headers.hsts_max_age", func(c *Config, v string) (err error) {
+		c.SecurityHeaders.HSTSMaxAge, err = time.ParseDuration(v)
+		return err
+	}},
+	{"HSTS_INCLUDE_SUBDOMAINS", "security_headers.hsts_include_subdomains", func(c *Config, v string) (err error) {
+		c.SecurityHeaders.HSTSIncludeSubdomains, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"FRAME_OPTIONS", "security_headers.frame_options", func(c *Config, v string) error { c.SecurityHeaders.FrameOptions = v; return nil }},
+	{"CONTENT_SECURITY_POLICY", "security_headers.content_security_policy", func(c *Config, v string) error {
+		c.SecurityHeaders.ContentSecurityPolicy = v
+		return nil
+	}},
+	{"SESSION_COOKIE_DOMAIN", "session.cookie_domain", func(c *Config, v string) error { c.Session.CookieDomain = v; return nil }},
+	{"SESSION_COOKIE_SECURE", "session.cookie_secure", func(c *Config, v string) (err error) {
+		c.Session.CookieSecure, err = strconv.ParseBool(v)
+		return err
+	}},
+	{"SESSION_COOKIE_SAMESITE", "session.cookie_samesite", func(c *Config, v string) error { c.Session.CookieSameSite = v; return nil }},
+	{"MAIL_SENDER", "mail.sender", func(c *Config, v string) error { c.Mail.Sender = v; return nil }},
+	{"MAIL_FROM", "mail.from", func(c *Config, v string) error { c.Mail.From = v; return nil }},
+	{"SMTP_HOST", "mail.smtp_host", func(c *Config, v string) error { c.Mail.SMTPHost = v; return nil }},
+	{"SMTP_PORT", "mail.smtp_port", func(c *Config, v string) (err error) {
+		c.Mail.SMTPPort, err = strconv.Atoi(v)
+		return err
+	}},
+	{"SMTP_USERNAME", "mail.smtp_username", func(c *Config, v string) error { c.Mail.SMTPUsername = v; return nil }},
+	{"SMTP_PASSWORD", "mail.smtp_password", func(c *Config, v string) error { c.Mail.SMTPPassword = v; return nil }},
+	{"OIDC_REDIRECT_BASE_URL", "oidc.redirect_base_url", func(c *Config, v string) error { c.OIDC.RedirectBaseURL = v; return nil }},
+	{"OIDC_FRONTEND_URL", "oidc.frontend_url", func(c *Config, v string) error { c.OIDC.FrontendURL = v; return nil }},
+	{"OAUTH_ISSUER", "oauth.issuer", func(c *Config, v string) error { c.OAuth.Issuer = v; return nil }},
+	{"OAUTH_SIGNING_KEY_FILE", "oauth.signing_key_file", func(c *Config, v string) error { c.OAuth.SigningKeyFile = v; return nil }},
+	{"OAUTH_ACCESS_TOKEN_LIFETIME", "oauth.access_token_lifetime", func(c *Config, v string) (err error) {
+		c.OAuth.AccessTokenLifetime, err = time.ParseDuration(v)
+		return err
+	}},
+	{"OAUTH_REFRESH_TOKEN_LIFETIME", "oauth.refresh_token_lifetime", func(c *Config, v string) (err error) {
+		c.OAuth.RefreshTokenLifetime, err = time.ParseDuration(v)
+		return err
+	}},
+	{"OIDC_PROVIDERS", "oidc.providers", func(c *Config, v string) error {
+		c.OIDC.Providers = nil
+		for _, name := range splitList(v) {
+			c.OIDC.Providers = append(c.OIDC.Providers, OIDCProvider{Name: strings.ToLower(name), Scopes: DefaultOIDCScopes})
+		}
+		return nil
+	}},
+}
+
+// oidcProviderSettings are the settings of the provider at index i of OIDC_PROVIDERS,
+// e.g. OIDC_GOOGLE_ISSUER or oidc.google.issuer in the config file
+func oidcProviderSettings(i int, name string) []setting {
+	env := "OIDC_" + strings.ToUpper(name) + "_"
+	file := "oidc." + name + "."
+	return []setting{
+		{env + "ISSUER", file + "issuer", func(c *Config, v string) error { c.OIDC.Providers[i].Issuer = v; return nil }},
+		{env + "CLIENT_ID", file + "client_id", func(c *Config, v string) error { c.OIDC.Providers[i].ClientID = v; return nil }},
+		{env + "CLIENT_SECRET", file + "client_secret", func(c *Config, v string) error { c.OIDC.Providers[i].ClientSecret = v; return nil }},
+		{env + "SCOPES", file + "scopes", func(c *Config, v string) error {
+			c.OIDC.Providers[i].Scopes = splitList(v)
+			return nil
+		}},
+	}
+}
+
+// Load builds the configuration and validates it. Later sources override earlier ones:
+// defaults, then the config file, then the .env file, then the process environment.
+func Load(options Options) (*Config, error) {
+	lookup := options.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	file := options.File
+	if file == "" {
+		file, _ = lookup("CONFIG_FILE")
+	}
+	fileValues := map[string]string{}
+	if file != "" {
+		var err error
+		if fileValues, err = readFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	envFile := options.EnvFile
+	if envFile == "" {
+		envFile = ".env"
+	}
+	dotenv, err := godotenv.Read(envFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", envFile, err)
+	}
+
+	cfg := Default()
+	var errs []error
+	apply := func(s setting) {
+		value, ok := fileValues[s.file]
+		source := file
+		delete(fileValues, s.file)
+		if v, found := dotenv[s.env]; found {
+			value, source, ok = v, envFile, true
+		}
+		if v, found := lookup(s.env); found {
+			value, source, ok = v, "environment", true
+		}
+		if !ok {
+			return
+		}
+		if err := s.set(cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s from %s: %w", s.env, source, err))
+		}
+	}
+	for _, s := range settings {
+		apply(s)
+	}
+	// The settings of each OIDC provider are only known once OIDC_PROVIDERS is read
+	for i, provider := range cfg.OIDC.Providers {
+		for _, s := range oidcProviderSettings(i, provider.Name) {
+			apply(s)
+		}
+	}
+
+	// Leftover keys are typos or settings this version doesn't know about
+	for _, key := range sortedKeys(fileValues) {
+		errs = append(errs, fmt.Errorf("unknown setting %q in %s", key, file))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile decodes a YAML or TOML file into dotted keys, e.g. "database.host"
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]interface{}, values map[string]string) {
+	for key, value := range raw {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flatten(key, value, values)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// splitList reads a comma-separated list, ignoring blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
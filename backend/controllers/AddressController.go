@@ -1,16 +1,20 @@
 package controllers
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/arthur-tragante/liven-code-test/models"
 	"github.com/arthur-tragante/liven-code-test/services"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type AddressController struct {
 	AddressService *services.AddressService
+	Logger         *slog.Logger
 }
 
 func (ctrl *AddressController) CreateAddress(c *gin.Context) {
@@ -23,11 +27,12 @@ func (ctrl *AddressController) CreateAddress(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 	address.UserID = userID
 
-	if err := ctrl.AddressService.CreateAddress(&address); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := ctrl.AddressService.CreateAddress(c, &address); err != nil {
+		internalError(c, ctrl.Logger, err)
 		return
 	}
 
+	c.Header("ETag", addressETag(&address))
 	c.JSON(http.StatusCreated, address)
 }
 
@@ -42,22 +47,22 @@ func (ctrl *AddressController) GetAddress(c *gin.Context) {
 			return
 		}
 
-		address, err := ctrl.AddressService.GetAddressByID(uint(addressID), userID)
+		address, err := ctrl.AddressService.GetAddressByID(c, uint(addressID), userID)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
 			return
 		}
-		c.JSON(http.StatusOK, address)
+		respondWithETag(c, addressETag(address), address)
 		return
 	}
 
-	addresses, err := ctrl.AddressService.GetAllAddresses(userID)
+	addresses, err := ctrl.AddressService.GetAllAddresses(c, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, ctrl.Logger, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, addresses)
+	respondWithETag(c, addressesETag(addresses), addresses)
 }
 
 func (ctrl *AddressController) UpdateAddress(c *gin.Context) {
@@ -76,12 +81,93 @@ func (ctrl *AddressController) UpdateAddress(c *gin.Context) {
 
 	userID := c.MustGet("userID").(uint)
 
-	if err := ctrl.AddressService.UpdateAddress(uint(addressID), userID, &updatedData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// The version in the body is informational, only If-Match makes the write conditional
+	updatedData.Version = 0
+	if header, ok := ifMatchHeader(c); ok {
+		current, err := ctrl.AddressService.GetAddressByID(c, uint(addressID), userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+			return
+		}
+		if !checkIfMatch(c, header, addressETag(current)) {
+			return
+		}
+		updatedData.Version = current.Version
+	}
+
+	if err := ctrl.AddressService.UpdateAddress(c, uint(addressID), userID, &updatedData); err != nil {
+		ctrl.writeError(c, err)
+		return
+	}
+
+	address, err := ctrl.AddressService.GetAddressByID(c, uint(addressID), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	c.Header("ETag", addressETag(address))
+	c.JSON(http.StatusOK, address)
+}
+
+// addressWritableFields are the members a PATCH may change, everything else in the representation is read-only
+var addressWritableFields = []string{"street", "number", "complement", "city", "state", "zipcode", "country"}
+
+func (ctrl *AddressController) PatchAddress(c *gin.Context) {
+	addressIDStr := c.Param("id")
+	addressID, err := strconv.ParseUint(addressIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uint)
+
+	current, err := ctrl.AddressService.GetAddressByID(c, uint(addressID), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	header, conditional := ifMatchHeader(c)
+	if conditional && !checkIfMatch(c, header, addressETag(current)) {
+		return
+	}
+
+	original, patched, ok := applyPatch(c, current)
+	if !ok || !checkPatchedFields(c, original, patched, addressWritableFields...) {
+		return
+	}
+
+	updatedData := models.Address{
+		Street:     patchedString(patched, "street"),
+		Number:     patchedString(patched, "number"),
+		Complement: patchedString(patched, "complement"),
+		City:       patchedString(patched, "city"),
+		State:      patchedString(patched, "state"),
+		Zipcode:    patchedString(patched, "zipcode"),
+		Country:    patchedString(patched, "country"),
+		// The patch was computed from this version, so it must not land on top of another write
+		Version: current.Version,
+	}
+
+	if err := ctrl.AddressService.UpdateAddress(c, uint(addressID), userID, &updatedData); err != nil {
+		if errors.Is(err, services.ErrVersionConflict) && !conditional {
+			c.JSON(http.StatusConflict, gin.H{"error": "Address was modified while the patch was applied, retry the request"})
+			return
+		}
+		ctrl.writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, updatedData)
+	address, err := ctrl.AddressService.GetAddressByID(c, uint(addressID), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	c.Header("ETag", addressETag(address))
+	c.JSON(http.StatusOK, address)
 }
 
 func (ctrl *AddressController) DeleteAddress(c *gin.Context) {
@@ -94,10 +180,70 @@ func (ctrl *AddressController) DeleteAddress(c *gin.Context) {
 
 	userID := c.MustGet("userID").(uint)
 
-	if err := ctrl.AddressService.DeleteAddress(uint(addressID), userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var version uint
+	if header, ok := ifMatchHeader(c); ok {
+		current, err := ctrl.AddressService.GetAddressByID(c, uint(addressID), userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+			return
+		}
+		if !checkIfMatch(c, header, addressETag(current)) {
+			return
+		}
+		version = current.Version
+	}
+
+	if err := ctrl.AddressService.DeleteAddress(c, uint(addressID), userID, version); err != nil {
+		ctrl.writeError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Address deleted successfully"})
-}
\ No newline at end of file
+}
+
+func (ctrl *AddressController) GetDeletedAddresses(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	addresses, err := ctrl.AddressService.GetDeletedAddresses(c, userID)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+
+	respondWithETag(c, addressesETag(addresses), addresses)
+}
+
+func (ctrl *AddressController) RestoreAddress(c *gin.Context) {
+	addressIDStr := c.Param("id")
+	addressID, err := strconv.ParseUint(addressIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uint)
+
+	address, err := ctrl.AddressService.RestoreAddress(c, uint(addressID), userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted address not found"})
+			return
+		}
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+
+	c.Header("ETag", addressETag(address))
+	c.JSON(http.StatusOK, address)
+}
+
+func (ctrl *AddressController) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Resource has been modified, fetch it again before retrying"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+	default:
+		internalError(c, ctrl.Logger, err)
+	}
+}
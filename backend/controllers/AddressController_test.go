@@ -2,6 +2,7 @@ package controllers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,48 +11,36 @@ import (
 
 	"github.com/arthur-tragante/liven-code-test/controllers"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
 	"github.com/arthur-tragante/liven-code-test/services"
 	"github.com/arthur-tragante/liven-code-test/testutils"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-	"gorm.io/gorm"
 )
 
 type AddressControllerTestSuite struct {
 	suite.Suite
-	TestDBSetup       *testutils.TestDBSetup
 	UserService       *services.UserService
 	AddressService    *services.AddressService
 	AddressController *controllers.AddressController
-	DB                *gorm.DB
 }
 
-func (suite *AddressControllerTestSuite) SetupSuite() {
-	suite.TestDBSetup = testutils.SetupTestDB(assert.New(suite.T()))
-	suite.DB = suite.TestDBSetup.DB
+// SetupTest gives every test an empty in-memory store
+func (suite *AddressControllerTestSuite) SetupTest() {
+	store := repositories.NewMemoryStore()
 	suite.UserService = &services.UserService{
-		DB:        suite.DB,
+		Users:     store.Users(),
 		JWTSecret: "testsecret",
 	}
 	suite.AddressService = &services.AddressService{
-		DB: suite.DB,
+		Addresses: store.Addresses(),
 	}
 	suite.AddressController = &controllers.AddressController{
 		AddressService: suite.AddressService,
 	}
 }
 
-func (suite *AddressControllerTestSuite) TearDownSuite() {
-	suite.TestDBSetup.TearDown(assert.New(suite.T()))
-}
-func (suite *AddressControllerTestSuite) SetupTest() {
-	err := suite.DB.Exec("DELETE FROM addresses").Error
-	assert.NoError(suite.T(), err)
-	err = suite.DB.Exec("DELETE FROM users").Error
-	assert.NoError(suite.T(), err)
-}
-
 func (suite *AddressControllerTestSuite) TestCreateAddress_Success() {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -62,7 +51,7 @@ func (suite *AddressControllerTestSuite) TestCreateAddress_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -105,34 +94,10 @@ func (suite *AddressControllerTestSuite) TestCreateAddress_InvalidJSON() {
 }
 
 func (suite *AddressControllerTestSuite) TestGetAddress_Success() {
-	w := httptest.NewRecorder()
-	c, _ := gin.CreateTestContext(w)
-
-	user := &models.User{
-		Name:     "Jane Doe",
-		Email:    "jane.doe@example.com",
-		Password: "password123",
-	}
-
-	err := suite.UserService.Register(user)
-	assert.NoError(suite.T(), err)
+	user := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	address := testutils.CreateAddress(suite.T(), suite.AddressService.Addresses, user.ID)
 
-	address := &models.Address{
-		UserID:     user.ID,
-		Street:     "123 Test St",
-		Number:     "1",
-		Complement: "Apt 1",
-		City:       "Test City",
-		State:      "Test State",
-		Zipcode:    "12345",
-		Country:    "Test Country",
-	}
-
-	err = suite.AddressService.CreateAddress(address)
-	assert.NoError(suite.T(), err)
-
-	c.Request, _ = http.NewRequest("GET", fmt.Sprintf("/address/%d", address.AddressID), nil)
-	c.Set("userID", user.ID)
+	c, w := testutils.AuthenticatedRequest(suite.T(), user, "GET", fmt.Sprintf("/address/%d", address.AddressID), nil)
 	c.Params = gin.Params{{Key: "id", Value: fmt.Sprintf("%d", address.AddressID)}}
 
 	suite.AddressController.GetAddress(c)
@@ -140,7 +105,7 @@ func (suite *AddressControllerTestSuite) TestGetAddress_Success() {
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
 
 	var fetchedAddress models.Address
-	err = json.Unmarshal(w.Body.Bytes(), &fetchedAddress)
+	err := json.Unmarshal(w.Body.Bytes(), &fetchedAddress)
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), address.Street, fetchedAddress.Street)
 }
@@ -181,7 +146,7 @@ func (suite *AddressControllerTestSuite) TestUpdateAddress_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -195,7 +160,7 @@ func (suite *AddressControllerTestSuite) TestUpdateAddress_Success() {
 		Country:    "Test Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address)
+	err = suite.AddressService.CreateAddress(context.Background(), address)
 	assert.NoError(suite.T(), err)
 
 	updatedData := &models.Address{
@@ -261,7 +226,7 @@ func (suite *AddressControllerTestSuite) TestDeleteAddress_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -275,7 +240,7 @@ func (suite *AddressControllerTestSuite) TestDeleteAddress_Success() {
 		Country:    "Test Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address)
+	err = suite.AddressService.CreateAddress(context.Background(), address)
 	assert.NoError(suite.T(), err)
 
 	c.Request, _ = http.NewRequest("DELETE", fmt.Sprintf("/address/%d", address.AddressID), nil)
@@ -313,6 +278,312 @@ func (suite *AddressControllerTestSuite) TestDeleteAddress_NotFound() {
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
 }
 
+func (suite *AddressControllerTestSuite) TestGetDeletedAddresses_Success() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	user := &models.User{
+		Name:     "Joe Doe",
+		Email:    "joe.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{
+		UserID: user.ID,
+		Street: "123 Test St",
+		City:   "Test City",
+	}
+
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+	err = suite.AddressService.DeleteAddress(context.Background(), address.AddressID, user.ID, 0)
+	assert.NoError(suite.T(), err)
+
+	c.Request, _ = http.NewRequest("GET", "/address/trash", nil)
+	c.Set("userID", user.ID)
+
+	suite.AddressController.GetDeletedAddresses(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var trash []models.Address
+	err = json.Unmarshal(w.Body.Bytes(), &trash)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), trash, 1)
+	assert.Equal(suite.T(), address.AddressID, trash[0].AddressID)
+}
+
+func (suite *AddressControllerTestSuite) TestRestoreAddress_Success() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	user := &models.User{
+		Name:     "Joan Doe",
+		Email:    "joan.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{
+		UserID: user.ID,
+		Street: "123 Test St",
+		City:   "Test City",
+	}
+
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+	err = suite.AddressService.DeleteAddress(context.Background(), address.AddressID, user.ID, 0)
+	assert.NoError(suite.T(), err)
+
+	c.Request, _ = http.NewRequest("POST", fmt.Sprintf("/address/%d/restore", address.AddressID), nil)
+	c.Set("userID", user.ID)
+	c.Params = gin.Params{{Key: "id", Value: fmt.Sprintf("%d", address.AddressID)}}
+
+	suite.AddressController.RestoreAddress(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var restored models.Address
+	err = json.Unmarshal(w.Body.Bytes(), &restored)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), address.Street, restored.Street)
+}
+
+func (suite *AddressControllerTestSuite) TestRestoreAddress_NotFound() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request, _ = http.NewRequest("POST", "/address/999/restore", nil)
+	c.Set("userID", uint(1))
+	c.Params = gin.Params{{Key: "id", Value: "999"}}
+
+	suite.AddressController.RestoreAddress(c)
+
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+
+func (suite *AddressControllerTestSuite) TestAddressConditionalRequests() {
+	user := &models.User{
+		Name:     "Jules Doe",
+		Email:    "jules.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{
+		UserID: user.ID,
+		Street: "123 Test St",
+		City:   "Test City",
+	}
+
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+
+	idParam := gin.Params{{Key: "id", Value: fmt.Sprintf("%d", address.AddressID)}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", fmt.Sprintf("/address/%d", address.AddressID), nil)
+	c.Set("userID", user.ID)
+	c.Params = idParam
+
+	suite.AddressController.GetAddress(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(suite.T(), etag)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", fmt.Sprintf("/address/%d", address.AddressID), nil)
+	c.Request.Header.Set("If-None-Match", etag)
+	c.Set("userID", user.ID)
+	c.Params = idParam
+
+	suite.AddressController.GetAddress(c)
+
+	assert.Equal(suite.T(), http.StatusNotModified, w.Code)
+	assert.Empty(suite.T(), w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", fmt.Sprintf("/address/%d", address.AddressID), bytes.NewBufferString(`{"street":"First Writer St"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", etag)
+	c.Set("userID", user.ID)
+	c.Params = idParam
+
+	suite.AddressController.UpdateAddress(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.NotEqual(suite.T(), etag, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", fmt.Sprintf("/address/%d", address.AddressID), bytes.NewBufferString(`{"street":"Second Writer St"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", etag)
+	c.Set("userID", user.ID)
+	c.Params = idParam
+
+	suite.AddressController.UpdateAddress(c)
+
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, w.Code)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", fmt.Sprintf("/address/%d", address.AddressID), nil)
+	c.Request.Header.Set("If-Match", etag)
+	c.Set("userID", user.ID)
+	c.Params = idParam
+
+	suite.AddressController.DeleteAddress(c)
+
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, w.Code)
+
+	stored, err := suite.AddressService.GetAddressByID(context.Background(), address.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "First Writer St", stored.Street)
+	assert.Equal(suite.T(), uint(2), stored.Version)
+}
+
+func (suite *AddressControllerTestSuite) TestPatchAddress() {
+	user := &models.User{
+		Name:     "Jude Doe",
+		Email:    "jude.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{
+		UserID:     user.ID,
+		Street:     "123 Test St",
+		Number:     "1",
+		Complement: "Apt 1",
+		City:       "Test City",
+	}
+
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Merge Patch Clears Complement",
+			contentType:    "application/merge-patch+json",
+			body:           `{"complement": null, "city": "Patched City"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON Patch Replaces Street",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "test", "path": "/city", "value": "Patched City"}, {"op": "replace", "path": "/street", "value": "Patched St"}]`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "JSON Patch Failed Test",
+			contentType:    "application/json-patch+json",
+			body:           `[{"op": "test", "path": "/city", "value": "Other City"}]`,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Read-only Field",
+			contentType:    "application/merge-patch+json",
+			body:           `{"user_id": 999}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Unsupported Media Type",
+			contentType:    "application/json",
+			body:           `{"city": "Other City"}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("PATCH", fmt.Sprintf("/address/%d", address.AddressID), bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", tt.contentType)
+			c.Set("userID", user.ID)
+			c.Params = gin.Params{{Key: "id", Value: fmt.Sprintf("%d", address.AddressID)}}
+
+			suite.AddressController.PatchAddress(c)
+
+			assert.Equal(suite.T(), tt.expectedStatus, w.Code)
+		})
+	}
+
+	patched, err := suite.AddressService.GetAddressByID(context.Background(), address.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Patched St", patched.Street)
+	assert.Equal(suite.T(), "1", patched.Number)
+	assert.Equal(suite.T(), "", patched.Complement)
+	assert.Equal(suite.T(), "Patched City", patched.City)
+	assert.Equal(suite.T(), user.ID, patched.UserID)
+}
+
+func (suite *AddressControllerTestSuite) TestImportAddresses_CSV() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	user := &models.User{
+		Name:     "Jay Doe",
+		Email:    "jay.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	document := "Rua,city,state,zipcode,country\n" +
+		"Main St,Springfield,IL,62701,USA\n" +
+		",Shelbyville,IL,62565,USA\n"
+
+	c.Request, _ = http.NewRequest("POST", "/address/import?mode=best-effort&mapping=street:Rua", bytes.NewBufferString(document))
+	c.Request.Header.Set("Content-Type", "text/csv")
+	c.Set("userID", user.ID)
+
+	suite.AddressController.ImportAddresses(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var result services.ImportResult
+	err = json.Unmarshal(w.Body.Bytes(), &result)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, result.Created)
+	assert.Equal(suite.T(), []string{"street is required"}, result.Rows[1].Errors)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/address/export?format=ndjson", nil)
+	c.Set("userID", user.ID)
+
+	suite.AddressController.ExportAddresses(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var exported models.Address
+	err = json.Unmarshal(w.Body.Bytes(), &exported)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Main St", exported.Street)
+}
+
 func TestAddressControllerTestSuite(t *testing.T) {
 	suite.Run(t, new(AddressControllerTestSuite))
 }
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// maxImportBodySize bounds the uploaded document of an address import
+const maxImportBodySize = 10 << 20
+
+var importContentTypes = map[string]string{
+	"text/csv":             services.FormatCSV,
+	"application/x-ndjson": services.FormatNDJSON,
+	"application/jsonl":    services.FormatNDJSON,
+}
+
+var exportContentTypes = map[string]string{
+	services.FormatCSV:    "text/csv; charset=utf-8",
+	services.FormatNDJSON: "application/x-ndjson",
+}
+
+// ImportAddresses creates addresses from a CSV or NDJSON upload. The "mode" query parameter selects
+// all-or-nothing (default) or best-effort, and "mapping" renames source columns (field:column,...).
+func (ctrl *AddressController) ImportAddresses(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	format, ok := importContentTypes[mediaType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be text/csv or application/x-ndjson"})
+		return
+	}
+
+	mode := services.ImportMode(c.DefaultQuery("mode", string(services.ImportAllOrNothing)))
+	if mode != services.ImportAllOrNothing && mode != services.ImportBestEffort {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be all-or-nothing or best-effort"})
+		return
+	}
+
+	mapping, err := services.ParseColumnMapping(c.Query("mapping"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBodySize)
+	var rows []services.ImportRow
+	if format == services.FormatCSV {
+		rows, err = services.ParseAddressCSV(body, mapping)
+	} else {
+		rows, err = services.ParseAddressNDJSON(body, mapping)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import document is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import document has no rows"})
+		return
+	}
+
+	result, err := ctrl.AddressService.ImportAddresses(c, userID, rows, mode)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+
+	status := http.StatusOK
+	if !result.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, result)
+}
+
+// ExportAddresses streams the user's addresses as CSV or NDJSON, chosen with the "format" query
+// parameter or the Accept header
+func (ctrl *AddressController) ExportAddresses(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	format := c.Query("format")
+	if format == "" {
+		format = services.FormatCSV
+		if strings.Contains(c.GetHeader("Accept"), "ndjson") {
+			format = services.FormatNDJSON
+		}
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="addresses.`+format+`"`)
+	c.Status(http.StatusOK)
+
+	if err := ctrl.AddressService.ExportAddresses(c, userID, format, c.Writer); err != nil {
+		// The status line is already out, so the best we can do is cut the stream short
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
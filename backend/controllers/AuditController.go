@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// Page sizes of the audit event lists, set with the "limit" query parameter
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+type AuditController struct {
+	AuditService *services.AuditService
+	Logger       *slog.Logger
+}
+
+// ListActivity returns the audit events of the user's own account, newest first. The "before_id"
+// query parameter pages to the events older than the given one.
+func (ctrl *AuditController) ListActivity(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	filter := services.AuditFilter{UserID: userID}
+	limit, err := parseAuditPage(c, &filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := ctrl.AuditService.ListEvents(c, filter, limit)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// ListEvents searches the whole audit log for admins, filtered by the user_id, actor_id, action,
+// target_type, target_id, from and to (RFC 3339) query parameters and paged like ListActivity.
+// With format=ndjson or an Accept header asking for NDJSON, every matching event is streamed instead.
+func (ctrl *AuditController) ListEvents(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" && strings.Contains(c.GetHeader("Accept"), "ndjson") {
+		format = services.FormatNDJSON
+	}
+	if format == services.FormatNDJSON {
+		c.Header("Content-Type", exportContentTypes[services.FormatNDJSON])
+		c.Header("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+		c.Status(http.StatusOK)
+
+		if err := ctrl.AuditService.ExportEvents(c, filter, c.Writer); err != nil {
+			// The status line is already out, so the best we can do is cut the stream short
+			_ = c.Error(err)
+			c.Abort()
+		}
+		return
+	}
+	if format != "" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or ndjson"})
+		return
+	}
+
+	limit, err := parseAuditPage(c, &filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := ctrl.AuditService.ListEvents(c, filter, limit)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+func parseAuditFilter(c *gin.Context) (services.AuditFilter, error) {
+	filter := services.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+	var err error
+	if filter.UserID, err = parseIDQuery(c, "user_id"); err != nil {
+		return filter, err
+	}
+	if filter.ActorID, err = parseIDQuery(c, "actor_id"); err != nil {
+		return filter, err
+	}
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseAuditPage sets the cursor of the filter and returns the page size
+func parseAuditPage(c *gin.Context, filter *services.AuditFilter) (int, error) {
+	var err error
+	if filter.BeforeID, err = parseIDQuery(c, "before_id"); err != nil {
+		return 0, err
+	}
+	limit := defaultAuditPageSize
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			return 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxAuditPageSize))
+		}
+	}
+	return limit, nil
+}
+
+func parseIDQuery(c *gin.Context, name string) (uint, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New(name + " must be a positive integer")
+	}
+	return uint(id), nil
+}
+
+func parseTimeQuery(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be an RFC 3339 time like 2024-01-31T12:00:00Z")
+	}
+	return t, nil
+}
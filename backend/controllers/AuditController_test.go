@@ -0,0 +1,113 @@
+package controllers_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+func TestAuditEndpoints(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	audit := &services.AuditService{Events: store.Audit()}
+	userService := &services.UserService{Users: store.Users(), JWTSecret: "testsecret", Audit: audit}
+	userController := &controllers.UserController{UserService: userService}
+	auditController := &controllers.AuditController{AuditService: audit}
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(middlewares.RequestIDMiddleware())
+	r.POST("/login", userController.LoginUser)
+	userGroup := r.Group("/user", middlewares.AuthMiddleware(userService.JWTSecret, userService))
+	userGroup.PUT("/", userController.UpdateUser)
+	userGroup.GET("/activity", auditController.ListActivity)
+	adminGroup := r.Group("/admin", middlewares.AuthMiddleware(userService.JWTSecret, userService), middlewares.RoleMiddleware(userService, models.RoleAdmin))
+	adminGroup.GET("/audit-events", auditController.ListEvents)
+
+	login := func(email string) string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"email": "`+email+`", "password": "password123"}`)))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response["token"]
+	}
+	send := func(method, path, token, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	ctx := context.Background()
+	jane := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "password123"}
+	admin := &models.User{Name: "Admin", Email: "admin@example.com", Password: "password123"}
+	require.NoError(t, userService.Register(ctx, jane))
+	require.NoError(t, userService.Register(ctx, admin))
+	require.NoError(t, userService.SetRole(ctx, admin.ID, models.RoleAdmin))
+
+	janeToken := login(jane.Email)
+	w := send("PUT", "/user/", janeToken, `{"name": "Jane Smith", "email": "jane.doe@example.com"}`, "X-Request-ID", "req-update")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = send("GET", "/user/activity", janeToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var activity []models.AuditEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &activity))
+	require.Len(t, activity, 3)
+	assert.Equal(t, services.AuditUserUpdated, activity[0].Action)
+	assert.Equal(t, "req-update", activity[0].RequestID)
+	assert.Equal(t, "192.0.2.1", activity[0].IP)
+	assert.Equal(t, models.AuditChanges{"name": {Before: "Jane Doe", After: "Jane Smith"}}, activity[0].Changes)
+	assert.Equal(t, services.AuditLogin, activity[1].Action)
+	assert.Equal(t, services.AuditUserRegistered, activity[2].Action)
+
+	w = send("GET", "/user/activity?limit=1&before_id="+fmt.Sprint(activity[0].ID), janeToken, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &activity))
+	require.Len(t, activity, 1)
+	assert.Equal(t, services.AuditLogin, activity[0].Action)
+	assert.Equal(t, http.StatusBadRequest, send("GET", "/user/activity?limit=0", janeToken, "").Code)
+
+	// Only admins search the whole log
+	assert.Equal(t, http.StatusForbidden, send("GET", "/admin/audit-events", janeToken, "").Code)
+	adminToken := login(admin.Email)
+
+	w = send("GET", "/admin/audit-events?action=user.login", adminToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var events []models.AuditEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+	require.Len(t, events, 2)
+	assert.Equal(t, admin.ID, *events[0].UserID)
+	assert.Equal(t, jane.ID, *events[1].UserID)
+	assert.Equal(t, http.StatusBadRequest, send("GET", "/admin/audit-events?from=yesterday", adminToken, "").Code)
+
+	w = send("GET", "/admin/audit-events?user_id="+fmt.Sprint(jane.ID), adminToken, "", "Accept", "application/x-ndjson")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	var exported []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var event models.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		exported = append(exported, event.Action)
+	}
+	assert.Equal(t, []string{services.AuditUserUpdated, services.AuditLogin, services.AuditUserRegistered}, exported)
+}
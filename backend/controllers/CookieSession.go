@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// startCookieSession hands the token over in the HttpOnly session cookie instead of the body, and returns
+// the CSRF token both in the body and in a cookie JavaScript can read, for the double-submit check
+func (ctrl *UserController) startCookieSession(c *gin.Context, token string) {
+	csrfToken, err := ctrl.setSessionCookies(c, token)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"csrf_token": csrfToken, "expires_at": time.Now().Add(services.TokenLifetime)})
+}
+
+// setSessionCookies sets the cookies of a cookie session for the token and returns its CSRF token
+func (ctrl *UserController) setSessionCookies(c *gin.Context, token string) (string, error) {
+	claims, err := ctrl.UserService.ParseToken(token)
+	if err != nil {
+		return "", err
+	}
+	tokenID, _ := claims["jti"].(string)
+	csrfToken := services.CSRFToken(ctrl.UserService.JWTSecret, tokenID)
+
+	maxAge := int(services.TokenLifetime.Seconds())
+	http.SetCookie(c.Writer, ctrl.sessionCookie(middlewares.SessionCookie, token, maxAge, true))
+	http.SetCookie(c.Writer, ctrl.sessionCookie(middlewares.CSRFCookie, csrfToken, maxAge, false))
+	return csrfToken, nil
+}
+
+func (ctrl *UserController) clearCookieSession(c *gin.Context) {
+	http.SetCookie(c.Writer, ctrl.sessionCookie(middlewares.SessionCookie, "", -1, true))
+	http.SetCookie(c.Writer, ctrl.sessionCookie(middlewares.CSRFCookie, "", -1, false))
+}
+
+func (ctrl *UserController) sessionCookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	sameSite := http.SameSiteStrictMode
+	switch ctrl.Session.CookieSameSite {
+	case config.SameSiteLax:
+		sameSite = http.SameSiteLaxMode
+	case config.SameSiteNone:
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   ctrl.Session.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   ctrl.Session.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
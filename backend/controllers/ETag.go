@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/gin-gonic/gin"
+)
+
+func addressETag(address *models.Address) string {
+	return fmt.Sprintf(`"a%d-%d"`, address.AddressID, address.Version)
+}
+
+// addressesETag identifies a list of addresses by the id and version of each entry
+func addressesETag(addresses []models.Address) string {
+	hash := sha256.New()
+	for _, address := range addresses {
+		fmt.Fprintf(hash, "%d:%d;", address.AddressID, address.Version)
+	}
+	return `"l` + hex.EncodeToString(hash.Sum(nil))[:16] + `"`
+}
+
+// userETag also covers the preloaded addresses, since they are part of the user representation
+func userETag(user *models.User) string {
+	return fmt.Sprintf(`"u%d-%d-%s"`, user.ID, user.Version, strings.Trim(addressesETag(user.Addresses), `"`))
+}
+
+// etagMatches reports whether an If-Match / If-None-Match header lists the given ETag.
+// If-None-Match uses the weak comparison, If-Match the strong one (RFC 9110, section 8.8.3.2).
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithETag writes body with its ETag, or a bare 304 when the client already holds that version
+func respondWithETag(c *gin.Context, etag string, body interface{}) {
+	c.Header("ETag", etag)
+	if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// ifMatchHeader returns the If-Match header and whether the request carries one
+func ifMatchHeader(c *gin.Context) (string, bool) {
+	header := c.GetHeader("If-Match")
+	return header, header != ""
+}
+
+// checkIfMatch answers 412 and returns false when the request's If-Match doesn't list the current ETag
+func checkIfMatch(c *gin.Context, header, etag string) bool {
+	if etagMatches(header, etag, false) {
+		return true
+	}
+	c.Header("ETag", etag)
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Resource has been modified, fetch it again before retrying"})
+	return false
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// internalError logs an unexpected error with the request context before answering 500
+func internalError(c *gin.Context, logger *slog.Logger, err error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.ErrorContext(c, "request failed", "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// hashingUnavailable answers 503 when the password could not be hashed in time, because the hashing
+// workers are saturated or the client went away while waiting for one. It reports whether it answered.
+func hashingUnavailable(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrHashingBusy) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is busy, retry shortly"})
+	return true
+}
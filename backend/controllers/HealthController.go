@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/migrations"
+)
+
+// readinessTimeout bounds the checks, so a stuck database fails the probe instead of hanging it
+const readinessTimeout = 2 * time.Second
+
+// HealthController answers the liveness and readiness probes of the orchestrator
+type HealthController struct {
+	DB       *gorm.DB
+	Migrator *migrations.Migrator
+	draining atomic.Bool
+}
+
+// Drain makes the readiness probe fail, so no new traffic is routed to the instance while it shuts down
+func (h *HealthController) Drain() {
+	h.draining.Store(true)
+}
+
+// Liveness only tells that the process is serving requests, it must not depend on the database
+// or a database outage would get every instance restarted
+func (h *HealthController) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness tells whether the instance can serve traffic: the database answers and its schema is up to date
+func (h *HealthController) Readiness(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	checks := gin.H{"database": "ok", "migrations": "ok"}
+
+	sqlDB, err := h.DB.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		ready = false
+		checks["database"] = err.Error()
+	}
+
+	pending, err := h.Migrator.WithContext(ctx).Pending()
+	if err != nil {
+		ready = false
+		checks["migrations"] = err.Error()
+	} else if pending > 0 {
+		ready = false
+		checks["migrations"] = fmt.Sprintf("%d pending", pending)
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
@@ -0,0 +1,57 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/migrations"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+func probe(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/readyz", nil)
+	handler(c)
+	return w
+}
+
+func TestReadiness(t *testing.T) {
+	db := testutils.NewTestDB(t)
+	migrator, err := migrations.New(db)
+	require.NoError(t, err)
+	health := &controllers.HealthController{DB: db, Migrator: migrator}
+
+	assert.Equal(t, http.StatusOK, probe(health.Liveness).Code)
+	assert.Equal(t, http.StatusOK, probe(health.Readiness).Code)
+
+	// A binary shipping a migration the database doesn't have yet must not get traffic
+	ahead := &migrations.Migrator{DB: db, Migrations: append(migrator.Migrations, migrations.Migration{Version: 9999, Name: "future"})}
+	w := probe((&controllers.HealthController{DB: db, Migrator: ahead}).Readiness)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "1 pending")
+
+	health.Drain()
+	assert.Equal(t, http.StatusServiceUnavailable, probe(health.Readiness).Code)
+	assert.Equal(t, http.StatusOK, probe(health.Liveness).Code)
+}
+
+func TestReadinessWithoutDatabase(t *testing.T) {
+	db := testutils.NewTestDB(t)
+	migrator, err := migrations.New(db)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	w := probe((&controllers.HealthController{DB: db, Migrator: migrator}).Readiness)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "closed")
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"html/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// consentPageLifetime is how long the user has to submit the consent page
+const consentPageLifetime = 30 * time.Minute
+
+// consentPagePolicy replaces the API's Content-Security-Policy on the HTML pages to allow their inline style
+const consentPagePolicy = "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'"
+
+var scopeDescriptions = map[string]string{
+	services.ScopeOpenID:  "Know who you are on this account",
+	services.ScopeProfile: "See your name",
+	services.ScopeEmail:   "See your email address",
+}
+
+type consentPage struct {
+	Client    *models.OAuthClient
+	Request   services.AuthorizationRequest
+	Scopes    []string
+	Login     bool
+	Message   string
+	CSRFToken string
+}
+
+func describeScopes(scopes models.StringList) []string {
+	descriptions := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if description, ok := scopeDescriptions[scope]; ok {
+			descriptions = append(descriptions, description)
+		}
+	}
+	return descriptions
+}
+
+const pageStyle = `<style>
+body { font-family: sans-serif; max-width: 28rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+label, input { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25rem 0 1rem; padding: .5rem; }
+button { padding: .5rem 1.5rem; margin-right: .5rem; }
+.message { color: #b00020; }
+</style>`
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Authorize {{.Client.Name}}</title>` + pageStyle + `</head>
+<body>
+<h1>Authorize {{.Client.Name}}</h1>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+<p>{{.Client.Name}} would like to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+{{if .Login}}<input type="hidden" name="login" value="true">
+<label>Email <input type="email" name="email" autocomplete="username"></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>{{end}}
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+var oauthErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Authorization failed</title>` + pageStyle + `</head>
+<body>
+<h1>Authorization failed</h1>
+<p class="message">{{.}}</p>
+</body>
+</html>
+`))
+
+func renderConsentPage(c *gin.Context, status int, page consentPage) {
+	renderPage(c, status, consentTemplate, page)
+}
+
+// renderOAuthError shows an error the client can't be told about, when the request doesn't say where to send it
+func renderOAuthError(c *gin.Context, status int, message string) {
+	renderPage(c, status, oauthErrorTemplate, message)
+}
+
+func renderPage(c *gin.Context, status int, page *template.Template, data any) {
+	c.Header("Content-Security-Policy", consentPagePolicy)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := page.Execute(c.Writer, data); err != nil {
+		_ = c.Error(err)
+	}
+}
@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// authorizeCookie ties the consent form to the browser it was shown to, see authorizeCSRFToken
+const authorizeCookie = "liven_authorize"
+
+// OAuthController serves the endpoints of the OpenID Connect provider
+type OAuthController struct {
+	OAuthService *services.OAuthService
+	Logger       *slog.Logger
+	// Session sets the attributes of the cookies, the authorization endpoint recognizes cookie sessions
+	Session config.Session
+}
+
+func (ctrl *OAuthController) logger() *slog.Logger {
+	if ctrl.Logger == nil {
+		return slog.Default()
+	}
+	return ctrl.Logger
+}
+
+// Discovery serves the OpenID Connect discovery document
+func (ctrl *OAuthController) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, ctrl.OAuthService.Discovery())
+}
+
+// JWKS serves the public keys the tokens are signed with
+func (ctrl *OAuthController) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, ctrl.OAuthService.JWKS())
+}
+
+// Authorize is where clients send users to get an authorization code. A user with a cookie session who
+// already consented to the scopes is sent straight back, the others get the consent page, which also
+// asks for their credentials when there is no session or the client wants a fresh login (prompt=login).
+func (ctrl *OAuthController) Authorize(c *gin.Context) {
+	req := authorizationRequest(c.Query)
+	client, scopes, ok := ctrl.checkAuthorization(c, req)
+	if !ok {
+		return
+	}
+
+	prompt := c.Query("prompt")
+	userID, authTime, loggedIn := ctrl.sessionUser(c)
+	if loggedIn && prompt != "login" && prompt != "consent" {
+		consented, err := ctrl.OAuthService.HasConsent(c, userID, client.ClientID, scopes)
+		if err != nil {
+			internalError(c, ctrl.Logger, err)
+			return
+		}
+		if consented {
+			ctrl.authorize(c, http.StatusFound, userID, authTime, client, req, scopes)
+			return
+		}
+	}
+	if prompt == "none" {
+		code, description := services.OAuthConsentRequired, "the user must consent"
+		if !loggedIn {
+			code, description = services.OAuthLoginRequired, "the user must log in"
+		}
+		c.Redirect(http.StatusFound, ctrl.OAuthService.RedirectWithError(req.RedirectURI, req.State, &services.OAuthError{Code: code, Description: description}))
+		return
+	}
+
+	ctrl.renderConsent(c, http.StatusOK, client, req, scopes, !loggedIn || prompt == "login", "")
+}
+
+// Decide receives the consent form. The user either logs in with the form or already has a cookie session.
+func (ctrl *OAuthController) Decide(c *gin.Context) {
+	cookie, _ := c.Cookie(authorizeCookie)
+	expected := ctrl.authorizeCSRFToken(cookie)
+	if cookie == "" || !hmac.Equal([]byte(expected), []byte(c.PostForm("csrf_token"))) {
+		renderOAuthError(c, http.StatusForbidden, "The authorization request expired, please go back to the application and start again.")
+		return
+	}
+
+	req := authorizationRequest(c.PostForm)
+	client, scopes, ok := ctrl.checkAuthorization(c, req)
+	if !ok {
+		return
+	}
+	if c.PostForm("decision") != "allow" {
+		c.Redirect(http.StatusSeeOther, ctrl.OAuthService.RedirectWithError(req.RedirectURI, req.State, &services.OAuthError{Code: services.OAuthAccessDenied, Description: "the user denied the request"}))
+		return
+	}
+
+	loginRequired := c.PostForm("login") == "true"
+	userID, authTime, loggedIn := ctrl.sessionUser(c)
+	if email := strings.TrimSpace(c.PostForm("email")); email != "" {
+		user, err := ctrl.OAuthService.Users.Authenticate(c, email, c.PostForm("password"))
+		if hashingUnavailable(c, err) {
+			return
+		}
+		if err != nil {
+			message := "Invalid email or password."
+			if errors.Is(err, services.ErrAccountDisabled) {
+				message = "This account is disabled."
+			}
+			ctrl.renderConsent(c, http.StatusUnauthorized, client, req, scopes, true, message)
+			return
+		}
+		userID, authTime, loggedIn = user.ID, time.Now(), true
+	} else if loginRequired || !loggedIn {
+		ctrl.renderConsent(c, http.StatusUnauthorized, client, req, scopes, true, "Please log in to continue.")
+		return
+	}
+	ctrl.authorize(c, http.StatusSeeOther, userID, authTime, client, req, scopes)
+}
+
+func (ctrl *OAuthController) authorize(c *gin.Context, status int, userID uint, authTime time.Time, client *models.OAuthClient, req services.AuthorizationRequest, scopes models.StringList) {
+	redirect, err := ctrl.OAuthService.Authorize(c, userID, authTime, client, req, scopes)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	http.SetCookie(c.Writer, ctrl.authorizeCookie("", -1))
+	c.Redirect(status, redirect)
+}
+
+// checkAuthorization answers invalid requests: errors go back to the client, unless the client or
+// its redirect URI is unknown and the user can only be told on an error page
+func (ctrl *OAuthController) checkAuthorization(c *gin.Context, req services.AuthorizationRequest) (*models.OAuthClient, models.StringList, bool) {
+	client, scopes, err := ctrl.OAuthService.CheckAuthorization(c, req)
+	var oauthErr *services.OAuthError
+	switch {
+	case err == nil:
+		return client, scopes, true
+	case errors.As(err, &oauthErr) && client == nil:
+		renderOAuthError(c, http.StatusBadRequest, "The application sent an invalid request: "+oauthErr.Description+".")
+	case errors.As(err, &oauthErr):
+		c.Redirect(http.StatusFound, ctrl.OAuthService.RedirectWithError(req.RedirectURI, req.State, oauthErr))
+	default:
+		internalError(c, ctrl.Logger, err)
+	}
+	return nil, nil, false
+}
+
+func authorizationRequest(param func(string) string) services.AuthorizationRequest {
+	return services.AuthorizationRequest{
+		ClientID:            param("client_id"),
+		RedirectURI:         param("redirect_uri"),
+		ResponseType:        param("response_type"),
+		Scope:               param("scope"),
+		State:               param("state"),
+		Nonce:               param("nonce"),
+		CodeChallenge:       param("code_challenge"),
+		CodeChallengeMethod: param("code_challenge_method"),
+	}
+}
+
+// sessionUser returns the user of the cookie session and when the session started, if the browser has a valid one
+func (ctrl *OAuthController) sessionUser(c *gin.Context) (uint, time.Time, bool) {
+	token, err := c.Cookie(middlewares.SessionCookie)
+	if err != nil || token == "" {
+		return 0, time.Time{}, false
+	}
+	claims, err := ctrl.OAuthService.Users.ParseToken(token)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	userID, _ := claims["userID"].(float64)
+	tokenID, _ := claims["jti"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+	authTime := time.Unix(int64(issuedAt), 0)
+	if err := ctrl.OAuthService.Users.ValidateToken(c, uint(userID), tokenID, authTime); err != nil {
+		return 0, time.Time{}, false
+	}
+	return uint(userID), authTime, true
+}
+
+// AuthenticateClient authenticates the client of a token request for Token and the rate limits between
+// them, which find its ID under "clientID". Confidential clients authenticate with HTTP basic
+// authentication or the client_id and client_secret form fields, public clients only send their client_id.
+func (ctrl *OAuthController) AuthenticateClient(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes the credentials before putting them in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+		if c.PostForm("client_secret") != "" {
+			ctrl.tokenError(c, &services.OAuthError{Code: services.OAuthInvalidRequest, Description: "only one client authentication method can be used"}, basic)
+			return
+		}
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	client, err := ctrl.OAuthService.AuthenticateClient(c, clientID, secret)
+	if err != nil {
+		ctrl.tokenError(c, err, basic)
+		c.Abort()
+		return
+	}
+
+	c.Set("oauthClient", client)
+	c.Set("oauthBasicAuth", basic)
+	c.Set("clientID", client.ClientID)
+	c.Next()
+}
+
+// Token exchanges grants for tokens, for the client authenticated by AuthenticateClient
+func (ctrl *OAuthController) Token(c *gin.Context) {
+	client := c.MustGet("oauthClient").(*models.OAuthClient)
+	basic := c.GetBool("oauthBasicAuth")
+
+	var tokens *services.TokenResponse
+	var err error
+	switch grant := c.PostForm("grant_type"); grant {
+	case services.GrantAuthorizationCode:
+		tokens, err = ctrl.OAuthService.ExchangeCode(c, client, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	case services.GrantRefreshToken:
+		tokens, err = ctrl.OAuthService.Refresh(c, client, c.PostForm("refresh_token"), c.PostForm("scope"))
+	case services.GrantClientCredentials:
+		tokens, err = ctrl.OAuthService.ClientCredentials(c, client, c.PostForm("scope"))
+	default:
+		err = &services.OAuthError{Code: services.OAuthUnsupportedGrantType, Description: fmt.Sprintf("grant_type %q isn't supported", grant)}
+	}
+	if err != nil {
+		ctrl.tokenError(c, err, basic)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// tokenError answers with the OAuth error, 401 when the client failed to authenticate
+func (ctrl *OAuthController) tokenError(c *gin.Context, err error, basic bool) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	status := http.StatusBadRequest
+	if oauthErr.Code == services.OAuthInvalidClient {
+		status = http.StatusUnauthorized
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+	}
+	c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+}
+
+// UserInfo returns the claims about the user of the bearer access token
+func (ctrl *OAuthController) UserInfo(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="oauth"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing access token"})
+		return
+	}
+
+	claims, err := ctrl.OAuthService.UserInfo(c, token)
+	var oauthErr *services.OAuthError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, claims)
+	case errors.As(err, &oauthErr):
+		status := http.StatusUnauthorized
+		if oauthErr.Code == services.OAuthInsufficientScope {
+			status = http.StatusForbidden
+		}
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="oauth", error=%q, error_description=%q`, oauthErr.Code, oauthErr.Description))
+		c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+	default:
+		internalError(c, ctrl.Logger, err)
+	}
+}
+
+// renderConsent shows the consent page, with a new CSRF token tied to the browser by the authorize cookie
+func (ctrl *OAuthController) renderConsent(c *gin.Context, status int, client *models.OAuthClient, req services.AuthorizationRequest,
+	scopes models.StringList, login bool, message string) {
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	http.SetCookie(c.Writer, ctrl.authorizeCookie(nonce, int(consentPageLifetime.Seconds())))
+	req.Scope = scopes.String()
+	renderConsentPage(c, status, consentPage{
+		Client:    client,
+		Request:   req,
+		Scopes:    describeScopes(scopes),
+		Login:     login,
+		Message:   message,
+		CSRFToken: ctrl.authorizeCSRFToken(nonce),
+	})
+}
+
+// authorizeCSRFToken signs the nonce of the authorize cookie, so that a cookie planted by another site
+// on the API's domain can't be paired with a token of the attacker's choosing
+func (ctrl *OAuthController) authorizeCSRFToken(nonce string) string {
+	return services.CSRFToken(ctrl.OAuthService.Users.JWTSecret, "authorize:"+nonce)
+}
+
+func (ctrl *OAuthController) authorizeCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     authorizeCookie,
+		Value:    value,
+		Path:     "/oauth/authorize",
+		MaxAge:   maxAge,
+		Secure:   ctrl.Session.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
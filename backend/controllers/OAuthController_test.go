@@ -0,0 +1,246 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/ratelimit"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestOAuthProvider(t *testing.T) {
+	ctx := context.Background()
+	db := testutils.NewTestDB(t)
+	userService := &services.UserService{
+		Users:     &repositories.GormUserRepository{DB: db},
+		JWTSecret: testutils.JWTSecret,
+		Sessions:  &repositories.GormSessionRepository{DB: db},
+	}
+	oauthService := &services.OAuthService{
+		OAuth:      &repositories.GormOAuthRepository{DB: db},
+		Users:      userService,
+		Issuer:     "https://api.example.com",
+		SigningKey: testutils.OAuthSigningKey(t),
+	}
+	ctrl := &controllers.OAuthController{OAuthService: oauthService}
+
+	r := gin.New()
+	r.GET("/.well-known/openid-configuration", ctrl.Discovery)
+	r.GET("/oauth/jwks", ctrl.JWKS)
+	r.GET("/oauth/authorize", ctrl.Authorize)
+	r.POST("/oauth/authorize", ctrl.Decide)
+	r.POST("/oauth/token", ctrl.AuthenticateClient, ctrl.Token)
+	r.GET("/oauth/userinfo", ctrl.UserInfo)
+
+	send := func(req *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	postForm := func(target string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return send(req, cookies...)
+	}
+	// authorize opens the consent page and returns the form it holds with the cookie it is tied to
+	authorize := func(params url.Values, cookies ...*http.Cookie) (url.Values, *http.Cookie) {
+		w := send(httptest.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil), cookies...)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		match := csrfField.FindStringSubmatch(w.Body.String())
+		require.NotNil(t, match)
+		form := url.Values{"csrf_token": {match[1]}, "decision": {"allow"}}
+		for _, key := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			form.Set(key, params.Get(key))
+		}
+		return form, w.Result().Cookies()[0]
+	}
+	redirectQuery := func(w *httptest.ResponseRecorder) url.Values {
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "app.example.com", location.Host)
+		return location.Query()
+	}
+	token := func(form url.Values, clientID, secret string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+		w := send(req)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w, body
+	}
+
+	w := send(httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"token_endpoint":"https://api.example.com/oauth/token"`)
+	w = send(httptest.NewRequest("GET", "/oauth/jwks", nil))
+	assert.Contains(t, w.Body.String(), `"alg":"RS256"`)
+
+	user := testutils.CreateUser(t, userService.Users)
+	client, secret, err := oauthService.RegisterClient(ctx, services.NewOAuthClient{Name: "Internal App", RedirectURIs: []string{"https://app.example.com/callback"}})
+	require.NoError(t, err)
+	verifier, err := oidc.RandomString()
+	require.NoError(t, err)
+	params := url.Values{
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {oidc.CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	// Without a session the consent page asks for credentials
+	form, cookie := authorize(params)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	form.Set("email", user.Email)
+	form.Set("password", "wrong")
+	w = postForm("/oauth/authorize", form, cookie)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid email or password.")
+
+	// The form can't be posted from another site, which doesn't have the cookie
+	form.Set("password", testutils.Password)
+	assert.Equal(t, http.StatusForbidden, postForm("/oauth/authorize", form).Code)
+
+	w = postForm("/oauth/authorize", form, cookie)
+	require.Equal(t, http.StatusSeeOther, w.Code, w.Body.String())
+	query := redirectQuery(w)
+	assert.Equal(t, "xyz", query.Get("state"))
+	assert.Equal(t, "https://api.example.com", query.Get("iss"))
+	code := query.Get("code")
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"https://app.example.com/callback"}, "code_verifier": {verifier}}
+	w, tokens := token(exchange, client.ClientID, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_client", tokens["error"])
+
+	w, tokens = token(exchange, client.ClientID, secret)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "Bearer", tokens["token_type"])
+	assert.Equal(t, "openid email", tokens["scope"])
+	assert.NotEmpty(t, tokens["id_token"])
+
+	w, body := token(exchange, client.ClientID, secret)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	req := httptest.NewRequest("GET", "/oauth/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["access_token"].(string))
+	w = send(req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"email":"`+user.Email+`"`)
+	w = send(httptest.NewRequest("GET", "/oauth/userinfo", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+
+	// Refresh tokens are rotated, and the replaced one can't be used again
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens["refresh_token"].(string)}}
+	w, refreshed := token(refresh, client.ClientID, secret)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, tokens["refresh_token"], refreshed["refresh_token"])
+	w, body = token(refresh, client.ClientID, secret)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	// A user with a cookie session who already consented goes straight back to the client
+	session, err := userService.Login(ctx, user.Email, testutils.Password, services.Device{})
+	require.NoError(t, err)
+	sessionCookie := &http.Cookie{Name: middlewares.SessionCookie, Value: session}
+	w = send(httptest.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil), sessionCookie)
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	assert.NotEmpty(t, redirectQuery(w).Get("code"))
+
+	// Unless the client asks for the consent again, the session then being enough to allow it
+	params.Set("prompt", "consent")
+	form, cookie = authorize(params, sessionCookie)
+	w = postForm("/oauth/authorize", form, cookie, sessionCookie)
+	require.Equal(t, http.StatusSeeOther, w.Code, w.Body.String())
+	assert.NotEmpty(t, redirectQuery(w).Get("code"))
+
+	params.Set("prompt", "none")
+	w = send(httptest.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "login_required", redirectQuery(w).Get("error"))
+	params.Del("prompt")
+
+	form, cookie = authorize(params)
+	form.Set("decision", "deny")
+	w = postForm("/oauth/authorize", form, cookie)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "access_denied", redirectQuery(w).Get("error"))
+
+	// An unregistered redirect URI gets an error page instead of a redirect
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+	w = send(httptest.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), "redirect_uri isn&#39;t registered")
+
+	service, serviceSecret, err := oauthService.RegisterClient(ctx, services.NewOAuthClient{Name: "Batch", GrantTypes: []string{"client_credentials"}})
+	require.NoError(t, err)
+	w = postForm("/oauth/token", url.Values{"grant_type": {"client_credentials"}, "client_id": {service.ClientID}, "client_secret": {serviceSecret}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "refresh_token")
+	w = postForm("/oauth/token", url.Values{"grant_type": {"password"}, "client_id": {service.ClientID}, "client_secret": {serviceSecret}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported_grant_type")
+}
+
+func TestTokenRateLimitPerClient(t *testing.T) {
+	ctx := context.Background()
+	db := testutils.NewTestDB(t)
+	oauthService := &services.OAuthService{
+		OAuth:      &repositories.GormOAuthRepository{DB: db},
+		Users:      &services.UserService{Users: &repositories.GormUserRepository{DB: db}, JWTSecret: testutils.JWTSecret},
+		Issuer:     "https://api.example.com",
+		SigningKey: testutils.OAuthSigningKey(t),
+	}
+	ctrl := &controllers.OAuthController{OAuthService: oauthService}
+	limiter := &middlewares.RateLimiter{Store: &ratelimit.MemoryStore{}}
+	r := gin.New()
+	r.POST("/oauth/token", ctrl.AuthenticateClient, limiter.Limit(ratelimit.Policy{Name: "oauth-token", Limit: 1, Period: time.Minute}, middlewares.ByClient), ctrl.Token)
+
+	token := func(clientID, secret string) int {
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}, "client_secret": {secret}}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	first, firstSecret, err := oauthService.RegisterClient(ctx, services.NewOAuthClient{Name: "Batch", GrantTypes: []string{"client_credentials"}})
+	require.NoError(t, err)
+	second, secondSecret, err := oauthService.RegisterClient(ctx, services.NewOAuthClient{Name: "Reports", GrantTypes: []string{"client_credentials"}})
+	require.NoError(t, err)
+
+	// Both clients come from the same IP but have their own budget, which a wrong secret doesn't use up
+	assert.Equal(t, http.StatusUnauthorized, token(first.ClientID, "wrong"))
+	assert.Equal(t, http.StatusOK, token(first.ClientID, firstSecret))
+	assert.Equal(t, http.StatusTooManyRequests, token(first.ClientID, firstSecret))
+	assert.Equal(t, http.StatusOK, token(second.ClientID, secondSecret))
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// oidcStateCookie carries the state, nonce and PKCE verifier of a login in progress from /start to /callback
+const oidcStateCookie = "liven_oidc"
+
+// oidcStateLifetime is how long the user has to log in at the provider
+const oidcStateLifetime = 10 * time.Minute
+
+// oidcLoginState is what the callback needs to finish the login started in the same browser
+type oidcLoginState struct {
+	Provider  string `json:"provider"`
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// StartOIDCLogin sends the browser to the login page of the provider
+func (ctrl *UserController) StartOIDCLogin(c *gin.Context) {
+	provider, ok := ctrl.OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	login := oidcLoginState{Provider: provider.Name, ExpiresAt: time.Now().Add(oidcStateLifetime).Unix()}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		var err error
+		if *value, err = oidc.RandomString(); err != nil {
+			internalError(c, ctrl.Logger, err)
+			return
+		}
+	}
+
+	authURL, err := provider.AuthCodeURL(c, login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		ctrl.logger().WarnContext(c, "login provider unavailable", "provider", provider.Name, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		return
+	}
+
+	sealed, err := sealOIDCLoginState(ctrl.UserService.JWTSecret, login)
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	http.SetCookie(c.Writer, ctrl.oidcStateCookie(sealed, int(oidcStateLifetime.Seconds())))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// FinishOIDCLogin is where the provider sends the browser back. The login must have been started in the
+// same browser, whose state cookie holds what the code is redeemed with. With a frontend URL configured
+// the browser is redirected there with a cookie session, or with the error in the login_error parameter.
+func (ctrl *UserController) FinishOIDCLogin(c *gin.Context) {
+	provider, ok := ctrl.OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	// The state is single use, whatever the outcome
+	cookie, _ := c.Cookie(oidcStateCookie)
+	http.SetCookie(c.Writer, ctrl.oidcStateCookie("", -1))
+	login, err := openOIDCLoginState(ctrl.UserService.JWTSecret, cookie)
+	if err != nil || login.Provider != provider.Name || !hmac.Equal([]byte(login.State), []byte(c.Query("state"))) {
+		ctrl.failOIDCLogin(c, http.StatusBadRequest, "Login expired or was started in another browser, please start again")
+		return
+	}
+	if reason := c.Query("error"); reason != "" {
+		ctrl.failOIDCLogin(c, http.StatusUnauthorized, "The provider refused the login: "+reason)
+		return
+	}
+	if c.Query("code") == "" {
+		ctrl.failOIDCLogin(c, http.StatusBadRequest, "Missing authorization code")
+		return
+	}
+
+	claims, err := provider.Exchange(c, c.Query("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		ctrl.logger().WarnContext(c, "login provider exchange failed", "provider", provider.Name, "error", err)
+		ctrl.failOIDCLogin(c, http.StatusUnauthorized, "Login with the provider failed")
+		return
+	}
+
+	identity := services.ExternalIdentity{
+		Provider:      provider.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}
+	device := services.Device{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+	token, err := ctrl.UserService.LoginWithIdentity(c, identity, device)
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrEmailNotVerified), errors.Is(err, services.ErrAccountDisabled), errors.Is(err, services.ErrAccountDeleted):
+		ctrl.failOIDCLogin(c, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		ctrl.failOIDCLogin(c, http.StatusConflict, err.Error())
+		return
+	default:
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+
+	if ctrl.OIDCFrontendURL == "" {
+		c.JSON(http.StatusOK, gin.H{"token": token})
+		return
+	}
+	if _, err := ctrl.setSessionCookies(c, token); err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, ctrl.OIDCFrontendURL)
+}
+
+func (ctrl *UserController) failOIDCLogin(c *gin.Context, status int, message string) {
+	if ctrl.OIDCFrontendURL == "" {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	target, err := url.Parse(ctrl.OIDCFrontendURL)
+	if err != nil {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	query := target.Query()
+	query.Set("login_error", message)
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusSeeOther, target.String())
+}
+
+// oidcStateCookie is sent back on the provider's redirect, a cross-site navigation, so it is SameSite
+// lax whatever the session cookies use
+func (ctrl *UserController) oidcStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/auth/",
+		MaxAge:   maxAge,
+		Secure:   ctrl.Session.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sealOIDCLoginState signs the state with the JWT secret, the browser can read it but not change it
+func sealOIDCLoginState(secret string, login oidcLoginState) (string, error) {
+	payload, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(oidcStateMAC(secret, encoded)), nil
+}
+
+func openOIDCLoginState(secret, sealed string) (*oidcLoginState, error) {
+	encoded, signature, ok := strings.Cut(sealed, ".")
+	if !ok {
+		return nil, errors.New("malformed login state")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, oidcStateMAC(secret, encoded)) {
+		return nil, errors.New("invalid login state signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var login oidcLoginState
+	if err := json.Unmarshal(payload, &login); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > login.ExpiresAt {
+		return nil, errors.New("login state expired")
+	}
+	return &login, nil
+}
+
+func oidcStateMAC(secret, encoded string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("oidc:" + encoded))
+	return mac.Sum(nil)
+}
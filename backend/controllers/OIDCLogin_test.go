@@ -0,0 +1,132 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	userService := &services.UserService{
+		Users:      store.Users(),
+		JWTSecret:  "testsecret",
+		Sessions:   store.Sessions(),
+		Identities: store.Identities(),
+	}
+	mock := testutils.NewMockOIDCProvider(t, "liven", "client-secret")
+	userController := &controllers.UserController{
+		UserService:   userService,
+		OIDCProviders: map[string]*oidc.Provider{"mock": mock.Provider("mock", "http://api.example.com/auth/mock/callback")},
+	}
+
+	r := gin.New()
+	r.GET("/auth/:provider/start", userController.StartOIDCLogin)
+	r.GET("/auth/:provider/callback", userController.FinishOIDCLogin)
+	r.GET("/user/", middlewares.AuthMiddleware(userService.JWTSecret, userService), userController.GetUser)
+
+	send := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	// start returns the state cookie and where the provider sends the browser back to
+	start := func() (*http.Cookie, *url.URL) {
+		w := send("/auth/mock/start")
+		require.Equal(t, http.StatusFound, w.Code)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+		callback := mock.Authorize(t, w.Header().Get("Location"))
+		return cookies[0], callback
+	}
+	callbackPath := func(callback *url.URL) string {
+		return callback.Path + "?" + callback.RawQuery
+	}
+	currentUser := func(token string) models.User {
+		req := httptest.NewRequest("GET", "/user/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var user models.User
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+		return user
+	}
+
+	assert.Equal(t, http.StatusNotFound, send("/auth/unknown/start").Code)
+
+	// The identity is linked to the account registered with the same, verified, email
+	existing := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "password123"}
+	require.NoError(t, userService.Register(context.Background(), existing))
+	mock.SetUser(testutils.OIDCUser{Subject: "42", Email: existing.Email, EmailVerified: true, Name: "Jane"})
+
+	cookie, callback := start()
+	assert.Equal(t, "/auth/mock/callback", callback.Path)
+	w := send(callbackPath(callback), cookie)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, existing.ID, currentUser(response["token"]).ID)
+
+	// Codes are single use
+	assert.Equal(t, http.StatusUnauthorized, send(callbackPath(callback), cookie).Code)
+
+	// Callbacks without the state cookie of the same browser are refused, e.g. a link sent by an attacker
+	_, callback = start()
+	assert.Equal(t, http.StatusBadRequest, send(callbackPath(callback)).Code)
+	cookie, _ = start()
+	assert.Equal(t, http.StatusBadRequest, send(callbackPath(callback), cookie).Code)
+	tampered := *cookie
+	tampered.Value = "x" + tampered.Value
+	assert.Equal(t, http.StatusBadRequest, send(callbackPath(callback), &tampered).Code)
+
+	// Unverified emails don't get an account
+	mock.SetUser(testutils.OIDCUser{Subject: "43", Email: "john.doe@example.com"})
+	cookie, callback = start()
+	assert.Equal(t, http.StatusForbidden, send(callbackPath(callback), cookie).Code)
+
+	// With a frontend, the browser lands there with a cookie session, or with the error
+	userController.OIDCFrontendURL = "https://app.example.com/home"
+	mock.SetUser(testutils.OIDCUser{Subject: "44", Email: "john.doe@example.com", EmailVerified: true, Name: "John Doe"})
+	cookie, callback = start()
+	w = send(callbackPath(callback), cookie)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://app.example.com/home", w.Header().Get("Location"))
+	var session *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == middlewares.SessionCookie {
+			session = cookie
+		}
+	}
+	require.NotNil(t, session)
+	assert.Equal(t, "john.doe@example.com", currentUser(session.Value).Email)
+
+	mock.SetUser(testutils.OIDCUser{})
+	cookie, callback = start()
+	w = send(callbackPath(callback), cookie)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "The provider refused the login: access_denied", location.Query().Get("login_error"))
+}
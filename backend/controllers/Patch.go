@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// applyPatch applies the request body to the JSON representation of current, as a JSON Merge Patch
+// (RFC 7396) or a JSON Patch (RFC 6902) depending on the Content-Type. It returns the original and the
+// patched documents; members listed in omit are left out of the original. When it returns false the
+// error response has already been written.
+func applyPatch(c *gin.Context, current interface{}, omit ...string) (map[string]interface{}, map[string]interface{}, bool) {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || (mediaType != mergePatchContentType && mediaType != jsonPatchContentType) {
+		c.Header("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + mergePatchContentType + " or " + jsonPatchContentType})
+		return nil, nil, false
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	var original map[string]interface{}
+	encoded, err := json.Marshal(current)
+	if err == nil {
+		err = json.Unmarshal(encoded, &original)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	for _, field := range omit {
+		delete(original, field)
+	}
+	if encoded, err = json.Marshal(original); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	var patchedJSON []byte
+	if mediaType == mergePatchContentType {
+		patchedJSON, err = jsonpatch.MergePatch(encoded, body)
+	} else {
+		var patch jsonpatch.Patch
+		if patch, err = jsonpatch.DecodePatch(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Patch document: " + err.Error()})
+			return nil, nil, false
+		}
+		patchedJSON, err = patch.Apply(encoded)
+	}
+	if err != nil {
+		// A well-formed patch that can't be applied (failed test op, missing path, ...) is a 409 per RFC 5789
+		status := http.StatusConflict
+		if mediaType == mergePatchContentType {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": "Patch could not be applied: " + err.Error()})
+		return nil, nil, false
+	}
+
+	var patched map[string]interface{}
+	if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Patched document must be a JSON object"})
+		return nil, nil, false
+	}
+	return original, patched, true
+}
+
+// checkPatchedFields rejects patches that touch members outside of writable or that give them
+// a non-string value. A member removed or set to null counts as the empty string.
+func checkPatchedFields(c *gin.Context, original, patched map[string]interface{}, writable ...string) bool {
+	isWritable := make(map[string]bool, len(writable))
+	for _, field := range writable {
+		isWritable[field] = true
+		if value, ok := patched[field]; ok && value != nil {
+			if _, ok := value.(string); !ok {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Field %q must be a string or null", field)})
+				return false
+			}
+		}
+	}
+
+	for field, value := range patched {
+		if !isWritable[field] && !reflect.DeepEqual(original[field], value) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Field %q cannot be modified", field)})
+			return false
+		}
+	}
+	for field := range original {
+		if _, ok := patched[field]; !ok && !isWritable[field] {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Field %q cannot be removed", field)})
+			return false
+		}
+	}
+	return true
+}
+
+// patchedString returns the value of a field validated by checkPatchedFields
+func patchedString(patched map[string]interface{}, field string) string {
+	value, _ := patched[field].(string)
+	return value
+}
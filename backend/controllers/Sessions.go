@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/repositories"
+)
+
+// ListSessions returns where the user is logged in, flagging the session of the request as current
+func (ctrl *UserController) ListSessions(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	sessions, err := ctrl.UserService.ListSessions(c, userID, c.GetString("tokenID"))
+	if err != nil {
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession logs the user out of one of their sessions, the current one included
+func (ctrl *UserController) RevokeSession(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := ctrl.UserService.RevokeSession(c, userID, uint(sessionID)); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		internalError(c, ctrl.Logger, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Logout ends the session of the request and clears the session cookies
+func (ctrl *UserController) Logout(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	if tokenID := c.GetString("tokenID"); tokenID != "" {
+		if err := ctrl.UserService.Logout(c, userID, tokenID); err != nil {
+			internalError(c, ctrl.Logger, err)
+			return
+		}
+	}
+	ctrl.clearCookieSession(c)
+	c.Status(http.StatusNoContent)
+}
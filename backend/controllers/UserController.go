@@ -1,26 +1,66 @@
 package controllers
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/arthur-tragante/liven-code-test/config"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/oidc"
 	"github.com/arthur-tragante/liven-code-test/services"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type UserController struct {
 	UserService *services.UserService
+	Logger      *slog.Logger
+	// Session sets the attributes of the cookies of cookie sessions
+	Session config.Session
+	// OIDCProviders are the OpenID Connect providers of the /auth/:provider routes, by name
+	OIDCProviders map[string]*oidc.Provider
+	// OIDCFrontendURL is where browsers go after logging in with a provider, see config.OIDC
+	OIDCFrontendURL string
+}
+
+func (ctrl *UserController) logger() *slog.Logger {
+	if ctrl.Logger == nil {
+		return slog.Default()
+	}
+	return ctrl.Logger
+}
+
+// userRequest is the body of the register and update requests, the password being write-only on models.User
+type userRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 func (ctrl *UserController) RegisterUser(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var request userRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := ctrl.UserService.Register(&user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user := models.User{Name: request.Name, Email: request.Email, Password: request.Password}
+
+	if err := ctrl.UserService.Register(c, &user); err != nil {
+		if hashingUnavailable(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrPasswordRejected) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		internalError(c, ctrl.Logger, err)
 		return
 	}
 
@@ -31,6 +71,8 @@ func (ctrl *UserController) LoginUser(c *gin.Context) {
 	var loginData struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// Cookie asks for a cookie session instead of a bearer token
+		Cookie bool `json:"cookie"`
 	}
 
 	if err := c.ShouldBindJSON(&loginData); err != nil {
@@ -38,51 +80,177 @@ func (ctrl *UserController) LoginUser(c *gin.Context) {
 		return
 	}
 
-	token, err := ctrl.UserService.Login(loginData.Email, loginData.Password)
+	device := services.Device{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+	token, err := ctrl.UserService.Login(c, loginData.Email, loginData.Password, device)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		if hashingUnavailable(c, err) {
+			return
+		}
+		status := http.StatusUnauthorized
+		if errors.Is(err, services.ErrAccountDisabled) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
+	if loginData.Cookie {
+		ctrl.startCookieSession(c, token)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
 
 func (ctrl *UserController) GetUser(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	user, err := ctrl.UserService.GetUserByID(userID)
+	user, err := ctrl.UserService.GetUserByID(c, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	respondWithETag(c, userETag(user), user)
 }
 
 func (ctrl *UserController) UpdateUser(c *gin.Context) {
-	var updatedData models.User
-	if err := c.ShouldBindJSON(&updatedData); err != nil {
+	var request userRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	updatedData := models.User{Name: request.Name, Email: request.Email, Password: request.Password}
+
+	// PUT replaces the whole user, the password being the only write-only member that may be left out
+	if updatedData.Name == "" || updatedData.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and email are required"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uint)
+
+	// The version in the body is informational, only If-Match makes the write conditional
+	updatedData.Version = 0
+	if header, ok := ifMatchHeader(c); ok {
+		current, ok := ctrl.currentUser(c, userID)
+		if !ok || !checkIfMatch(c, header, userETag(current)) {
+			return
+		}
+		updatedData.Version = current.Version
+	}
+
+	if err := ctrl.UserService.UpdateUser(c, userID, &updatedData); err != nil {
+		ctrl.writeError(c, err)
+		return
+	}
+
+	user, ok := ctrl.currentUser(c, userID)
+	if !ok {
+		return
+	}
+
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, user)
+}
 
+// userWritableFields are the members a PATCH may change. The password is write-only: it is not part
+// of the patched document but can be added to it to set a new one.
+var userWritableFields = []string{"name", "email", "password"}
+
+func (ctrl *UserController) PatchUser(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	if err := ctrl.UserService.UpdateUser(userID, &updatedData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	current, ok := ctrl.currentUser(c, userID)
+	if !ok {
+		return
+	}
+
+	header, conditional := ifMatchHeader(c)
+	if conditional && !checkIfMatch(c, header, userETag(current)) {
+		return
+	}
+
+	original, patched, ok := applyPatch(c, current)
+	if !ok || !checkPatchedFields(c, original, patched, userWritableFields...) {
+		return
+	}
+
+	updatedData := models.User{
+		Name:     patchedString(patched, "name"),
+		Email:    patchedString(patched, "email"),
+		Password: patchedString(patched, "password"),
+		// The patch was computed from this version, so it must not land on top of another write
+		Version: current.Version,
+	}
+	if updatedData.Name == "" || updatedData.Email == "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Name and email cannot be removed"})
+		return
+	}
+
+	if err := ctrl.UserService.UpdateUser(c, userID, &updatedData); err != nil {
+		if errors.Is(err, services.ErrVersionConflict) && !conditional {
+			c.JSON(http.StatusConflict, gin.H{"error": "User was modified while the patch was applied, retry the request"})
+			return
+		}
+		ctrl.writeError(c, err)
+		return
+	}
+
+	user, ok := ctrl.currentUser(c, userID)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, updatedData)
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, user)
 }
 
 func (ctrl *UserController) DeleteUser(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	if err := ctrl.UserService.DeleteUser(userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var version uint
+	if header, ok := ifMatchHeader(c); ok {
+		current, ok := ctrl.currentUser(c, userID)
+		if !ok || !checkIfMatch(c, header, userETag(current)) {
+			return
+		}
+		version = current.Version
+	}
+
+	if err := ctrl.UserService.DeleteUser(c, userID, version); err != nil {
+		ctrl.writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "User deleted successfully",
+		"purge_after": ctrl.UserService.DeletionDeadline(time.Now()).Format(time.RFC3339),
+	})
+}
+
+func (ctrl *UserController) currentUser(c *gin.Context, userID uint) (*models.User, bool) {
+	user, err := ctrl.UserService.GetUserByID(c, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return user, true
+}
+
+func (ctrl *UserController) writeError(c *gin.Context, err error) {
+	if hashingUnavailable(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Resource has been modified, fetch it again before retrying"})
+	case errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPasswordRejected):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		internalError(c, ctrl.Logger, err)
+	}
 }
@@ -2,34 +2,34 @@ package controllers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
 	"github.com/arthur-tragante/liven-code-test/services"
-	"github.com/arthur-tragante/liven-code-test/testutils"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-	"gorm.io/gorm"
 )
 
 type UserControllerTestSuite struct {
 	suite.Suite
-	TestDBSetup    *testutils.TestDBSetup
 	UserService    *services.UserService
 	UserController *controllers.UserController
-	DB             *gorm.DB
 }
 
-func (suite *UserControllerTestSuite) SetupSuite() {
-	suite.TestDBSetup = testutils.SetupTestDB(assert.New(suite.T()))
-	suite.DB = suite.TestDBSetup.DB
+// SetupTest gives every test an empty in-memory store
+func (suite *UserControllerTestSuite) SetupTest() {
+	store := repositories.NewMemoryStore()
 	suite.UserService = &services.UserService{
-		DB:        suite.DB,
+		Users:     store.Users(),
 		JWTSecret: "testsecret",
 	}
 	suite.UserController = &controllers.UserController{
@@ -37,25 +37,14 @@ func (suite *UserControllerTestSuite) SetupSuite() {
 	}
 }
 
-func (suite *UserControllerTestSuite) TearDownSuite() {
-	suite.TestDBSetup.TearDown(assert.New(suite.T()))
-}
-
-func (suite *UserControllerTestSuite) SetupTest() {
-	err := suite.DB.Exec("DELETE FROM addresses").Error
-	assert.NoError(suite.T(), err)
-	err = suite.DB.Exec("DELETE FROM users").Error
-	assert.NoError(suite.T(), err)
-}
-
 func (suite *UserControllerTestSuite) TestRegisterUser_Success() {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
 
-	user := &models.User{
-		Name:     "John Doe",
-		Email:    "john.doe@example.com",
-		Password: "password123",
+	user := gin.H{
+		"name":     "John Doe",
+		"email":    "john.doe@example.com",
+		"password": "password123",
 	}
 
 	jsonValue, _ := json.Marshal(user)
@@ -69,7 +58,8 @@ func (suite *UserControllerTestSuite) TestRegisterUser_Success() {
 	var createdUser models.User
 	err := json.Unmarshal(w.Body.Bytes(), &createdUser)
 	assert.NoError(suite.T(), err)
-	assert.Equal(suite.T(), user.Email, createdUser.Email)
+	assert.Equal(suite.T(), user["email"], createdUser.Email)
+	assert.NotContains(suite.T(), w.Body.String(), "password")
 }
 
 func (suite *UserControllerTestSuite) TestRegisterUser_InvalidJSON() {
@@ -84,6 +74,20 @@ func (suite *UserControllerTestSuite) TestRegisterUser_InvalidJSON() {
 	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
 }
 
+func (suite *UserControllerTestSuite) TestRegisterUser_WeakPassword() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	body := `{"name": "John Doe", "email": "john.doe@example.com", "password": "1"}`
+	c.Request, _ = http.NewRequest("POST", "/register", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	suite.UserController.RegisterUser(c)
+
+	assert.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "must be at least 8 characters long")
+}
+
 func (suite *UserControllerTestSuite) TestLoginUser_Success() {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -94,7 +98,7 @@ func (suite *UserControllerTestSuite) TestLoginUser_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	loginData := map[string]string{
@@ -144,9 +148,10 @@ func (suite *UserControllerTestSuite) TestGetUser_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
+	c.Request, _ = http.NewRequest("GET", "/user", nil)
 	c.Set("userID", user.ID)
 
 	suite.UserController.GetUser(c)
@@ -180,7 +185,7 @@ func (suite *UserControllerTestSuite) TestUpdateUser_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	updatedData := &models.User{
@@ -227,7 +232,7 @@ func (suite *UserControllerTestSuite) TestDeleteUser_Success() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	c.Request, _ = http.NewRequest("DELETE", "/user", nil)
@@ -248,13 +253,232 @@ func (suite *UserControllerTestSuite) TestDeleteUser_InternalServerError() {
 	c, _ := gin.CreateTestContext(w)
 
 	c.Request, _ = http.NewRequest("DELETE", "/user", nil)
-	c.Set("userID", uint(999)) 
+	c.Set("userID", uint(999))
 
 	suite.UserController.DeleteUser(c)
 
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
 }
 
+func (suite *UserControllerTestSuite) TestUpdateUser_MissingFields() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request, _ = http.NewRequest("PUT", "/user", bytes.NewBufferString(`{"name": "No Email"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("userID", uint(1))
+
+	suite.UserController.UpdateUser(c)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+
+func (suite *UserControllerTestSuite) TestPatchUser() {
+	user := &models.User{
+		Name:     "Joy Doe",
+		Email:    "joy.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PATCH", "/user", bytes.NewBufferString(`{"name": "Joy Smith", "password": "newpassword"}`))
+	c.Request.Header.Set("Content-Type", "application/merge-patch+json")
+	c.Set("userID", user.ID)
+
+	suite.UserController.PatchUser(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var patchedUser models.User
+	err = json.Unmarshal(w.Body.Bytes(), &patchedUser)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Joy Smith", patchedUser.Name)
+	assert.Equal(suite.T(), user.Email, patchedUser.Email)
+
+	token, err := suite.UserService.Login(context.Background(), user.Email, "newpassword", services.Device{})
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), token)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PATCH", "/user", bytes.NewBufferString(`[{"op": "remove", "path": "/email"}]`))
+	c.Request.Header.Set("Content-Type", "application/json-patch+json")
+	c.Set("userID", user.ID)
+
+	suite.UserController.PatchUser(c)
+
+	assert.Equal(suite.T(), http.StatusUnprocessableEntity, w.Code)
+}
+
+// A client that disconnects while waiting for password hashing gets a 503 rather than a 401 or a 500
+func (suite *UserControllerTestSuite) TestLoginUser_ClientGone() {
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "password123"}
+	suite.Require().NoError(suite.UserService.Register(context.Background(), user))
+
+	w := httptest.NewRecorder()
+	c, engine := gin.CreateTestContext(w)
+	engine.ContextWithFallback = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := `{"email": "jane.doe@example.com", "password": "password123"}`
+	c.Request = httptest.NewRequest("POST", "/login", bytes.NewBufferString(body)).WithContext(ctx)
+
+	suite.UserController.LoginUser(c)
+
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, w.Code)
+	assert.Equal(suite.T(), "1", w.Header().Get("Retry-After"))
+}
+
+// In a cookie session the token never reaches JavaScript, and changing state takes the CSRF token
+func (suite *UserControllerTestSuite) TestLoginUser_CookieSession() {
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "password123"}
+	suite.Require().NoError(suite.UserService.Register(context.Background(), user))
+
+	r := gin.New()
+	r.POST("/login", suite.UserController.LoginUser)
+	userGroup := r.Group("/user", middlewares.AuthMiddleware(suite.UserService.JWTSecret, suite.UserService))
+	userGroup.GET("/", suite.UserController.GetUser)
+	userGroup.POST("/logout", suite.UserController.Logout)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"email": "jane.doe@example.com", "password": "password123", "cookie": true}`)))
+	suite.Require().Equal(http.StatusOK, w.Code)
+	var response map[string]string
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Empty(suite.T(), response["token"])
+	assert.NotEmpty(suite.T(), response["csrf_token"])
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	session, csrf := cookies[middlewares.SessionCookie], cookies[middlewares.CSRFCookie]
+	suite.Require().NotNil(session)
+	suite.Require().NotNil(csrf)
+	assert.True(suite.T(), session.HttpOnly)
+	assert.Equal(suite.T(), http.SameSiteStrictMode, session.SameSite)
+	assert.False(suite.T(), csrf.HttpOnly)
+	assert.Equal(suite.T(), response["csrf_token"], csrf.Value)
+
+	send := func(method, path, csrfHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(session)
+		req.AddCookie(csrf)
+		if csrfHeader != "" {
+			req.Header.Set(middlewares.CSRFHeader, csrfHeader)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(suite.T(), http.StatusOK, send("GET", "/user/", "").Code)
+	assert.Equal(suite.T(), http.StatusForbidden, send("POST", "/user/logout", "").Code)
+	assert.Equal(suite.T(), http.StatusForbidden, send("POST", "/user/logout", "forged").Code)
+
+	w = send("POST", "/user/logout", csrf.Value)
+	assert.Equal(suite.T(), http.StatusNoContent, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		assert.Negative(suite.T(), cookie.MaxAge, cookie.Name)
+	}
+	assert.Equal(suite.T(), http.StatusUnauthorized, send("GET", "/user/", "").Code)
+}
+
+func (suite *UserControllerTestSuite) TestSessions() {
+	store := repositories.NewMemoryStore()
+	suite.UserService.Users = store.Users()
+	suite.UserService.Sessions = store.Sessions()
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "password123"}
+	suite.Require().NoError(suite.UserService.Register(context.Background(), user))
+
+	r := gin.New()
+	r.POST("/login", suite.UserController.LoginUser)
+	userGroup := r.Group("/user", middlewares.AuthMiddleware(suite.UserService.JWTSecret, suite.UserService))
+	userGroup.GET("/sessions", suite.UserController.ListSessions)
+	userGroup.DELETE("/sessions/:id", suite.UserController.RevokeSession)
+
+	var tokens []string
+	for _, userAgent := range []string{"Firefox", "Chrome"} {
+		req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"email": "jane.doe@example.com", "password": "password123"}`))
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		suite.Require().Equal(http.StatusOK, w.Code)
+		var response map[string]string
+		suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		tokens = append(tokens, response["token"])
+	}
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "/user/sessions", tokens[1])
+	suite.Require().Equal(http.StatusOK, w.Code)
+	var sessions []map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &sessions))
+	suite.Require().Len(sessions, 2)
+	assert.Equal(suite.T(), "Chrome", sessions[0]["user_agent"])
+	assert.Equal(suite.T(), true, sessions[0]["current"])
+	assert.NotContains(suite.T(), sessions[0], "token_id")
+
+	firefox := fmt.Sprint(sessions[1]["id"])
+	assert.Equal(suite.T(), http.StatusBadRequest, send("DELETE", "/user/sessions/first", tokens[1]).Code)
+	assert.Equal(suite.T(), http.StatusNotFound, send("DELETE", "/user/sessions/999", tokens[1]).Code)
+	assert.Equal(suite.T(), http.StatusNoContent, send("DELETE", "/user/sessions/"+firefox, tokens[1]).Code)
+	assert.Equal(suite.T(), http.StatusUnauthorized, send("GET", "/user/sessions", tokens[0]).Code)
+}
+
+func (suite *UserControllerTestSuite) TestPasswordChangeRevokesTokens() {
+	store := repositories.NewMemoryStore()
+	suite.UserService.Users = store.Users()
+	suite.UserService.Sessions = store.Sessions()
+
+	r := gin.New()
+	r.POST("/login", suite.UserController.LoginUser)
+	userGroup := r.Group("/user", middlewares.AuthMiddleware(suite.UserService.JWTSecret, suite.UserService))
+	userGroup.GET("/", suite.UserController.GetUser)
+	userGroup.PUT("/", suite.UserController.UpdateUser)
+	userGroup.PATCH("/", suite.UserController.PatchUser)
+
+	send := func(method, path, token, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	login := func(email string) string {
+		user := &models.User{Name: "Jane Doe", Email: email, Password: "password123"}
+		suite.Require().NoError(suite.UserService.Register(context.Background(), user))
+		w := send("POST", "/login", "", "application/json", fmt.Sprintf(`{"email": %q, "password": "password123"}`, email))
+		suite.Require().Equal(http.StatusOK, w.Code)
+		var response map[string]string
+		suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		return response["token"]
+	}
+
+	token := login("jane.doe@example.com")
+	assert.Equal(suite.T(), http.StatusOK, send("PUT", "/user/", token, "application/json", `{"name": "Jane Doe", "email": "jane.doe@example.com"}`).Code)
+	assert.Equal(suite.T(), http.StatusOK, send("GET", "/user/", token, "", "").Code)
+	assert.Equal(suite.T(), http.StatusOK, send("PUT", "/user/", token, "application/json", `{"name": "Jane Doe", "email": "jane.doe@example.com", "password": "newpassword123"}`).Code)
+	assert.Equal(suite.T(), http.StatusUnauthorized, send("GET", "/user/", token, "", "").Code)
+
+	token = login("john.doe@example.com")
+	assert.Equal(suite.T(), http.StatusOK, send("PATCH", "/user/", token, "application/merge-patch+json", `{"password": "newpassword123"}`).Code)
+	assert.Equal(suite.T(), http.StatusUnauthorized, send("GET", "/user/", token, "", "").Code)
+}
+
 func TestUserControllerTestSuite(t *testing.T) {
 	suite.Run(t, new(UserControllerTestSuite))
 }
@@ -0,0 +1,41 @@
+package hashing
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMismatch is returned when a password doesn't match the stored hash
+var ErrMismatch = errors.New("password does not match")
+
+// PasswordHasher is one password hashing algorithm. Hashes are self-describing strings carrying the
+// algorithm and its parameters, so a hash can still be verified after the configuration changed.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password with the configured parameters
+	Hash(password string) (string, error)
+	// Identifies reports whether encoded was produced by this algorithm
+	Identifies(encoded string) bool
+	// Verify checks password against a hash of this algorithm, using the parameters stored in it.
+	// It returns ErrMismatch when the password is wrong.
+	Verify(encoded, password string) error
+	// NeedsRehash reports whether encoded, a hash of this algorithm, was made with other parameters than the configured ones
+	NeedsRehash(encoded string) bool
+}
+
+// algorithms can verify every hash the application ever stored, whichever algorithm is configured
+var algorithms = []PasswordHasher{&Bcrypt{}, &Argon2id{}}
+
+// verify checks password against a hash of any supported algorithm and reports whether the
+// hash should be replaced by one from current
+func verify(current PasswordHasher, encoded, password string) (bool, error) {
+	for _, algorithm := range algorithms {
+		if !algorithm.Identifies(encoded) {
+			continue
+		}
+		if err := algorithm.Verify(encoded, password); err != nil {
+			return false, err
+		}
+		return !current.Identifies(encoded) || current.NeedsRehash(encoded), nil
+	}
+	return false, fmt.Errorf("unknown password hash format")
+}
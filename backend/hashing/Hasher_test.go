@@ -0,0 +1,76 @@
+package hashing_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/arthur-tragante/liven-code-test/hashing"
+)
+
+func TestArgon2id(t *testing.T) {
+	hasher := &hashing.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+
+	hash, err := hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`), hash)
+	assert.True(t, hasher.Identifies(hash))
+
+	other, err := hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "every hash gets its own salt")
+
+	assert.NoError(t, hasher.Verify(hash, "password123"))
+	assert.ErrorIs(t, hasher.Verify(hash, "wrong"), hashing.ErrMismatch)
+	assert.Error(t, hasher.Verify("$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", "password123"))
+
+	assert.False(t, hasher.NeedsRehash(hash))
+	assert.True(t, (&hashing.Argon2id{Memory: 128, Iterations: 1, Parallelism: 1}).NeedsRehash(hash))
+}
+
+func TestBcrypt(t *testing.T) {
+	hasher := &hashing.Bcrypt{Cost: bcrypt.MinCost}
+
+	hash, err := hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, hasher.Identifies(hash))
+	assert.False(t, (&hashing.Argon2id{}).Identifies(hash))
+	assert.NoError(t, hasher.Verify(hash, "password123"))
+	assert.ErrorIs(t, hasher.Verify(hash, "wrong"), hashing.ErrMismatch)
+
+	assert.False(t, hasher.NeedsRehash(hash))
+	assert.True(t, (&hashing.Bcrypt{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash))
+}
+
+// A pool verifies hashes of every algorithm and asks for the ones not made by its hasher to be replaced
+func TestCompareAcrossAlgorithms(t *testing.T) {
+	argon := &hashing.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+	pool := &hashing.Pool{Hasher: argon}
+
+	legacy, err := (&hashing.Bcrypt{Cost: bcrypt.MinCost}).Hash("password123")
+	require.NoError(t, err)
+	rehash, err := pool.Compare(context.Background(), legacy, "password123")
+	assert.NoError(t, err)
+	assert.True(t, rehash)
+
+	weak, err := (&hashing.Argon2id{Memory: 32, Iterations: 1, Parallelism: 1}).Hash("password123")
+	require.NoError(t, err)
+	rehash, err = pool.Compare(context.Background(), weak, "password123")
+	assert.NoError(t, err)
+	assert.True(t, rehash)
+
+	current, err := pool.Hash(context.Background(), "password123")
+	require.NoError(t, err)
+	rehash, err = pool.Compare(context.Background(), current, "password123")
+	assert.NoError(t, err)
+	assert.False(t, rehash)
+
+	_, err = pool.Compare(context.Background(), legacy, "wrong")
+	assert.ErrorIs(t, err, hashing.ErrMismatch)
+	_, err = pool.Compare(context.Background(), "plaintext", "plaintext")
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package hashing
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/metrics"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+// DefaultQueueTimeout is how long a password operation waits for a free worker before giving up
+const DefaultQueueTimeout = 2 * time.Second
+
+// ErrBusy is returned when no worker freed up within the queue timeout
+var ErrBusy = errors.New("password hashing is saturated, retry later")
+
+// Pool runs password hashing on a bounded number of workers, so a burst of logins queues up instead of
+// pinning every core and starving the other endpoints. Callers wait at most QueueTimeout for a
+// worker and stop waiting as soon as their context is cancelled. The zero value is ready to use.
+type Pool struct {
+	// Workers bounds the concurrent hashing operations, GOMAXPROCS when zero
+	Workers int
+	// QueueTimeout is DefaultQueueTimeout when zero
+	QueueTimeout time.Duration
+	// Hasher makes new hashes, bcrypt with its default cost when nil. Existing hashes are verified
+	// with the algorithm they were made with.
+	Hasher  PasswordHasher
+	Metrics *metrics.Metrics
+
+	once  sync.Once
+	slots chan struct{}
+}
+
+func (p *Pool) init() {
+	p.once.Do(func() {
+		workers := p.Workers
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		p.slots = make(chan struct{}, workers)
+	})
+}
+
+func (p *Pool) queueTimeout() time.Duration {
+	if p.QueueTimeout <= 0 {
+		return DefaultQueueTimeout
+	}
+	return p.QueueTimeout
+}
+
+func (p *Pool) hasher() PasswordHasher {
+	if p.Hasher == nil {
+		return &Bcrypt{}
+	}
+	return p.Hasher
+}
+
+// Hash returns the encoded hash of password
+func (p *Pool) Hash(ctx context.Context, password string) (string, error) {
+	ctx, span := tracing.Start(ctx, "password.hash")
+	hashed, err := run(ctx, p, "hash", func() (string, error) {
+		return p.hasher().Hash(password)
+	})
+	tracing.End(span, err)
+	return hashed, err
+}
+
+// Compare returns ErrMismatch when password doesn't match hash. On a match, rehash reports whether
+// hash was made with another algorithm or other parameters than Hasher's and should be replaced.
+// A mismatch doesn't mark the span as failed, a wrong password isn't an error of the server.
+func (p *Pool) Compare(ctx context.Context, hash, password string) (rehash bool, err error) {
+	ctx, span := tracing.Start(ctx, "password.compare")
+	rehash, err = run(ctx, p, "compare", func() (bool, error) {
+		return verify(p.hasher(), hash, password)
+	})
+	if errors.Is(err, ErrMismatch) {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+	return rehash, err
+}
+
+// run waits for a worker and runs operation on it. Hashing can't be interrupted, so when the context
+// is cancelled mid-operation the caller returns right away while the worker finishes in the background
+// and only then frees its slot.
+func run[T any](ctx context.Context, p *Pool, operation string, work func() (T, error)) (T, error) {
+	var zero T
+	p.init()
+	if err := ctx.Err(); err != nil {
+		p.Metrics.PasswordHashRejected("canceled")
+		return zero, err
+	}
+
+	queued := time.Now()
+	p.Metrics.PasswordHashQueued(1)
+	timer := time.NewTimer(p.queueTimeout())
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		p.Metrics.PasswordHashQueued(-1)
+	case <-timer.C:
+		p.Metrics.PasswordHashQueued(-1)
+		p.Metrics.PasswordHashRejected("timeout")
+		return zero, ErrBusy
+	case <-ctx.Done():
+		p.Metrics.PasswordHashQueued(-1)
+		p.Metrics.PasswordHashRejected("canceled")
+		return zero, ctx.Err()
+	}
+	p.Metrics.ObservePasswordHashWait(time.Since(queued))
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-p.slots }()
+		start := time.Now()
+		value, err := work()
+		p.Metrics.ObservePasswordHashing(operation, time.Since(start))
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
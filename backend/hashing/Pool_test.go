@@ -0,0 +1,74 @@
+package hashing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/metrics"
+)
+
+func TestHashAndCompare(t *testing.T) {
+	pool := &hashing.Pool{Hasher: &hashing.Bcrypt{Cost: bcrypt.MinCost}}
+
+	hash, err := pool.Hash(context.Background(), "password123")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(hash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+
+	rehash, err := pool.Compare(context.Background(), hash, "password123")
+	assert.NoError(t, err)
+	assert.False(t, rehash)
+	_, err = pool.Compare(context.Background(), hash, "wrong")
+	assert.ErrorIs(t, err, hashing.ErrMismatch)
+}
+
+// With every worker busy, callers give up after the queue timeout or when their context ends
+func TestSaturatedPool(t *testing.T) {
+	m := metrics.New()
+	pool := &hashing.Pool{Workers: 1, QueueTimeout: 50 * time.Millisecond, Hasher: &hashing.Bcrypt{Cost: 14}, Metrics: m}
+
+	// One slow hash holds the only worker
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := pool.Hash(context.Background(), "password123")
+		assert.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool {
+		return strings.Contains(scrape(t, m), "liven_password_hash_queue_wait_seconds_count 1")
+	}, time.Second, time.Millisecond)
+
+	start := time.Now()
+	_, err := pool.Hash(context.Background(), "password123")
+	assert.ErrorIs(t, err, hashing.ErrBusy)
+	assert.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.Compare(ctx, "hash", "password123")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `liven_password_hash_rejected_total{reason="timeout"} 1`)
+	assert.Contains(t, body, `liven_password_hash_rejected_total{reason="canceled"} 1`)
+	assert.Contains(t, body, "liven_password_hash_queue_depth 0")
+	wg.Wait()
+}
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
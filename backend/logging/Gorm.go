@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// DefaultSlowQueryThreshold is the duration above which a query is logged as a warning
+const DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+// GormLogger sends the gorm logs to slog. Queries are logged with placeholders instead
+// of their values, which hold personal data; every query shows up at debug level,
+// failed ones at error and slow ones at warn.
+type GormLogger struct {
+	Logger        *slog.Logger
+	SlowThreshold time.Duration
+}
+
+// NewGormLogger returns a GormLogger writing to logger, slog.Default() when nil
+func NewGormLogger(logger *slog.Logger) *GormLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &GormLogger{Logger: logger, SlowThreshold: DefaultSlowQueryThreshold}
+}
+
+// LogMode is a no-op, the level of the slog handler decides what is written
+func (l *GormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.Logger.InfoContext(ctx, fmt.Sprintf(msg, data...))
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.Logger.WarnContext(ctx, fmt.Sprintf(msg, data...))
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.Logger.ErrorContext(ctx, fmt.Sprintf(msg, data...))
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	level := slog.LevelDebug
+	message := "query"
+	switch {
+	// Not found is an expected outcome the callers handle
+	case err != nil && !errors.Is(err, gormlogger.ErrRecordNotFound):
+		level, message = slog.LevelError, "query failed"
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold:
+		level, message = slog.LevelWarn, "slow query"
+	}
+	if !l.Logger.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	l.Logger.LogAttrs(ctx, level, message, attrs...)
+}
+
+// ParamsFilter drops the query parameters, so logged queries keep their placeholders
+func (l *GormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose value is never logged, compared in lower case
+// without separators so that "zip_code", "zipCode" and "Zip-Code" all match
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"newpassword":   true,
+	"token":         true,
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"idtoken":       true,
+	"authorization": true,
+	"cookie":        true,
+	"setcookie":     true,
+	"secret":        true,
+	"jwtsecret":     true,
+	"email":         true,
+	"street":        true,
+	"number":        true,
+	"complement":    true,
+	"city":          true,
+	"state":         true,
+	"zipcode":       true,
+	"country":       true,
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)bearer\s+\S+`)
+)
+
+// Redact is a slog ReplaceAttr function. It masks attributes with a sensitive key and scrubs
+// emails and tokens out of every other string, error messages included.
+func Redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[normalizeKey(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, RedactString(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, RedactString(err.Error()))
+		}
+	}
+	return attr
+}
+
+// RedactString masks the emails and tokens found in s
+func RedactString(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	return emailPattern.ReplaceAllString(s, redacted)
+}
+
+func normalizeKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+}
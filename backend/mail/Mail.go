@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogSender writes emails to the log instead of sending them, for development and setups without a mail server
+type LogSender struct {
+	Logger *slog.Logger
+}
+
+func (s *LogSender) Send(ctx context.Context, message Message) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.InfoContext(ctx, "email not sent, no mail server configured", "email", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
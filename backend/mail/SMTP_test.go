@@ -0,0 +1,90 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/mail"
+)
+
+// fakeSMTPServer accepts a single message and sends back the commands and data it received
+func fakeSMTPServer(t *testing.T) (port int, received chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received = make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var lines []string
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				received <- lines
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPSender(t *testing.T) {
+	port, received := fakeSMTPServer(t)
+	sender := &mail.SMTPSender{Host: "127.0.0.1", Port: port, From: "Liven <no-reply@liven.example>"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := sender.Send(ctx, mail.Message{To: "jane@example.com", Subject: "New login\r\nBcc: attacker@example.com", Body: "Hello Jane,\nsomeone logged in."})
+	require.NoError(t, err)
+
+	lines := <-received
+	session := strings.Join(lines, "\n")
+	assert.Contains(t, lines, "MAIL FROM:<no-reply@liven.example>")
+	assert.Contains(t, lines, "RCPT TO:<jane@example.com>")
+	assert.Contains(t, lines, `From: "Liven" <no-reply@liven.example>`)
+	assert.Contains(t, lines, "To: <jane@example.com>")
+	assert.Contains(t, lines, "Hello Jane,")
+	assert.Contains(t, lines, "someone logged in.")
+	assert.NotContains(t, lines, "Bcc: attacker@example.com")
+	assert.Contains(t, session, "Subject: =?utf-8?q?")
+	assert.Contains(t, session, "Message-ID: <")
+	assert.Contains(t, session, "@liven.example>")
+
+	err = sender.Send(ctx, mail.Message{To: "not an address"})
+	assert.ErrorContains(t, err, "invalid recipient")
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// countTimeout keeps a slow count from stalling the whole scrape
+const countTimeout = 2 * time.Second
+
+// countInterval is how long the counts are reused, so frequent scrapes don't each scan the tables
+const countInterval = 30 * time.Second
+
+// RegisterDatabase adds the connection pool statistics (go_sql_* series) and the user and address gauges
+func (m *Metrics) RegisterDatabase(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return registerAll(m.Registry,
+		collectors.NewDBStatsCollector(sqlDB, db.Dialector.Name()),
+		&entityCollector{db: db},
+	)
+}
+
+func registerAll(registry *prometheus.Registry, cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	usersDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "users"),
+		"Users that are not deleted, by state: active or disabled.", []string{"state"}, nil)
+	addressesDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "addresses"),
+		"Addresses that are not deleted.", nil, nil)
+)
+
+// entityCollector counts the rows in the database, so the gauges are right whichever instance wrote them.
+// The counts are taken on a scrape at most once per countInterval.
+type entityCollector struct {
+	db *gorm.DB
+
+	mu                          sync.Mutex
+	countedAt                   time.Time
+	active, disabled, addresses int64
+}
+
+func (c *entityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- addressesDesc
+}
+
+func (c *entityCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.countedAt) >= countInterval {
+		if err := c.count(); err != nil {
+			ch <- prometheus.NewInvalidMetric(usersDesc, err)
+			ch <- prometheus.NewInvalidMetric(addressesDesc, err)
+			return
+		}
+		c.countedAt = time.Now()
+	}
+	ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(c.active), "active")
+	ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(c.disabled), "disabled")
+	ch <- prometheus.MustNewConstMetric(addressesDesc, prometheus.GaugeValue, float64(c.addresses))
+}
+
+func (c *entityCollector) count() error {
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+	db := c.db.WithContext(ctx)
+
+	var active, disabled, addresses int64
+	if err := db.Model(&models.User{}).Where("disabled_at IS NULL").Count(&active).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.User{}).Where("disabled_at IS NOT NULL").Count(&disabled).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.Address{}).Count(&addresses).Error; err != nil {
+		return err
+	}
+	c.active, c.disabled, c.addresses = active, disabled, addresses
+	return nil
+}
@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "liven"
+
+// Results of a login attempt
+const (
+	LoginSuccess  = "success"
+	LoginFailure  = "failure"
+	LoginDisabled = "disabled"
+)
+
+// Metrics holds the application metrics and the registry serving them. Its recording methods
+// accept a nil receiver, so services and tests that don't care about metrics can leave it unset.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequests      *prometheus.CounterVec
+	httpDuration      *prometheus.HistogramVec
+	logins            *prometheus.CounterVec
+	passwordHashing   *prometheus.HistogramVec
+	passwordQueue     prometheus.Gauge
+	passwordWait      prometheus.Histogram
+	passwordRejected  *prometheus.CounterVec
+	registrations     prometheus.Counter
+	addressOperations *prometheus.CounterVec
+	rateLimited       *prometheus.CounterVec
+}
+
+// New creates the metrics in a registry of their own, together with the Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests answered, by method, route template and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to answer HTTP requests, by method, route template and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by result: success, failure (unknown email or wrong password) or disabled.",
+		}, []string{"result"}),
+		passwordHashing: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "password_hash_duration_seconds",
+			Help:      "Time spent hashing (hash) or verifying (compare) passwords.",
+			// bcrypt takes tens to hundreds of milliseconds depending on the cost
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}, []string{"operation"}),
+		passwordQueue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "password_hash_queue_depth",
+			Help:      "Password operations waiting for a free hashing worker.",
+		}),
+		passwordWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "password_hash_queue_wait_seconds",
+			Help:      "Time password operations waited for a free hashing worker.",
+			Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		}),
+		passwordRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "password_hash_rejected_total",
+			Help:      "Password operations that never got a worker, by reason: timeout (answered 503) or canceled (client went away).",
+		}, []string{"reason"}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "user_registrations_total",
+			Help:      "Users registered.",
+		}),
+		addressOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "address_operations_total",
+			Help:      "Addresses created, updated, deleted, restored or imported.",
+		}, []string{"operation"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Requests refused with 429 Too Many Requests, by rate limit policy.",
+		}, []string{"policy"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.logins,
+		m.passwordHashing,
+		m.passwordQueue,
+		m.passwordWait,
+		m.passwordRejected,
+		m.registrations,
+		m.addressOperations,
+		m.rateLimited,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// ObserveRequest records an answered HTTP request. route is the route template, so that
+// /user/address/1 and /user/address/2 share their series.
+func (m *Metrics) ObserveRequest(method, route, status string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequests.WithLabelValues(method, route, status).Inc()
+	m.httpDuration.WithLabelValues(method, route, status).Observe(duration.Seconds())
+}
+
+// LoginAttempt counts a login by result, one of LoginSuccess, LoginFailure or LoginDisabled
+func (m *Metrics) LoginAttempt(result string) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(result).Inc()
+}
+
+// ObservePasswordHashing records the duration of a password "hash" or "compare" operation
+func (m *Metrics) ObservePasswordHashing(operation string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.passwordHashing.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// PasswordHashQueued moves the password hashing queue depth by delta
+func (m *Metrics) PasswordHashQueued(delta float64) {
+	if m == nil {
+		return
+	}
+	m.passwordQueue.Add(delta)
+}
+
+// ObservePasswordHashWait records how long a password operation waited for a worker
+func (m *Metrics) ObservePasswordHashWait(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.passwordWait.Observe(duration.Seconds())
+}
+
+// PasswordHashRejected counts a password operation that gave up waiting, reason is "timeout" or "canceled"
+func (m *Metrics) PasswordHashRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.passwordRejected.WithLabelValues(reason).Inc()
+}
+
+// UserRegistered counts a new user
+func (m *Metrics) UserRegistered() {
+	if m == nil {
+		return
+	}
+	m.registrations.Inc()
+}
+
+// AddressOperation counts addresses by operation: create, update, delete, restore or import
+func (m *Metrics) AddressOperation(operation string, count int) {
+	if m == nil || count <= 0 {
+		return
+	}
+	m.addressOperations.WithLabelValues(operation).Add(float64(count))
+}
+
+// RateLimited counts a request refused by the named rate limit policy
+func (m *Metrics) RateLimited(policy string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(policy).Inc()
+}
@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/metrics"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+func TestHTTPMetricsUseRouteTemplates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := metrics.New()
+	r := gin.New()
+	r.Use(middlewares.MetricsMiddleware(m))
+	r.GET("/address/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/address/1", "/address/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `liven_http_requests_total{method="GET",route="/address/:id",status="204"} 2`)
+	assert.Contains(t, body, `liven_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `liven_http_request_duration_seconds_count{method="GET",route="/address/:id",status="204"} 2`)
+	assert.NotContains(t, body, "/address/1")
+}
+
+func TestServiceAndDatabaseMetrics(t *testing.T) {
+	m := metrics.New()
+	db := testutils.NewTestDB(t)
+	require.NoError(t, m.RegisterDatabase(db))
+	users := &services.UserService{Users: &repositories.GormUserRepository{DB: db}, JWTSecret: "testsecret", Metrics: m}
+	addresses := &services.AddressService{Addresses: &repositories.GormAddressRepository{DB: db}, Metrics: m}
+	ctx := context.Background()
+
+	user := &models.User{Name: "Jane Doe", Email: "jane@example.com", Password: "password123"}
+	require.NoError(t, users.Register(ctx, user))
+	require.NoError(t, addresses.CreateAddress(ctx, &models.Address{UserID: user.ID, Street: "Main St"}))
+	disabled := testutils.CreateUser(t, users.Users)
+	require.NoError(t, users.DisableUser(ctx, disabled.ID))
+
+	_, err := users.Login(ctx, "jane@example.com", "password123", services.Device{})
+	require.NoError(t, err)
+	_, err = users.Login(ctx, "jane@example.com", "wrong", services.Device{})
+	require.Error(t, err)
+	_, err = users.Login(ctx, "nobody@example.com", "password123", services.Device{})
+	require.Error(t, err)
+
+	body := scrape(t, m)
+	for _, line := range []string{
+		`liven_logins_total{result="success"} 1`,
+		`liven_logins_total{result="failure"} 2`,
+		`liven_user_registrations_total 1`,
+		`liven_address_operations_total{operation="create"} 1`,
+		`liven_password_hash_duration_seconds_count{operation="hash"} 1`,
+		`liven_password_hash_duration_seconds_count{operation="compare"} 2`,
+		`liven_users{state="active"} 1`,
+		`liven_users{state="disabled"} 1`,
+		`liven_addresses 1`,
+		`go_sql_max_open_connections{db_name="sqlite"} 1`,
+	} {
+		assert.Contains(t, body, line)
+	}
+
+	// The counts are reused by the following scrapes for a while
+	require.NoError(t, addresses.CreateAddress(ctx, &models.Address{UserID: user.ID, Street: "Second St"}))
+	assert.Contains(t, scrape(t, m), `liven_addresses 1`)
+}
@@ -0,0 +1,65 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/logging"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var out bytes.Buffer
+	logger := logging.New(&out, slog.LevelInfo)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(middlewares.RequestIDMiddleware(), middlewares.AccessLogMiddleware(logger))
+	r.GET("/user", middlewares.AuthMiddleware(testutils.JWTSecret, nil), func(c *gin.Context) {
+		logger.InfoContext(c, "inside the handler")
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	user := &models.User{}
+	user.ID = 42
+	req, _ := http.NewRequest("GET", "/user?email=jane@example.com", nil)
+	req.Header.Set("Authorization", "Bearer "+testutils.IssueToken(t, user, testutils.JWTSecret))
+	req.Header.Set(middlewares.RequestIDHeader, "from-the-proxy")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, "from-the-proxy", w.Header().Get(middlewares.RequestIDHeader))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.NotContains(t, out.String(), "jane@example.com")
+
+	var handlerLine, accessLine map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &handlerLine))
+	require.NoError(t, json.Unmarshal(lines[1], &accessLine))
+	assert.Equal(t, "from-the-proxy", handlerLine["request_id"])
+	assert.Equal(t, "from-the-proxy", accessLine["request_id"])
+	assert.Equal(t, float64(42), accessLine["user_id"])
+	assert.Equal(t, float64(http.StatusOK), accessLine["status"])
+	assert.Equal(t, "/user", accessLine["path"])
+	assert.Contains(t, accessLine, "latency_ms")
+
+	// Missing or unsafe IDs are replaced
+	for _, sent := range []string{"", "bad id\nwith a newline"} {
+		req, _ = http.NewRequest("GET", "/missing", nil)
+		req.Header.Set(middlewares.RequestIDHeader, sent)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Regexp(t, `^[0-9a-f]{32}$`, w.Header().Get(middlewares.RequestIDHeader))
+	}
+}
@@ -1,31 +1,58 @@
 package middlewares
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/logging"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// TokenValidator is implemented by services.UserService
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, userID uint, tokenID string, issuedAt time.Time) error
+}
+
+// Cookie sessions keep the JWT in the HttpOnly SessionCookie. Requests changing state must then repeat
+// in CSRFHeader the CSRF token that JavaScript reads from CSRFCookie (double-submit).
+const (
+	SessionCookie = "liven_session"
+	CSRFCookie    = "liven_csrf"
+	CSRFHeader    = "X-CSRF-Token"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware authenticates the bearer token of the request, or the session cookie when there is no
+// Authorization header. When validator is not nil it is also asked whether the token has been revoked.
+func AuthMiddleware(jwtSecret string, validator TokenValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var tokenString string
 		authHeader := c.GetHeader("Authorization")
+		fromCookie := false
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
-
-		parts := strings.Split(authHeader, "Bearer ")
-		if len(parts) != 2 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
-			c.Abort()
-			return
+			cookie, err := c.Cookie(SessionCookie)
+			if err != nil || cookie == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+				c.Abort()
+				return
+			}
+			tokenString, fromCookie = cookie, true
+		} else {
+			parts := strings.Split(authHeader, "Bearer ")
+			if len(parts) != 2 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
+				c.Abort()
+				return
+			}
+			tokenString = parts[1]
 		}
 
-		tokenString := parts[1]
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, errors.New("unexpected signing method")
@@ -59,8 +86,44 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		tokenID, _ := claims["jti"].(string)
+		if fromCookie && !safeMethod(c.Request.Method) && !validCSRF(c, jwtSecret, tokenID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token missing or invalid"})
+			c.Abort()
+			return
+		}
+
+		if validator != nil {
+			issuedAt, _ := claims["iat"].(float64)
+			if err := validator.ValidateToken(c.Request.Context(), uint(userID), tokenID, time.Unix(int64(issuedAt), 0)); err != nil {
+				if errors.Is(err, services.ErrTokenRevoked) || errors.Is(err, services.ErrAccountDisabled) {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token", "message": err.Error()})
+				} else {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				}
+				c.Abort()
+				return
+			}
+		}
+
 		c.Set("userID", uint(userID))
+		c.Set("tokenID", tokenID)
+		c.Request = c.Request.WithContext(logging.WithUserID(c.Request.Context(), uint(userID)))
 
 		c.Next()
 	}
 }
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// validCSRF checks that the CSRF header matches the CSRF cookie, and both the token of the session
+func validCSRF(c *gin.Context, jwtSecret, tokenID string) bool {
+	header := c.GetHeader(CSRFHeader)
+	cookie, _ := c.Cookie(CSRFCookie)
+	expected := services.CSRFToken(jwtSecret, tokenID)
+	return header != "" &&
+		subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(header), []byte(expected)) == 1
+}
@@ -27,7 +27,7 @@ func TestAuthMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	secret := "testsecret"
-	r.Use(middlewares.AuthMiddleware(secret))
+	r.Use(middlewares.AuthMiddleware(secret, nil))
 
 	r.GET("/test", func(c *gin.Context) {
 		userID, _ := c.Get("userID")
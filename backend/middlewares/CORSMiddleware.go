@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+)
+
+// Methods and request headers the API accepts from browsers, and the response headers their scripts may read
+var (
+	corsMethods        = "GET, POST, PUT, PATCH, DELETE"
+	corsRequestHeaders = strings.Join([]string{"Authorization", "Content-Type", "Accept", "If-Match", "If-None-Match", "Idempotency-Key", CSRFHeader, RequestIDHeader}, ", ")
+	corsExposedHeaders = strings.Join([]string{"ETag", "Content-Disposition", "Retry-After", "Accept-Patch", "Idempotent-Replayed",
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", RequestIDHeader}, ", ")
+)
+
+// CORSMiddleware lets the configured origins call the API from the browser. Preflight requests are
+// answered here, before routing and authentication, and refused with 403 for other origins. Without
+// allowed origins it does nothing, so browsers keep enforcing the same-origin policy.
+func CORSMiddleware(cfg config.CORS) gin.HandlerFunc {
+	anyOrigin := false
+	allowed := map[string]bool{}
+	for _, origin := range cfg.AllowedOrigins {
+		anyOrigin = anyOrigin || origin == "*"
+		allowed[strings.ToLower(origin)] = true
+	}
+
+	return func(c *gin.Context) {
+		if len(allowed) == 0 {
+			c.Next()
+			return
+		}
+		// The response depends on the origin, caches must not hand one origin's answer to another
+		c.Writer.Header().Add("Vary", "Origin")
+
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if origin == "" {
+			c.Next()
+			return
+		}
+		if !anyOrigin && !allowed[strings.ToLower(origin)] {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if anyOrigin {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", corsExposedHeaders)
+			c.Next()
+			return
+		}
+		c.Header("Access-Control-Allow-Methods", corsMethods)
+		c.Header("Access-Control-Allow-Headers", corsRequestHeaders)
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,112 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+)
+
+func newCORSRouter(cfg config.CORS) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middlewares.SecurityHeadersMiddleware(config.Default().SecurityHeaders), middlewares.CORSMiddleware(cfg))
+	r.GET("/user/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"name": "John"})
+	})
+	return r
+}
+
+func sendFrom(r *gin.Engine, method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/user/", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	r := newCORSRouter(config.CORS{AllowedOrigins: []string{"http://localhost:3000"}, AllowCredentials: true, MaxAge: 10 * time.Minute})
+
+	preflight := map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "authorization, if-match"}
+	w := sendFrom(r, http.MethodOptions, "http://localhost:3000", preflight)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "PUT")
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "If-Match")
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	w = sendFrom(r, http.MethodGet, "http://localhost:3000", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag")
+
+	// Other origins get no CORS headers, so the browser keeps the response from their scripts
+	w = sendFrom(r, http.MethodOptions, "https://evil.example.com", preflight)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	w = sendFrom(r, http.MethodGet, "https://evil.example.com", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = sendFrom(r, http.MethodGet, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSMiddleware_AnyOrigin(t *testing.T) {
+	r := newCORSRouter(config.CORS{AllowedOrigins: []string{"*"}})
+
+	w := sendFrom(r, http.MethodGet, "https://anything.example.com", nil)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCORSMiddleware_Disabled(t *testing.T) {
+	r := newCORSRouter(config.CORS{})
+
+	w := sendFrom(r, http.MethodOptions, "http://localhost:3000", map[string]string{"Access-Control-Request-Method": "GET"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Vary"))
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	r := newCORSRouter(config.CORS{})
+
+	w := sendFrom(r, http.MethodGet, "", nil)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", w.Header().Get("Content-Security-Policy"))
+
+	// Unknown routes get them too
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	missing := httptest.NewRecorder()
+	r.ServeHTTP(missing, req)
+	assert.Equal(t, http.StatusNotFound, missing.Code)
+	assert.Equal(t, "nosniff", missing.Header().Get("X-Content-Type-Options"))
+
+	gin.SetMode(gin.TestMode)
+	r = gin.New()
+	r.Use(middlewares.SecurityHeadersMiddleware(config.SecurityHeaders{}))
+	r.GET("/user/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	w = sendFrom(r, http.MethodGet, "", nil)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+	assert.Empty(t, w.Header().Get("X-Frame-Options"))
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+}
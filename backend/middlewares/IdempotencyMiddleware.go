@@ -0,0 +1,208 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// MaxIdempotentBodySize bounds the request bodies buffered to be hashed, the size of the largest import
+const MaxIdempotentBodySize = 10 << 20
+
+// replayable reports whether a response header is stored and replayed. The request ID, the date and the
+// rate limit headers describe the request that got them, the replay gets its own.
+func replayable(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	return name != RequestIDHeader && name != "Date" && name != "Retry-After" && !strings.HasPrefix(name, "Ratelimit-")
+}
+
+// IdempotencyStore is implemented by services.IdempotencyService
+type IdempotencyStore interface {
+	Begin(key string, userID uint, requestHash string) (*models.IdempotencyKey, error)
+	Complete(record *models.IdempotencyKey, status int, headers http.Header, body []byte) error
+	Release(record *models.IdempotencyKey) error
+}
+
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// IdempotencyScope narrows the keys of anonymous requests to what their body is about, e.g. the account
+// being registered, since those requests would otherwise share a single namespace. An empty scope
+// leaves the request out of idempotency.
+type IdempotencyScope func(body []byte) string
+
+// EmailScope scopes the keys of a registration to its normalized email
+func EmailScope(body []byte) string {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(request.Email))
+}
+
+// IdempotencyMiddleware makes requests carrying an Idempotency-Key header safe to retry: the first
+// response per key and user is stored and replayed for later requests with the same key and payload.
+// It must run after AuthMiddleware so keys are scoped to the user, keys of anonymous requests are ignored.
+func IdempotencyMiddleware(store IdempotencyStore) gin.HandlerFunc {
+	return idempotency(store, nil)
+}
+
+// AnonymousIdempotencyMiddleware is IdempotencyMiddleware for routes without authentication: the keys
+// are stored under user 0, each one hashed together with the scope of the request.
+func AnonymousIdempotencyMiddleware(store IdempotencyStore, scope IdempotencyScope) gin.HandlerFunc {
+	return idempotency(store, scope)
+}
+
+func idempotency(store IdempotencyStore, scope IdempotencyScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		userID := c.GetUint("userID")
+		if key == "" || (userID == 0 && scope == nil) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		if userID == 0 {
+			requestScope := scope(body)
+			if requestScope == "" {
+				c.Next()
+				return
+			}
+			scoped := sha256.Sum256([]byte(requestScope + "\n" + key))
+			key = "scoped:" + hex.EncodeToString(scoped[:])
+		}
+
+		// The query and the content type change how the body is handled, e.g. the import mode and format
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "?" + c.Request.URL.RawQuery + "\n"))
+		hash.Write([]byte(c.GetHeader("Content-Type") + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		record, err := store.Begin(key, userID, requestHash)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyKeyReused):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+			c.Abort()
+			return
+		case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			c.Abort()
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		if record.ResponseStatus != 0 {
+			replay(c, record)
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		completed := false
+		defer func() {
+			if !completed {
+				// The handler panicked, let the client retry with the same key
+				if err := store.Release(record); err != nil {
+					slog.ErrorContext(c.Request.Context(), "failed to release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		c.Next()
+		completed = true
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			err = store.Release(record)
+		} else {
+			headers := writer.Header().Clone()
+			for name := range headers {
+				if !replayable(name) {
+					delete(headers, name)
+				}
+			}
+			err = store.Complete(record, status, headers, writer.body.Bytes())
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+func replay(c *gin.Context, record *models.IdempotencyKey) {
+	var headers http.Header
+	if record.ResponseHeaders != "" {
+		if err := json.Unmarshal([]byte(record.ResponseHeaders), &headers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+	}
+	for name, values := range headers {
+		if !replayable(name) {
+			continue
+		}
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Status(record.ResponseStatus)
+	c.Writer.WriteHeaderNow()
+	if _, err := c.Writer.Write(record.ResponseBody); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to replay idempotent response", "error", err)
+	}
+	c.Abort()
+}
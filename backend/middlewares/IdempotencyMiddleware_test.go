@@ -0,0 +1,185 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*models.IdempotencyKey
+}
+
+func (s *memoryIdempotencyStore) Begin(key string, userID uint, requestHash string) (*models.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[key]; ok {
+		if existing.ResponseStatus == 0 {
+			return nil, services.ErrIdempotencyKeyInProgress
+		}
+		if existing.RequestHash != requestHash {
+			return nil, services.ErrIdempotencyKeyReused
+		}
+		return existing, nil
+	}
+	record := &models.IdempotencyKey{Key: key, UserID: userID, RequestHash: requestHash}
+	s.records[key] = record
+	return record, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(record *models.IdempotencyKey, status int, headers http.Header, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	record.ResponseStatus = status
+	record.ResponseHeaders = string(encoded)
+	record.ResponseBody = body
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(record *models.IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, record.Key)
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &memoryIdempotencyStore{records: map[string]*models.IdempotencyKey{}}
+	r := gin.New()
+	// Stands for AuthMiddleware, requests without X-User are anonymous
+	r.Use(func(c *gin.Context) {
+		if c.GetHeader("X-User") != "" {
+			c.Set("userID", uint(1))
+		}
+	})
+
+	calls := 0
+	r.POST("/items", middlewares.IdempotencyMiddleware(store), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"call": calls})
+	})
+	r.POST("/failing", middlewares.IdempotencyMiddleware(store), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "boom"})
+	})
+
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("X-User", "u")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("/items", "key-1", `{"name":"a"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.JSONEq(t, `{"call":1}`, first.Body.String())
+
+	retry := send("/items", "key-1", `{"name":"a"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.JSONEq(t, `{"call":1}`, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "application/json; charset=utf-8", retry.Header().Get("Content-Type"))
+	assert.Equal(t, 1, calls)
+
+	reused := send("/items", "key-1", `{"name":"b"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Equal(t, 1, calls)
+
+	// The query and the content type are part of the request, like the import mode and format
+	reused = send("/items?mode=all_or_nothing", "key-1", `{"name":"a"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewBufferString(`{"name":"a"}`))
+	req.Header.Set("X-User", "u")
+	req.Header.Set("Idempotency-Key", "key-1")
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	reused = httptest.NewRecorder()
+	r.ServeHTTP(reused, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Equal(t, 1, calls)
+
+	withoutKey := send("/items", "", `{"name":"a"}`)
+	assert.Equal(t, http.StatusCreated, withoutKey.Code)
+	assert.Equal(t, 2, calls)
+
+	store.records["in-flight"] = &models.IdempotencyKey{Key: "in-flight"}
+	inFlight := send("/items", "in-flight", `{"name":"a"}`)
+	assert.Equal(t, http.StatusConflict, inFlight.Code)
+	assert.Equal(t, "1", inFlight.Header().Get("Retry-After"))
+
+	// Server errors are not stored, so the retry runs the handler again
+	send("/failing", "key-2", `{}`)
+	send("/failing", "key-2", `{}`)
+	assert.Equal(t, 4, calls)
+
+	// Anonymous requests don't share a namespace of keys, the key is ignored
+	req = httptest.NewRequest(http.MethodPost, "/items", bytes.NewBufferString(`{"name":"a"}`))
+	req.Header.Set("Idempotency-Key", "key-1")
+	anonymous := httptest.NewRecorder()
+	r.ServeHTTP(anonymous, req)
+	assert.Equal(t, http.StatusCreated, anonymous.Code)
+	assert.Empty(t, anonymous.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 5, calls)
+
+	tooLarge := send("/items", "key-3", strings.Repeat("a", middlewares.MaxIdempotentBodySize+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.Code)
+	assert.Equal(t, 5, calls)
+}
+
+func TestIdempotencyMiddlewareReplaysWithCurrentRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &memoryIdempotencyStore{records: map[string]*models.IdempotencyKey{}}
+	remaining := 10
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userID", uint(1))
+		// Stands for the rate limiter, which runs before the middleware
+		remaining--
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("Retry-After", strconv.Itoa(remaining))
+	})
+	r.POST("/items", middlewares.IdempotencyMiddleware(store), func(c *gin.Context) {
+		c.Header("Location", "/items/1")
+		c.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewBufferString(`{}`))
+		req.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	send()
+	retry := send()
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, []string{"/items/1"}, retry.Header().Values("Location"))
+	assert.Equal(t, []string{"8"}, retry.Header().Values("RateLimit-Remaining"))
+	assert.Equal(t, []string{"8"}, retry.Header().Values("Retry-After"))
+	assert.NotContains(t, store.records["key-1"].ResponseHeaders, "Ratelimit")
+}
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+)
+
+// MetricsAccessMiddleware only lets the scrapers read /metrics: clients whose IP is in the allowed ones,
+// resolved through the trusted proxies, and requests carrying the configured bearer token.
+func MetricsAccessMiddleware(cfg config.Metrics) gin.HandlerFunc {
+	var networks []*net.IPNet
+	for _, allowed := range cfg.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			networks = append(networks, network)
+		} else if ip := net.ParseIP(allowed); ip != nil {
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		}
+	}
+
+	return func(c *gin.Context) {
+		if cfg.Token != "" {
+			token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if found && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) == 1 {
+				c.Next()
+				return
+			}
+		}
+		if ip := net.ParseIP(c.ClientIP()); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Metrics are only available to the configured scrapers"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,46 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+)
+
+func TestMetricsAccessMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/metrics", middlewares.MetricsAccessMiddleware(config.Metrics{Token: "scrape-token", AllowedIPs: []string{"127.0.0.1", "10.1.0.0/16"}}), func(c *gin.Context) {
+		c.String(http.StatusOK, "liven_users 1")
+	})
+
+	scrape := func(remoteAddr, authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remoteAddr
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, scrape("127.0.0.1:5000", ""))
+	assert.Equal(t, http.StatusOK, scrape("10.1.2.3:5000", ""))
+	assert.Equal(t, http.StatusForbidden, scrape("203.0.113.7:5000", ""))
+	assert.Equal(t, http.StatusForbidden, scrape("203.0.113.7:5000", "Bearer wrong-token"))
+	assert.Equal(t, http.StatusOK, scrape("203.0.113.7:5000", "Bearer scrape-token"))
+
+	// Without a token only the allowed IPs get in
+	r = gin.New()
+	r.GET("/metrics", middlewares.MetricsAccessMiddleware(config.Metrics{AllowedIPs: []string{"127.0.0.1"}}), func(c *gin.Context) {
+		c.String(http.StatusOK, "liven_users 1")
+	})
+	assert.Equal(t, http.StatusForbidden, scrape("203.0.113.7:5000", "Bearer "))
+	assert.Equal(t, http.StatusOK, scrape("127.0.0.1:5000", ""))
+}
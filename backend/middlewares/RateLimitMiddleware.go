@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/arthur-tragante/liven-code-test/metrics"
+	"github.com/arthur-tragante/liven-code-test/ratelimit"
+)
+
+// KeyFunc returns the client a request is counted against
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client IP, as resolved by gin from the trusted proxies
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per authenticated user, and per IP before AuthMiddleware has run
+func ByUser(c *gin.Context) string {
+	if userID := c.GetUint("userID"); userID != 0 {
+		return "user:" + strconv.FormatUint(uint64(userID), 10)
+	}
+	return ByIP(c)
+}
+
+// ByClient counts requests per OAuth client, as authenticated by OAuthController.AuthenticateClient,
+// and per IP before it has run
+func ByClient(c *gin.Context) string {
+	if clientID := c.GetString("clientID"); clientID != "" {
+		return "client:" + clientID
+	}
+	return ByIP(c)
+}
+
+// RateLimiter builds the middlewares enforcing the rate limit policies. Without a Store requests are not limited.
+type RateLimiter struct {
+	Store   ratelimit.Store
+	Metrics *metrics.Metrics
+}
+
+// Limit refuses requests beyond the policy with 429 Too Many Requests. Every response carries the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers, and refused
+// ones Retry-After. Requests go through when the store fails, a broken store must not take the API down.
+func (l *RateLimiter) Limit(policy ratelimit.Policy, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil || l.Store == nil {
+			c.Next()
+			return
+		}
+
+		result, err := l.Store.Take(c, policy, key(c))
+		if err != nil {
+			slog.ErrorContext(c, "rate limit store failed", "policy", policy.Name, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, seconds(policy.Period)))
+		if !result.Allowed {
+			l.Metrics.RateLimited(policy.Name)
+			c.Header("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, retry later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// seconds rounds up, so clients waiting for the advertised delay never come back too early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
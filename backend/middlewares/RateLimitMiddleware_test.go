@@ -0,0 +1,92 @@
+package middlewares_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/ratelimit"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(ctx context.Context, policy ratelimit.Policy, key string) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("database is down")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := &middlewares.RateLimiter{Store: &ratelimit.MemoryStore{}}
+	policy := ratelimit.Policy{Name: "login", Limit: 2, Period: time.Minute}
+
+	r := gin.New()
+	require.NoError(t, r.SetTrustedProxies(nil))
+	r.POST("/login", limiter.Limit(policy, middlewares.ByIP), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"token": "t"})
+	})
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = remoteAddr
+		// Ignored, the client isn't a trusted proxy
+		req.Header.Set("X-Forwarded-For", "10.9.9.9")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("1.2.3.4:5000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, send("1.2.3.4:5001").Code)
+
+	w = send("1.2.3.4:5002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Too many requests, retry later"}`, w.Body.String())
+
+	assert.Equal(t, http.StatusOK, send("5.6.7.8:5000").Code)
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/user/", nil)
+	c.Request.RemoteAddr = "1.2.3.4:5000"
+
+	assert.Equal(t, "ip:1.2.3.4", middlewares.ByUser(c))
+	c.Set("userID", uint(42))
+	assert.Equal(t, "user:42", middlewares.ByUser(c))
+	assert.Equal(t, "ip:1.2.3.4", middlewares.ByIP(c))
+
+	assert.Equal(t, "ip:1.2.3.4", middlewares.ByClient(c))
+	c.Set("clientID", "reports")
+	assert.Equal(t, "client:reports", middlewares.ByClient(c))
+}
+
+func TestRateLimitMiddlewareFailsOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	limiter := &middlewares.RateLimiter{Store: failingRateLimitStore{}}
+	r.GET("/", limiter.Limit(ratelimit.Policy{Name: "user", Limit: 1, Period: time.Minute}, middlewares.ByIP), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleChecker is implemented by services.UserService
+type RoleChecker interface {
+	HasRole(ctx context.Context, userID uint, role string) (bool, error)
+}
+
+// RoleMiddleware only lets through the users authenticated by AuthMiddleware that have the role.
+// The role is looked up on every request, so taking it away applies to the tokens already issued.
+func RoleMiddleware(checker RoleChecker, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := checker.HasRole(c.Request.Context(), c.GetUint("userID"), role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires the " + role + " role"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,166 @@
+package migrations_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/database"
+	"github.com/arthur-tragante/liven-code-test/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	source := fstest.MapFS{
+		"0002_add_phone.up.sql":        {Data: []byte("ALTER TABLE users ADD COLUMN phone TEXT;")},
+		"0002_add_phone.down.sql":      {Data: []byte("ALTER TABLE users DROP COLUMN phone;")},
+		"0001_initial_schema.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT);")},
+		"0001_initial_schema.down.sql": {Data: []byte("DROP TABLE users;")},
+		"README.md":                    {Data: []byte("ignored")},
+	}
+
+	loaded, err := migrations.Load(source)
+	require.NoError(t, err)
+	require.Len(t, loaded, 2)
+	assert.Equal(t, 1, loaded[0].Version)
+	assert.Equal(t, "initial_schema", loaded[0].Name)
+	assert.Equal(t, 2, loaded[1].Version)
+	assert.Equal(t, "ALTER TABLE users DROP COLUMN phone;", loaded[1].Down)
+}
+
+func TestLoadRejectsInvalidSources(t *testing.T) {
+	tests := []struct {
+		name   string
+		source fstest.MapFS
+	}{
+		{
+			name:   "Missing Down Script",
+			source: fstest.MapFS{"0001_initial.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name:   "Invalid File Name",
+			source: fstest.MapFS{"initial.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "Conflicting Names",
+			source: fstest.MapFS{
+				"0001_first.up.sql":    {Data: []byte("SELECT 1;")},
+				"0001_second.down.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migrations.Load(tt.source)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	postgres, err := migrations.Embedded("postgres")
+	require.NoError(t, err)
+	require.NotEmpty(t, postgres)
+
+	for i, migration := range postgres {
+		assert.Equal(t, i+1, migration.Version, "migration versions must be contiguous")
+	}
+
+	// Every dialect must provide the same migrations
+	for _, dialect := range migrations.Dialects {
+		loaded, err := migrations.Embedded(dialect)
+		require.NoError(t, err)
+		require.Len(t, loaded, len(postgres), dialect)
+		for i := range loaded {
+			assert.Equal(t, postgres[i].Version, loaded[i].Version, dialect)
+			assert.Equal(t, postgres[i].Name, loaded[i].Name, dialect)
+		}
+	}
+
+	_, err = migrations.Embedded("mysql")
+	assert.Error(t, err)
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	for _, dialect := range migrations.Dialects {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, dialect), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sqlite", "0007_existing.up.sql"), []byte("SELECT 1;"), 0o644))
+
+	paths, err := migrations.Create(dir, "Add Phone Column")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "postgres", "0008_add_phone_column.up.sql"),
+		filepath.Join(dir, "postgres", "0008_add_phone_column.down.sql"),
+		filepath.Join(dir, "sqlite", "0008_add_phone_column.up.sql"),
+		filepath.Join(dir, "sqlite", "0008_add_phone_column.down.sql"),
+	}, paths)
+
+	_, err = migrations.Create(dir, "bad-name!")
+	assert.Error(t, err)
+}
+
+func TestUpAndDownOnSQLite(t *testing.T) {
+	db, err := database.Open(config.Database{Driver: config.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")}, nil)
+	require.NoError(t, err)
+	migrator, err := migrations.New(db)
+	require.NoError(t, err)
+
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrator.Migrations))
+	pending, err := migrator.Pending()
+	require.NoError(t, err)
+	assert.Zero(t, pending)
+	assert.True(t, db.Migrator().HasColumn("users", "tokens_revoked_at"))
+
+	// Every down script must undo its up script
+	reverted, err := migrator.Down(len(migrator.Migrations))
+	require.NoError(t, err)
+	assert.Len(t, reverted, len(migrator.Migrations))
+	assert.False(t, db.Migrator().HasTable("users"))
+
+	applied, err = migrator.Up()
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrator.Migrations))
+}
+
+func TestUpAdoptsAutoMigrateSchema(t *testing.T) {
+	db, err := database.Open(config.Database{Driver: config.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")}, nil)
+	require.NoError(t, err)
+	// The tables as AutoMigrate created them before the versions were added
+	type User struct {
+		gorm.Model
+		Name     string `gorm:"not null"`
+		Email    string `gorm:"unique;not null"`
+		Password string `gorm:"not null"`
+	}
+	type Address struct {
+		AddressID uint `gorm:"primaryKey"`
+		UserID    uint
+		Street    string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+		DeletedAt gorm.DeletedAt `gorm:"index"`
+	}
+	require.NoError(t, db.AutoMigrate(&User{}, &Address{}))
+	require.NoError(t, db.Create(&User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "hash"}).Error)
+
+	migrator, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	assert.True(t, db.Migrator().HasColumn("addresses", "version"))
+	var version int
+	require.NoError(t, db.Raw("SELECT version FROM users WHERE email = ?", "jane.doe@example.com").Scan(&version).Error)
+	assert.Equal(t, 1, version)
+}
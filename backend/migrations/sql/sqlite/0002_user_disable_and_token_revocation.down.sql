@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS revoked_tokens;
+
+ALTER TABLE users DROP COLUMN tokens_revoked_at;
+ALTER TABLE users DROP COLUMN disabled_at;
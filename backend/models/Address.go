@@ -1,6 +1,7 @@
 package models
 
 import (
+	"log/slog"
 	"time"
 
 	"gorm.io/gorm"
@@ -16,7 +17,19 @@ type Address struct {
 	State      string         `json:"state"`
 	Zipcode    string         `json:"zipcode"`
 	Country    string         `json:"country"`
+	Version    uint           `gorm:"not null;default:1" json:"version"`
 	CreatedAt  time.Time      `json:"created_at"`
 	UpdatedAt  time.Time      `json:"updated_at"`
 	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
-}
\ No newline at end of file
+}
+
+// BeforeCreate makes every new address start at version 1, whatever the client sent
+func (a *Address) BeforeCreate(tx *gorm.DB) error {
+	a.Version = 1
+	return nil
+}
+
+// LogValue keeps the location out of the logs
+func (a Address) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("address_id", uint64(a.AddressID)), slog.Uint64("user_id", uint64(a.UserID)))
+}
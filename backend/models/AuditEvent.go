@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// AuditEvent records a security relevant action, it is never changed nor deleted once written.
+// UserID is the account the event belongs to, ActorID who performed the action: the same user,
+// an admin, or nobody for admin commands and failed logins.
+type AuditEvent struct {
+	ID         uint         `gorm:"primaryKey" json:"id"`
+	OccurredAt time.Time    `gorm:"not null" json:"occurred_at"`
+	ActorID    *uint        `json:"actor_id,omitempty"`
+	UserID     *uint        `json:"user_id,omitempty"`
+	Action     string       `gorm:"not null;size:64" json:"action"`
+	TargetType string       `gorm:"not null;size:32" json:"target_type"`
+	TargetID   string       `gorm:"not null;size:64" json:"target_id,omitempty"`
+	IP         string       `gorm:"not null;size:64" json:"ip,omitempty"`
+	RequestID  string       `gorm:"not null;size:128" json:"request_id,omitempty"`
+	Changes    AuditChanges `gorm:"not null;type:text" json:"changes,omitempty"`
+}
+
+// AuditChange is the value of a field before and after the action, nil when the field didn't exist on that side
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditChanges maps the changed fields to their values, stored as a JSON object
+type AuditChanges map[string]AuditChange
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	encoded, err := json.Marshal(c)
+	return string(encoded), err
+}
+
+func (c *AuditChanges) Scan(value any) error {
+	var encoded []byte
+	switch value := value.(type) {
+	case string:
+		encoded = []byte(value)
+	case []byte:
+		encoded = value
+	case nil:
+		*c = nil
+		return nil
+	default:
+		return errors.New("unsupported audit changes value")
+	}
+	var changes AuditChanges
+	if err := json.Unmarshal(encoded, &changes); err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		changes = nil
+	}
+	*c = changes
+	return nil
+}
+
+// LogValue keeps the changed values and the IP out of the logs
+func (e AuditEvent) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(e.ID)), slog.String("action", e.Action))
+}
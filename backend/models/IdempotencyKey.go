@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// IdempotencyKey stores the first response produced for an Idempotency-Key so retries can be replayed.
+// ResponseStatus stays 0 while the original request is still being processed.
+type IdempotencyKey struct {
+	ID              uint   `gorm:"primaryKey"`
+	Key             string `gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_keys_scope"`
+	UserID          uint   `gorm:"not null;uniqueIndex:idx_idempotency_keys_scope"`
+	RequestHash     string `gorm:"not null"`
+	ResponseStatus  int    `gorm:"not null;default:0"`
+	ResponseHeaders string `gorm:"type:text"`
+	ResponseBody    []byte
+	CreatedAt       time.Time
+	ExpiresAt       time.Time `gorm:"index;not null"`
+}
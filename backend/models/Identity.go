@@ -0,0 +1,22 @@
+package models
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Identity links a user to an account at an OpenID Connect provider, Subject being the provider's
+// ID of that account. Email is the one the provider asserted when the identity was linked.
+type Identity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	Provider  string    `gorm:"not null;size:64;uniqueIndex:idx_identities_provider_subject" json:"provider"`
+	Subject   string    `gorm:"not null;size:255;uniqueIndex:idx_identities_provider_subject" json:"-"`
+	Email     string    `gorm:"not null;size:255" json:"email"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+// LogValue keeps the subject and email out of the logs
+func (i Identity) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(i.ID)), slog.String("provider", i.Provider), slog.Uint64("user_id", uint64(i.UserID)))
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAuthClient is an application using the accounts of this API through OpenID Connect.
+// SecretHash is the SHA-256 of its secret, empty for public clients which can't keep one.
+type OAuthClient struct {
+	ID           uint       `gorm:"primaryKey" json:"-"`
+	ClientID     string     `gorm:"not null;uniqueIndex;size:64" json:"client_id"`
+	Name         string     `gorm:"not null;size:255" json:"name"`
+	SecretHash   string     `gorm:"not null;size:64" json:"-"`
+	RedirectURIs StringList `gorm:"not null;type:text" json:"redirect_uris"`
+	Scopes       StringList `gorm:"not null;type:text" json:"scopes"`
+	GrantTypes   StringList `gorm:"not null;type:text" json:"grant_types"`
+	CreatedAt    time.Time  `gorm:"not null" json:"created_at"`
+}
+
+func (OAuthClient) TableName() string { return "oauth_clients" }
+
+// Public tells whether the client has no secret and must prove itself with PKCE
+func (c OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// LogValue keeps the secret hash out of the logs
+func (c OAuthClient) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("client_id", c.ClientID), slog.String("name", c.Name))
+}
+
+// OAuthConsent holds the scopes a user allowed a client to access
+type OAuthConsent struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;uniqueIndex:idx_oauth_consents_user_client"`
+	ClientID  string     `gorm:"not null;size:64;uniqueIndex:idx_oauth_consents_user_client"`
+	Scopes    StringList `gorm:"not null;type:text"`
+	UpdatedAt time.Time  `gorm:"not null"`
+}
+
+func (OAuthConsent) TableName() string { return "oauth_consents" }
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens, stored as the SHA-256 of the code
+type OAuthCode struct {
+	CodeHash      string     `gorm:"primaryKey;size:64"`
+	ClientID      string     `gorm:"not null;size:64"`
+	UserID        uint       `gorm:"not null"`
+	RedirectURI   string     `gorm:"not null;type:text"`
+	Scopes        StringList `gorm:"not null;type:text"`
+	Nonce         string     `gorm:"not null;size:255"`
+	CodeChallenge string     `gorm:"not null;size:128"`
+	AuthTime      time.Time  `gorm:"not null"`
+	ExpiresAt     time.Time  `gorm:"not null;index"`
+}
+
+func (OAuthCode) TableName() string { return "oauth_codes" }
+
+// OAuthRefreshToken is a refresh token issued to a client, stored as the SHA-256 of the token.
+// Refreshing revokes it for a new one, so a revoked token coming back means it leaked.
+type OAuthRefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`
+	TokenHash string     `gorm:"not null;uniqueIndex;size:64"`
+	ClientID  string     `gorm:"not null;size:64;index:idx_oauth_refresh_tokens_user_client"`
+	UserID    uint       `gorm:"not null;index:idx_oauth_refresh_tokens_user_client"`
+	Scopes    StringList `gorm:"not null;type:text"`
+	AuthTime  time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	ExpiresAt time.Time  `gorm:"not null;index"`
+	RevokedAt *time.Time
+}
+
+func (OAuthRefreshToken) TableName() string { return "oauth_refresh_tokens" }
+
+// StringList is stored as its values separated by spaces, the way OAuth writes scopes
+type StringList []string
+
+// Contains tells whether every value is in the list
+func (l StringList) Contains(values ...string) bool {
+	for _, value := range values {
+		if !slices.Contains(l, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l StringList) String() string {
+	return strings.Join(l, " ")
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	return l.String(), nil
+}
+
+func (l *StringList) Scan(value any) error {
+	switch value := value.(type) {
+	case string:
+		*l = strings.Fields(value)
+	case []byte:
+		*l = strings.Fields(string(value))
+	case nil:
+		*l = nil
+	default:
+		return errors.New("unsupported string list value")
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordHistory keeps a password hash a user replaced, so that it can't be picked again
+type PasswordHistory struct {
+	ID           uint      `gorm:"primaryKey"`
+	UserID       uint      `gorm:"not null;index:idx_password_history_user_id"`
+	PasswordHash string    `gorm:"not null"`
+	CreatedAt    time.Time `gorm:"not null;index:idx_password_history_user_id"`
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// RateLimitBucket is the token bucket of one rate limit key, shared by every instance.
+// Rows can be dropped once FullAt has passed since the bucket is then back to its full capacity.
+type RateLimitBucket struct {
+	Key       string    `gorm:"column:bucket_key;primaryKey;size:255"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;autoUpdateTime:false"`
+	FullAt    time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+// RevokedToken is a JWT rejected before its expiry. Rows can be dropped once ExpiresAt has passed.
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey;size:64" json:"token_id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	RevokedAt time.Time `gorm:"not null" json:"revoked_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Session is a successful login and the device it came from. Its token stops working once it is revoked.
+// Sessions outlive their expiry for a while, to recognize the devices a user logged in from.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index:idx_sessions_user_id" json:"-"`
+	TokenID    string     `gorm:"not null;uniqueIndex;size:64" json:"-"`
+	UserAgent  string     `gorm:"not null;size:512" json:"user_agent"`
+	IP         string     `gorm:"not null;size:64" json:"ip"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	LastSeenAt time.Time  `gorm:"not null;index:idx_sessions_user_id" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	// Current marks the session of the request listing the sessions
+	Current bool `gorm:"-" json:"current"`
+}
+
+// LogValue keeps the IP and user agent out of the logs
+func (s Session) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(s.ID)), slog.Uint64("user_id", uint64(s.UserID)))
+}
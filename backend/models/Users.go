@@ -1,13 +1,44 @@
 package models
 
 import (
+	"log/slog"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Roles of a user
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	gorm.Model
 	Name      string    `json:"name" gorm:"not null"`
 	Email     string    `json:"email" gorm:"unique;not null"`
-	Password  string    `json:"password" gorm:"not null"`
+	Password  string    `json:"-" gorm:"not null"`
+	Version   uint      `json:"version" gorm:"not null;default:1"`
 	Addresses []Address `json:"addresses"`
+	// DisabledAt blocks logins and invalidates every token of the user while set
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// Tokens issued before TokensRevokedAt are rejected
+	TokensRevokedAt *time.Time `json:"-"`
+	// Role is RoleUser, or RoleAdmin for the users allowed on the /admin endpoints
+	Role string `json:"role" gorm:"not null;size:16;default:user"`
+}
+
+// BeforeCreate makes every new user start at version 1, whatever the client sent,
+// and with the user role unless another one was set on purpose
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	u.Version = 1
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	return nil
+}
+
+// LogValue keeps the personal data of the user out of the logs
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(u.ID)))
 }
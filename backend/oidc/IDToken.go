@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// clockSkew is the difference between our clock and the provider's tolerated on the token times
+const clockSkew = time.Minute
+
+// signingMethods are the ID token algorithms accepted. HS256 is left out, its key would be the client secret.
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// Claims is what the provider asserts about the user in the ID token
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Verify checks the signature of the ID token against the provider's keys, its issuer, audience,
+// expiry and nonce, and returns its claims
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The claims are checked below, jwt-go doesn't allow for clock skew
+	parser := &jwt.Parser{ValidMethods: signingMethods, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if issuer, _ := claims["iss"].(string); issuer != metadata.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q instead of %q", issuer, metadata.Issuer)
+	}
+	if err := p.checkAudience(claims); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if now.After(time.Unix(int64(expiresAt), 0).Add(clockSkew)) {
+		return nil, errors.New("ID token expired")
+	}
+	if issuedAt, ok := claims["iat"].(float64); ok && time.Unix(int64(issuedAt), 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("ID token issued in the future")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || tokenNonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	result := &Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	if result.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	// Some providers send the flag as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	return result, nil
+}
+
+// checkAudience requires the client among the audiences, and as the authorized party when there are others
+func (p *Provider) checkAudience(claims jwt.MapClaims) error {
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, value := range aud {
+			if value, ok := value.(string); ok {
+				audiences = append(audiences, value)
+			}
+		}
+	}
+	found := false
+	for _, audience := range audiences {
+		found = found || audience == p.ClientID
+	}
+	if !found {
+		return errors.New("ID token is not meant for this client")
+	}
+	if azp, ok := claims["azp"].(string); (ok || len(audiences) > 1) && azp != p.ClientID {
+		return errors.New("ID token was issued to another client")
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+const redirectURL = "https://api.example.com/auth/mock/callback"
+
+func login(t *testing.T, mock *testutils.MockOIDCProvider, provider *oidc.Provider, nonce string) (*oidc.Claims, error) {
+	ctx := context.Background()
+	verifier, err := oidc.RandomString()
+	require.NoError(t, err)
+
+	authURL, err := provider.AuthCodeURL(ctx, "the-state", nonce, verifier)
+	require.NoError(t, err)
+	callback := mock.Authorize(t, authURL)
+	assert.Equal(t, "the-state", callback.Query().Get("state"))
+
+	return provider.Exchange(ctx, callback.Query().Get("code"), verifier, nonce)
+}
+
+func TestLogin(t *testing.T) {
+	mock := testutils.NewMockOIDCProvider(t, "client", "client-secret")
+	mock.SetUser(testutils.OIDCUser{Subject: "1234", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"})
+	provider := mock.Provider("mock", redirectURL)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, redirectURL, parsed.Query().Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+	assert.Equal(t, oidc.CodeChallenge("verifier"), parsed.Query().Get("code_challenge"))
+
+	claims, err := login(t, mock, provider, "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, &oidc.Claims{Subject: "1234", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"}, claims)
+}
+
+func TestExchangeChecksPKCEAndClient(t *testing.T) {
+	ctx := context.Background()
+	mock := testutils.NewMockOIDCProvider(t, "client", "client-secret")
+	mock.SetUser(testutils.OIDCUser{Subject: "1234"})
+	provider := mock.Provider("mock", redirectURL)
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	require.NoError(t, err)
+	code := mock.Authorize(t, authURL).Query().Get("code")
+	_, err = provider.Exchange(ctx, code, "another-verifier", "nonce")
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	wrongSecret := mock.Provider("mock", redirectURL)
+	wrongSecret.ClientSecret = "guess"
+	code = mock.Authorize(t, authURL).Query().Get("code")
+	_, err = wrongSecret.Exchange(ctx, code, "verifier", "nonce")
+	assert.ErrorContains(t, err, "invalid_client")
+
+	// A refused login comes back without a code
+	mock.SetUser(testutils.OIDCUser{})
+	assert.Equal(t, "access_denied", mock.Authorize(t, authURL).Query().Get("error"))
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	mock := testutils.NewMockOIDCProvider(t, "client", "client-secret")
+	provider := mock.Provider("mock", redirectURL)
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   mock.Issuer(),
+			"sub":   "1234",
+			"aud":   "client",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": "nonce",
+		}
+	}
+	with := func(name string, value any) string {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return mock.SignIDToken(claims)
+	}
+
+	claims, err := provider.Verify(ctx, mock.SignIDToken(valid()), "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, "1234", claims.Subject)
+	claims, err = provider.Verify(ctx, with("email_verified", "true"), "nonce")
+	require.NoError(t, err)
+	assert.True(t, claims.EmailVerified)
+	_, err = provider.Verify(ctx, with("aud", []string{"other", "client"}), "nonce")
+	assert.ErrorContains(t, err, "another client")
+	_, err = provider.Verify(ctx, with("azp", "client"), "nonce")
+	assert.NoError(t, err)
+
+	for name, token := range map[string]string{
+		"other issuer":   with("iss", "https://evil.example.com"),
+		"other audience": with("aud", "other"),
+		"expired":        with("exp", time.Now().Add(-2*time.Minute).Unix()),
+		"no expiry":      with("exp", nil),
+		"future":         with("iat", time.Now().Add(time.Hour).Unix()),
+		"no subject":     with("sub", nil),
+		"other nonce":    with("nonce", "replayed"),
+		"malformed":      "not.a.token",
+	} {
+		_, err := provider.Verify(ctx, token, "nonce")
+		assert.Error(t, err, name)
+	}
+
+	// Signed by another key, or with the symmetric algorithms
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, valid())
+	forged.Header["kid"] = "mock-key"
+	signed, err := forged.SignedString(otherKey)
+	require.NoError(t, err)
+	_, err = provider.Verify(ctx, signed, "nonce")
+	assert.ErrorContains(t, err, "verification error")
+
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, valid()).SignedString([]byte("client-secret"))
+	require.NoError(t, err)
+	_, err = provider.Verify(ctx, signed, "nonce")
+	assert.ErrorContains(t, err, "signing method")
+}
+
+func TestDiscoveryChecksTheIssuer(t *testing.T) {
+	mock := testutils.NewMockOIDCProvider(t, "client", "client-secret")
+	provider := mock.Provider("mock", redirectURL)
+	provider.Issuer = mock.Issuer() + "/"
+
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	assert.ErrorContains(t, err, "instead of")
+}
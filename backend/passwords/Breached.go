@@ -0,0 +1,46 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedDirectory checks passwords against a local copy of the Have I Been Pwned dataset, so the
+// check works offline and no password or hash ever leaves the server. Dir holds one file per SHA-1
+// prefix, named after the first 5 uppercase hex characters of the hash (e.g. "21BD1.txt"), with a
+// "SUFFIX:COUNT" line per breached hash, the format of the k-anonymity range API and of the
+// official downloader with -s false (haveibeenpwned-downloader -s false).
+type BreachedDirectory struct {
+	Dir string
+}
+
+func (d *BreachedDirectory) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		// No breached hash starts with this prefix
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// Padded responses list fake suffixes with a count of 0
+		if strings.EqualFold(candidate, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,139 @@
+package passwords
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lengths used when Policy leaves them at zero
+const (
+	DefaultMinLength = 8
+	DefaultMaxLength = 128
+)
+
+// ErrRejected is wrapped by every error reporting a password that doesn't satisfy the policy
+var ErrRejected = errors.New("password does not meet the password policy")
+
+// PolicyError lists every reason a password was rejected
+type PolicyError struct {
+	Problems []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password rejected: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrRejected
+}
+
+// Policy is what a new password must satisfy. The zero value only enforces the default lengths.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MaxBytes, when set, also caps the length in bytes of the UTF-8 encoding, for hashes that ignore the rest
+	MaxBytes int
+	// MinCharacterClasses is how many of lowercase letters, uppercase letters, digits and symbols the password must mix
+	MinCharacterClasses int
+	// History is how many previous passwords of a user can't be picked again
+	History int
+	// Breached, when set, rejects passwords found in data breaches
+	Breached BreachChecker
+}
+
+// BreachChecker tells whether a password appeared in a data breach
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+func (p *Policy) minLength() int {
+	if p.MinLength <= 0 {
+		return DefaultMinLength
+	}
+	return p.MinLength
+}
+
+func (p *Policy) maxLength() int {
+	if p.MaxLength <= 0 {
+		return DefaultMaxLength
+	}
+	return p.MaxLength
+}
+
+// Check validates password for the user with the given name and email. It returns a *PolicyError
+// listing every problem, or another error when the breach check could not run. The history is
+// checked by the caller, since it needs the stored hashes.
+func (p *Policy) Check(password, name, email string) error {
+	var problems []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength() {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.minLength()))
+	}
+	if length > p.maxLength() {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters long", p.maxLength()))
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes long, accented letters and symbols take several", p.MaxBytes))
+	}
+	if classes := characterClasses(password); classes < p.MinCharacterClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharacterClasses))
+	}
+	if containsPersonalInfo(password, name, email) {
+		problems = append(problems, "must not contain your name or email")
+	}
+
+	if len(problems) == 0 && p.Breached != nil {
+		breached, err := p.Breached.Breached(password)
+		if err != nil {
+			return fmt.Errorf("breached password check failed: %w", err)
+		}
+		if breached {
+			problems = append(problems, "appeared in a data breach, choose another one")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PolicyError{Problems: problems}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// containsPersonalInfo looks for the local part of the email and each part of the name, ignoring
+// case and the parts too short to be meaningful
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+	local, _, _ := strings.Cut(email, "@")
+	parts := append(strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }), local)
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(password, strings.ToLower(part)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package passwords_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/passwords"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := &passwords.Policy{MinCharacterClasses: 3}
+
+	for _, tc := range []struct {
+		password string
+		problems []string
+	}{
+		{"Tr0ubadour&3", nil},
+		{"1", []string{"at least 8 characters", "at least 3 of"}},
+		{"alllowercase", []string{"at least 3 of"}},
+		{"Jane-Secret-99", []string{"name or email"}},
+		{"x" + "Jdoe!2024", []string{"name or email"}},
+		{strings.Repeat("Ab1!", 40), []string{"at most 128 characters"}},
+	} {
+		err := policy.Check(tc.password, "Jane Doe", "jdoe@example.com")
+		if tc.problems == nil {
+			assert.NoError(t, err, tc.password)
+			continue
+		}
+		var policyErr *passwords.PolicyError
+		require.ErrorAs(t, err, &policyErr, tc.password)
+		assert.ErrorIs(t, err, passwords.ErrRejected)
+		require.Len(t, policyErr.Problems, len(tc.problems), tc.password)
+		for i, problem := range tc.problems {
+			assert.Contains(t, policyErr.Problems[i], problem)
+		}
+	}
+
+	// MaxBytes counts the encoding, not the characters
+	bytesPolicy := &passwords.Policy{MaxBytes: 72}
+	assert.NoError(t, bytesPolicy.Check(strings.Repeat("a", 72), "Jane Doe", "jdoe@example.com"))
+	var policyErr *passwords.PolicyError
+	require.ErrorAs(t, bytesPolicy.Check(strings.Repeat("é", 40), "Jane Doe", "jdoe@example.com"), &policyErr)
+	assert.Equal(t, []string{"must be at most 72 bytes long, accented letters and symbols take several"}, policyErr.Problems)
+
+	// Short name parts don't make every password containing them personal
+	assert.NoError(t, (&passwords.Policy{}).Check("correct horse battery", "Al Li", "al@example.com"))
+}
+
+func TestBreachedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	breached := hashOf("password123")
+	padded := hashOf("correct horse battery staple")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, breached[:5]+".txt"),
+		[]byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"+strings.ToLower(breached[5:])+":251682\r\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, padded[:5]+".txt"), []byte(padded[5:]+":0\r\n"), 0o644))
+
+	checker := &passwords.BreachedDirectory{Dir: dir}
+	found, err := checker.Breached("password123")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = checker.Breached("correct horse battery staple")
+	assert.NoError(t, err)
+	assert.False(t, found, "padding entries have a count of 0")
+
+	found, err = checker.Breached("Tr0ubadour&3")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	policy := &passwords.Policy{Breached: checker}
+	assert.ErrorContains(t, policy.Check("password123", "Jane Doe", "jane@example.com"), "data breach")
+
+	policy.Breached = failingChecker{}
+	err = policy.Check("Tr0ubadour&3", "Jane Doe", "jane@example.com")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, passwords.ErrRejected)
+}
+
+type failingChecker struct{}
+
+func (failingChecker) Breached(string) (bool, error) {
+	return false, errors.New("permission denied")
+}
+
+func hashOf(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
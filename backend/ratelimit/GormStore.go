@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// GormStore keeps the buckets in the rate_limit_buckets table, so every instance sharing the
+// database enforces the same limits. Each take locks the row of its bucket for the duration of a
+// short transaction. Buckets that are full again are dropped by services.PurgeService.
+type GormStore struct {
+	DB *gorm.DB
+	// Clock returns the current time, time.Now when nil
+	Clock func() time.Time
+}
+
+func (s *GormStore) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock()
+}
+
+func (s *GormStore) Take(ctx context.Context, policy Policy, key string) (Result, error) {
+	now := s.now()
+	var result Result
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bucket := models.RateLimitBucket{
+			Key:       bucketKey(policy, key),
+			Tokens:    float64(policy.Limit),
+			UpdatedAt: now,
+			FullAt:    now,
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&bucket).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("bucket_key = ?", bucket.Key).First(&bucket).Error; err != nil {
+			return err
+		}
+
+		var fullAt time.Time
+		bucket.Tokens, fullAt, result = policy.take(bucket.Tokens, bucket.UpdatedAt, now)
+		return tx.Model(&models.RateLimitBucket{}).Where("bucket_key = ?", bucket.Key).Updates(map[string]interface{}{
+			"tokens":     bucket.Tokens,
+			"updated_at": now,
+			"full_at":    fullAt,
+		}).Error
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops the buckets that are full again
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in the process. Every instance counts on its own, so with several
+// instances behind a load balancer a client gets up to the limit on each of them.
+type MemoryStore struct {
+	// Clock returns the current time, time.Now when nil
+	Clock func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func (s *MemoryStore) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock()
+}
+
+func (s *MemoryStore) Take(ctx context.Context, policy Policy, key string) (Result, error) {
+	now := s.now()
+	key = bucketKey(policy, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets == nil {
+		s.buckets = map[string]memoryBucket{}
+	}
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, bucket := range s.buckets {
+			if !now.Before(bucket.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = memoryBucket{tokens: float64(policy.Limit), updatedAt: now}
+	}
+	tokens, fullAt, result := policy.take(bucket.tokens, bucket.updatedAt, now)
+	s.buckets[key] = memoryBucket{tokens: tokens, updatedAt: now, fullAt: fullAt}
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Policy allows Limit requests per Period for each key. Requests are drawn from a token bucket holding
+// up to Limit tokens and refilled continuously, so a client can burst up to Limit and then has to
+// follow the refill rate.
+type Policy struct {
+	// Name identifies the policy in bucket keys, headers and metrics
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next token, set when the request was refused
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. key identifies the client within the policy (an IP, a user or an API key).
+type Store interface {
+	Take(ctx context.Context, policy Policy, key string) (Result, error)
+}
+
+// bucketKey scopes the client key to the policy, so one client has a separate bucket per policy
+func bucketKey(policy Policy, key string) string {
+	return policy.Name + ":" + key
+}
+
+// rate is the number of tokens added per second
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// take refills a bucket holding tokens at updatedAt up to now and tries to draw one token from it.
+// It returns the tokens left and when the bucket will be full again.
+func (p Policy) take(tokens float64, updatedAt, now time.Time) (float64, time.Time, Result) {
+	if elapsed := now.Sub(updatedAt); elapsed > 0 {
+		tokens = math.Min(float64(p.Limit), tokens+elapsed.Seconds()*p.rate())
+	}
+
+	result := Result{Limit: p.Limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = p.duration(1 - tokens)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = p.duration(float64(p.Limit) - tokens)
+	return tokens, now.Add(result.Reset), result
+}
+
+// duration is the time needed to refill the given number of tokens
+func (p Policy) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / p.rate() * float64(time.Second)))
+}
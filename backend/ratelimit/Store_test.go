@@ -0,0 +1,97 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/ratelimit"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+// clock is a fake time source advanced by hand
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// Both stores must count the same way, whether the buckets live in memory or in the database
+func TestStoresBurstThenRefill(t *testing.T) {
+	stores := map[string]func(t *testing.T, clock *clock) ratelimit.Store{
+		"memory": func(t *testing.T, clock *clock) ratelimit.Store {
+			return &ratelimit.MemoryStore{Clock: clock.Now}
+		},
+		"database": func(t *testing.T, clock *clock) ratelimit.Store {
+			return &ratelimit.GormStore{DB: testutils.NewTestDB(t), Clock: clock.Now}
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			clock := &clock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+			store := newStore(t, clock)
+			policy := ratelimit.Policy{Name: "login", Limit: 3, Period: time.Minute}
+
+			for i := 2; i >= 0; i-- {
+				result, err := store.Take(context.Background(), policy, "ip:1.2.3.4")
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, 3, result.Limit)
+				assert.Equal(t, i, result.Remaining)
+			}
+
+			result, err := store.Take(context.Background(), policy, "ip:1.2.3.4")
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, 0, result.Remaining)
+			// One token every 20 seconds
+			assert.Equal(t, 20*time.Second, result.RetryAfter)
+			assert.Equal(t, time.Minute, result.Reset)
+
+			// Other clients and other policies have buckets of their own
+			result, err = store.Take(context.Background(), policy, "ip:5.6.7.8")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			result, err = store.Take(context.Background(), ratelimit.Policy{Name: "register", Limit: 3, Period: time.Minute}, "ip:1.2.3.4")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+
+			clock.Advance(20 * time.Second)
+			result, err = store.Take(context.Background(), policy, "ip:1.2.3.4")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 0, result.Remaining)
+
+			// An idle bucket refills up to the limit and no further
+			clock.Advance(time.Hour)
+			result, err = store.Take(context.Background(), policy, "ip:1.2.3.4")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 2, result.Remaining)
+		})
+	}
+}
+
+func TestGormStoreIsSharedBetweenInstances(t *testing.T) {
+	db := testutils.NewTestDB(t)
+	policy := ratelimit.Policy{Name: "login", Limit: 2, Period: time.Hour}
+	first := &ratelimit.GormStore{DB: db}
+	second := &ratelimit.GormStore{DB: db}
+
+	_, err := first.Take(context.Background(), policy, "user:1")
+	require.NoError(t, err)
+	_, err = second.Take(context.Background(), policy, "user:1")
+	require.NoError(t, err)
+	result, err := first.Take(context.Background(), policy, "user:1")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	var bucket models.RateLimitBucket
+	require.NoError(t, db.First(&bucket, "bucket_key = ?", "login:user:1").Error)
+	assert.True(t, bucket.FullAt.After(time.Now()))
+}
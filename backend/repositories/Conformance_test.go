@@ -0,0 +1,658 @@
+package repositories_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+// ConformanceSuite checks the behaviour every repository implementation must share.
+// Implementations provide fresh, empty repositories through Reset before each test.
+type ConformanceSuite struct {
+	suite.Suite
+	Reset      func(t *testing.T) (repositories.UserRepository, repositories.AddressRepository, repositories.SessionRepository, repositories.IdentityRepository, repositories.AuditRepository)
+	Users      repositories.UserRepository
+	Addresses  repositories.AddressRepository
+	Sessions   repositories.SessionRepository
+	Identities repositories.IdentityRepository
+	Audit      repositories.AuditRepository
+	ctx        context.Context
+}
+
+func (suite *ConformanceSuite) SetupTest() {
+	suite.ctx = context.Background()
+	suite.Users, suite.Addresses, suite.Sessions, suite.Identities, suite.Audit = suite.Reset(suite.T())
+}
+
+func (suite *ConformanceSuite) createUser(email string) *models.User {
+	user := &models.User{Name: "John Doe", Email: email, Password: "hash"}
+	suite.Require().NoError(suite.Users.Create(suite.ctx, user))
+	return user
+}
+
+func (suite *ConformanceSuite) createAddress(userID uint, street string) *models.Address {
+	address := &models.Address{UserID: userID, Street: street, City: "City", State: "ST", Zipcode: "12345", Country: "Country"}
+	suite.Require().NoError(suite.Addresses.Create(suite.ctx, address))
+	return address
+}
+
+func (suite *ConformanceSuite) TestCreateUser() {
+	user := suite.createUser("john.doe@example.com")
+	assert.NotZero(suite.T(), user.ID)
+	assert.Equal(suite.T(), uint(1), user.Version)
+	assert.False(suite.T(), user.CreatedAt.IsZero())
+
+	err := suite.Users.Create(suite.ctx, &models.User{Name: "Other", Email: "john.doe@example.com", Password: "hash"})
+	assert.ErrorIs(suite.T(), err, repositories.ErrEmailTaken)
+
+	// Deleted accounts keep their email until they are purged
+	assert.NoError(suite.T(), suite.Users.SoftDelete(suite.ctx, user.ID, 0, time.Now()))
+	err = suite.Users.Create(suite.ctx, &models.User{Name: "Other", Email: "john.doe@example.com", Password: "hash"})
+	assert.ErrorIs(suite.T(), err, repositories.ErrEmailTaken)
+}
+
+func (suite *ConformanceSuite) TestCreateUserWithAddressesIsAtomic() {
+	// A create that fails midway, here by being cancelled, leaves neither the user nor its addresses behind
+	ctx, cancel := context.WithCancel(suite.ctx)
+	cancel()
+	user := &models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "hash", Addresses: []models.Address{{Street: "First St"}, {Street: "Second St"}}}
+	assert.Error(suite.T(), suite.Users.Create(ctx, user))
+	_, err := suite.Users.FindByEmail(suite.ctx, "john.doe@example.com", true)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	user = &models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "hash", Addresses: []models.Address{{Street: "Third St"}}}
+	suite.Require().NoError(suite.Users.Create(suite.ctx, user))
+	found, err := suite.Users.FindWithAddresses(suite.ctx, user.ID)
+	suite.Require().NoError(err)
+	if assert.Len(suite.T(), found.Addresses, 1) {
+		assert.Equal(suite.T(), "Third St", found.Addresses[0].Street)
+	}
+	addresses, err := suite.Addresses.ListByUser(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), addresses, 1)
+}
+
+func (suite *ConformanceSuite) TestFindUser() {
+	user := suite.createUser("jane.doe@example.com")
+
+	found, err := suite.Users.FindByID(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "jane.doe@example.com", found.Email)
+
+	found, err = suite.Users.FindByEmail(suite.ctx, "jane.doe@example.com", false)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), user.ID, found.ID)
+
+	_, err = suite.Users.FindByID(suite.ctx, user.ID+100)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+	_, err = suite.Users.FindByEmail(suite.ctx, "nobody@example.com", true)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	assert.NoError(suite.T(), suite.Users.SoftDelete(suite.ctx, user.ID, 0, time.Now()))
+	_, err = suite.Users.FindByID(suite.ctx, user.ID)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+	_, err = suite.Users.FindByEmail(suite.ctx, "jane.doe@example.com", false)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	found, err = suite.Users.FindByEmail(suite.ctx, "jane.doe@example.com", true)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), found.DeletedAt.Valid)
+}
+
+func (suite *ConformanceSuite) TestFindWithAddresses() {
+	user := suite.createUser("jack.doe@example.com")
+	first := suite.createAddress(user.ID, "First St")
+	second := suite.createAddress(user.ID, "Second St")
+	trashed := suite.createAddress(user.ID, "Trashed St")
+	other := suite.createUser("jill.doe@example.com")
+	suite.createAddress(other.ID, "Other St")
+	assert.NoError(suite.T(), suite.Addresses.Delete(suite.ctx, trashed.AddressID, user.ID, 0))
+
+	found, err := suite.Users.FindWithAddresses(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	if assert.Len(suite.T(), found.Addresses, 2) {
+		assert.Equal(suite.T(), first.AddressID, found.Addresses[0].AddressID)
+		assert.Equal(suite.T(), second.AddressID, found.Addresses[1].AddressID)
+	}
+}
+
+func (suite *ConformanceSuite) TestListUsers() {
+	var ids []uint
+	for i := 0; i < 4; i++ {
+		ids = append(ids, suite.createUser(fmt.Sprintf("user%d@example.com", i)).ID)
+	}
+	assert.NoError(suite.T(), suite.Users.SoftDelete(suite.ctx, ids[1], 0, time.Now()))
+
+	users, err := suite.Users.List(suite.ctx, repositories.ListUsersOptions{})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []uint{ids[0], ids[2], ids[3]}, userIDs(users))
+
+	users, err = suite.Users.List(suite.ctx, repositories.ListUsersOptions{IncludeDeleted: true, Offset: 1, Limit: 2})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []uint{ids[1], ids[2]}, userIDs(users))
+
+	users, err = suite.Users.List(suite.ctx, repositories.ListUsersOptions{Offset: 10})
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), users)
+}
+
+func userIDs(users []models.User) []uint {
+	ids := []uint{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func (suite *ConformanceSuite) TestUpdateUser() {
+	user := suite.createUser("jim.doe@example.com")
+	suite.createUser("taken@example.com")
+
+	user.Name = "Jim Smith"
+	user.Email = "jim.smith@example.com"
+	assert.NoError(suite.T(), suite.Users.Update(suite.ctx, user, 1, repositories.UserName, repositories.UserEmail))
+
+	found, err := suite.Users.FindByID(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Jim Smith", found.Name)
+	assert.Equal(suite.T(), "jim.smith@example.com", found.Email)
+	assert.Equal(suite.T(), "hash", found.Password)
+	assert.Equal(suite.T(), uint(2), found.Version)
+
+	// Stale version
+	user.Name = "Jim Stale"
+	err = suite.Users.Update(suite.ctx, user, 1, repositories.UserName)
+	assert.ErrorIs(suite.T(), err, repositories.ErrVersionConflict)
+
+	user.Email = "taken@example.com"
+	err = suite.Users.Update(suite.ctx, user, 0, repositories.UserEmail)
+	assert.ErrorIs(suite.T(), err, repositories.ErrEmailTaken)
+
+	now := time.Now()
+	user.DisabledAt = &now
+	assert.NoError(suite.T(), suite.Users.Update(suite.ctx, user, 0, repositories.UserDisabledAt))
+	found, err = suite.Users.FindByID(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.NotNil(suite.T(), found.DisabledAt)
+	assert.Equal(suite.T(), "Jim Smith", found.Name)
+	assert.Equal(suite.T(), uint(3), found.Version)
+
+	assert.Equal(suite.T(), models.RoleUser, found.Role)
+	user.Role = models.RoleAdmin
+	assert.NoError(suite.T(), suite.Users.Update(suite.ctx, user, 0, repositories.UserRole))
+	found, _ = suite.Users.FindByID(suite.ctx, user.ID)
+	assert.Equal(suite.T(), models.RoleAdmin, found.Role)
+
+	err = suite.Users.Update(suite.ctx, &models.User{Name: "Nobody"}, 0, repositories.UserName)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+}
+
+func (suite *ConformanceSuite) TestPasswordHistory() {
+	user := suite.createUser("jen.doe@example.com")
+	other := suite.createUser("joe.doe@example.com")
+
+	for _, hash := range []string{"second", "third"} {
+		user.Password = hash
+		assert.NoError(suite.T(), suite.Users.Update(suite.ctx, user, 0, repositories.UserPassword))
+	}
+	// A failed update leaves the history alone
+	user.Password = "fourth"
+	assert.ErrorIs(suite.T(), suite.Users.Update(suite.ctx, user, 1, repositories.UserPassword), repositories.ErrVersionConflict)
+	user.Name = "Jen Smith"
+	assert.NoError(suite.T(), suite.Users.Update(suite.ctx, user, 0, repositories.UserName))
+
+	hashes, err := suite.Users.PreviousPasswords(suite.ctx, user.ID, 5)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"second", "hash"}, hashes)
+
+	hashes, err = suite.Users.PreviousPasswords(suite.ctx, user.ID, 1)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"second"}, hashes)
+
+	hashes, err = suite.Users.PreviousPasswords(suite.ctx, other.ID, 5)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), hashes)
+}
+
+func (suite *ConformanceSuite) TestReplacePasswordHash() {
+	user := suite.createUser("jay.doe@example.com")
+
+	assert.NoError(suite.T(), suite.Users.ReplacePasswordHash(suite.ctx, user.ID, "hash", "stronger"))
+	found, err := suite.Users.FindByID(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "stronger", found.Password)
+	assert.Equal(suite.T(), uint(1), found.Version)
+
+	// The password changed in between, the new one must not be overwritten
+	assert.NoError(suite.T(), suite.Users.ReplacePasswordHash(suite.ctx, user.ID, "hash", "outdated"))
+	found, err = suite.Users.FindByID(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "stronger", found.Password)
+}
+
+func (suite *ConformanceSuite) TestSoftDeleteAndRestoreUser() {
+	user := suite.createUser("joan.doe@example.com")
+	kept := suite.createAddress(user.ID, "Kept St")
+	trashed := suite.createAddress(user.ID, "Trashed St")
+	assert.NoError(suite.T(), suite.Addresses.Delete(suite.ctx, trashed.AddressID, user.ID, 0))
+
+	assert.ErrorIs(suite.T(), suite.Users.SoftDelete(suite.ctx, user.ID, 5, time.Now()), repositories.ErrVersionConflict)
+	assert.NoError(suite.T(), suite.Users.SoftDelete(suite.ctx, user.ID, 1, time.Now()))
+	assert.ErrorIs(suite.T(), suite.Users.SoftDelete(suite.ctx, user.ID, 0, time.Now()), repositories.ErrNotFound)
+
+	_, err := suite.Addresses.FindByID(suite.ctx, kept.AddressID, user.ID)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	assert.NoError(suite.T(), suite.Users.Restore(suite.ctx, user.ID))
+	assert.ErrorIs(suite.T(), suite.Users.Restore(suite.ctx, user.ID), repositories.ErrNotFound)
+
+	_, err = suite.Users.FindByID(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	addresses, err := suite.Addresses.ListByUser(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	if assert.Len(suite.T(), addresses, 1) {
+		assert.Equal(suite.T(), kept.AddressID, addresses[0].AddressID)
+	}
+}
+
+func (suite *ConformanceSuite) TestTokenRevocation() {
+	user := suite.createUser("token@example.com")
+
+	revoked, err := suite.Users.IsTokenRevoked(suite.ctx, "abc")
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), revoked)
+
+	token := &models.RevokedToken{TokenID: "abc", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour), RevokedAt: time.Now()}
+	assert.NoError(suite.T(), suite.Users.RevokeToken(suite.ctx, token))
+	assert.NoError(suite.T(), suite.Users.RevokeToken(suite.ctx, token))
+
+	revoked, err = suite.Users.IsTokenRevoked(suite.ctx, "abc")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), revoked)
+}
+
+func (suite *ConformanceSuite) TestAddressOwnership() {
+	owner := suite.createUser("owner@example.com")
+	stranger := suite.createUser("stranger@example.com")
+	address := suite.createAddress(owner.ID, "Owned St")
+	assert.Equal(suite.T(), uint(1), address.Version)
+
+	_, err := suite.Addresses.FindByID(suite.ctx, address.AddressID, stranger.ID)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	update := *address
+	update.UserID = stranger.ID
+	assert.ErrorIs(suite.T(), suite.Addresses.Update(suite.ctx, &update, 0), repositories.ErrNotFound)
+	assert.ErrorIs(suite.T(), suite.Addresses.Delete(suite.ctx, address.AddressID, stranger.ID, 0), repositories.ErrNotFound)
+
+	addresses, err := suite.Addresses.ListByUser(suite.ctx, stranger.ID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), addresses)
+
+	err = suite.Addresses.Create(suite.ctx, &models.Address{UserID: stranger.ID + 100, Street: "Nowhere"})
+	assert.Error(suite.T(), err)
+}
+
+func (suite *ConformanceSuite) TestUpdateAndDeleteAddress() {
+	user := suite.createUser("versions@example.com")
+	address := suite.createAddress(user.ID, "Old St")
+
+	update := models.Address{AddressID: address.AddressID, UserID: user.ID, Street: "New St"}
+	assert.NoError(suite.T(), suite.Addresses.Update(suite.ctx, &update, 1))
+	assert.ErrorIs(suite.T(), suite.Addresses.Update(suite.ctx, &update, 1), repositories.ErrVersionConflict)
+
+	found, err := suite.Addresses.FindByID(suite.ctx, address.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "New St", found.Street)
+	assert.Empty(suite.T(), found.City)
+	assert.Equal(suite.T(), uint(2), found.Version)
+
+	assert.ErrorIs(suite.T(), suite.Addresses.Delete(suite.ctx, address.AddressID, user.ID, 1), repositories.ErrVersionConflict)
+	assert.NoError(suite.T(), suite.Addresses.Delete(suite.ctx, address.AddressID, user.ID, 2))
+	assert.ErrorIs(suite.T(), suite.Addresses.Delete(suite.ctx, address.AddressID, user.ID, 0), repositories.ErrNotFound)
+}
+
+func (suite *ConformanceSuite) TestAddressTrash() {
+	user := suite.createUser("trash@example.com")
+	first := suite.createAddress(user.ID, "First St")
+	second := suite.createAddress(user.ID, "Second St")
+
+	assert.ErrorIs(suite.T(), suite.Addresses.Restore(suite.ctx, first.AddressID, user.ID), repositories.ErrNotFound)
+	assert.NoError(suite.T(), suite.Addresses.Delete(suite.ctx, first.AddressID, user.ID, 0))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(suite.T(), suite.Addresses.Delete(suite.ctx, second.AddressID, user.ID, 0))
+
+	deleted, err := suite.Addresses.ListDeleted(suite.ctx, user.ID)
+	assert.NoError(suite.T(), err)
+	if assert.Len(suite.T(), deleted, 2) {
+		assert.Equal(suite.T(), second.AddressID, deleted[0].AddressID)
+		assert.Equal(suite.T(), first.AddressID, deleted[1].AddressID)
+	}
+
+	other := suite.createUser("other@example.com")
+	assert.ErrorIs(suite.T(), suite.Addresses.Restore(suite.ctx, first.AddressID, other.ID), repositories.ErrNotFound)
+	assert.NoError(suite.T(), suite.Addresses.Restore(suite.ctx, first.AddressID, user.ID))
+
+	restored, err := suite.Addresses.FindByID(suite.ctx, first.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint(2), restored.Version)
+}
+
+func (suite *ConformanceSuite) TestCreateBatch() {
+	user := suite.createUser("batch@example.com")
+	batch := func() []*models.Address {
+		return []*models.Address{
+			{UserID: user.ID, Street: "First St"},
+			{UserID: user.ID + 100, Street: "Orphan St"},
+			{UserID: user.ID, Street: "Third St"},
+		}
+	}
+
+	rowErrors, err := suite.Addresses.CreateBatch(suite.ctx, batch(), true)
+	assert.Error(suite.T(), err)
+	assert.Len(suite.T(), rowErrors, 3)
+	assert.Error(suite.T(), rowErrors[1])
+	addresses, _ := suite.Addresses.ListByUser(suite.ctx, user.ID)
+	assert.Empty(suite.T(), addresses)
+
+	rows := batch()
+	rowErrors, err = suite.Addresses.CreateBatch(suite.ctx, rows, false)
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), rowErrors[0])
+	assert.Error(suite.T(), rowErrors[1])
+	assert.NoError(suite.T(), rowErrors[2])
+	assert.NotZero(suite.T(), rows[0].AddressID)
+	addresses, _ = suite.Addresses.ListByUser(suite.ctx, user.ID)
+	assert.Len(suite.T(), addresses, 2)
+}
+
+func (suite *ConformanceSuite) TestStream() {
+	user := suite.createUser("stream@example.com")
+	var want []uint
+	for i := 0; i < 3; i++ {
+		want = append(want, suite.createAddress(user.ID, fmt.Sprintf("%d St", i)).AddressID)
+	}
+
+	var got []uint
+	err := suite.Addresses.Stream(suite.ctx, user.ID, func(address *models.Address) error {
+		got = append(got, address.AddressID)
+		return nil
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), want, got)
+
+	stop := fmt.Errorf("stop")
+	err = suite.Addresses.Stream(suite.ctx, user.ID, func(*models.Address) error { return stop })
+	assert.ErrorIs(suite.T(), err, stop)
+}
+
+func (suite *ConformanceSuite) TestConcurrentRegistrations() {
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- suite.Users.Create(suite.ctx, &models.User{Name: "Racer", Email: "race@example.com", Password: "hash"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else {
+			assert.ErrorIs(suite.T(), err, repositories.ErrEmailTaken)
+		}
+	}
+	assert.Equal(suite.T(), 1, created)
+}
+
+func (suite *ConformanceSuite) TestSessions() {
+	user := suite.createUser("session@example.com")
+	other := suite.createUser("other.session@example.com")
+	now := time.Now().Truncate(time.Second)
+	create := func(userID uint, tokenID, userAgent string, lastSeen time.Time) *models.Session {
+		session := &models.Session{UserID: userID, TokenID: tokenID, UserAgent: userAgent, IP: "192.0.2.1",
+			CreatedAt: lastSeen, LastSeenAt: lastSeen, ExpiresAt: lastSeen.Add(24 * time.Hour)}
+		suite.Require().NoError(suite.Sessions.Create(suite.ctx, session))
+		return session
+	}
+
+	anySession, _, err := suite.Sessions.Seen(suite.ctx, user.ID, "Firefox")
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), anySession)
+
+	older := create(user.ID, "older", "Firefox", now.Add(-2*time.Hour))
+	newer := create(user.ID, "newer", "Chrome", now.Add(-time.Hour))
+	expired := create(user.ID, "expired", "Safari", now.Add(-48*time.Hour))
+	create(other.ID, "other", "Edge", now)
+
+	sessions, err := suite.Sessions.ListActive(suite.ctx, user.ID, now)
+	assert.NoError(suite.T(), err)
+	if assert.Len(suite.T(), sessions, 2) {
+		assert.Equal(suite.T(), newer.ID, sessions[0].ID)
+		assert.Equal(suite.T(), older.ID, sessions[1].ID)
+	}
+
+	// Writes are skipped while the last seen time is recent enough
+	assert.NoError(suite.T(), suite.Sessions.Touch(suite.ctx, "newer", now, 2*time.Hour))
+	assert.NoError(suite.T(), suite.Sessions.Touch(suite.ctx, "older", now, time.Minute))
+	sessions, _ = suite.Sessions.ListActive(suite.ctx, user.ID, now)
+	if assert.Len(suite.T(), sessions, 2) {
+		assert.Equal(suite.T(), older.ID, sessions[0].ID)
+		assert.True(suite.T(), now.Equal(sessions[0].LastSeenAt))
+	}
+
+	_, err = suite.Sessions.Revoke(suite.ctx, older.ID, other.ID, now)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+	_, err = suite.Sessions.Revoke(suite.ctx, expired.ID, user.ID, now)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+	revoked, err := suite.Sessions.Revoke(suite.ctx, older.ID, user.ID, now)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "older", revoked.TokenID)
+	_, err = suite.Sessions.Revoke(suite.ctx, older.ID, user.ID, now)
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	revoked, err = suite.Sessions.RevokeByToken(suite.ctx, "newer", now)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), newer.ID, revoked.ID)
+	sessions, _ = suite.Sessions.ListActive(suite.ctx, user.ID, now)
+	assert.Empty(suite.T(), sessions)
+
+	// Revoked and expired sessions still count as known devices
+	anySession, sameUserAgent, err := suite.Sessions.Seen(suite.ctx, user.ID, "Safari")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), anySession)
+	assert.True(suite.T(), sameUserAgent)
+	_, sameUserAgent, _ = suite.Sessions.Seen(suite.ctx, user.ID, "Edge")
+	assert.False(suite.T(), sameUserAgent)
+}
+
+func (suite *ConformanceSuite) TestAuditLog() {
+	jane, john := uint(1), uint(2)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	events := []models.AuditEvent{
+		{OccurredAt: start, UserID: &jane, ActorID: &jane, Action: "user.login", TargetType: "user", TargetID: "1", IP: "10.0.0.1", RequestID: "req-1"},
+		{OccurredAt: start.Add(time.Minute), UserID: &jane, ActorID: &jane, Action: "address.updated", TargetType: "address", TargetID: "7",
+			Changes: models.AuditChanges{"street": {Before: "Old Street", After: "New Street"}}},
+		{OccurredAt: start.Add(2 * time.Minute), UserID: &john, Action: "user.disabled", TargetType: "user", TargetID: "2"},
+		{OccurredAt: start.Add(3 * time.Minute), Action: "user.login_failed", TargetType: "user"},
+	}
+	for i := range events {
+		suite.Require().NoError(suite.Audit.Append(suite.ctx, &events[i]))
+		assert.NotZero(suite.T(), events[i].ID)
+	}
+
+	all, err := suite.Audit.List(suite.ctx, repositories.AuditFilter{}, 10)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []uint{events[3].ID, events[2].ID, events[1].ID, events[0].ID}, auditEventIDs(all))
+	assert.Nil(suite.T(), all[0].UserID)
+	assert.Equal(suite.T(), jane, *all[2].ActorID)
+	assert.Equal(suite.T(), models.AuditChanges{"street": {Before: "Old Street", After: "New Street"}}, all[2].Changes)
+	assert.Equal(suite.T(), "10.0.0.1", all[3].IP)
+	assert.Equal(suite.T(), "req-1", all[3].RequestID)
+	assert.True(suite.T(), start.Equal(all[3].OccurredAt))
+
+	filters := []struct {
+		filter repositories.AuditFilter
+		want   []uint
+	}{
+		{repositories.AuditFilter{UserID: jane}, []uint{events[1].ID, events[0].ID}},
+		{repositories.AuditFilter{ActorID: jane, Action: "user.login"}, []uint{events[0].ID}},
+		{repositories.AuditFilter{TargetType: "address", TargetID: "7"}, []uint{events[1].ID}},
+		{repositories.AuditFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, []uint{events[2].ID, events[1].ID}},
+		{repositories.AuditFilter{UserID: jane, BeforeID: events[1].ID}, []uint{events[0].ID}},
+		{repositories.AuditFilter{UserID: 3}, []uint{}},
+	}
+	for _, tt := range filters {
+		found, err := suite.Audit.List(suite.ctx, tt.filter, 10)
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), tt.want, auditEventIDs(found), "%+v", tt.filter)
+	}
+
+	page, _ := suite.Audit.List(suite.ctx, repositories.AuditFilter{}, 2)
+	assert.Equal(suite.T(), []uint{events[3].ID, events[2].ID}, auditEventIDs(page))
+
+	var streamed []uint
+	err = suite.Audit.Stream(suite.ctx, repositories.AuditFilter{ActorID: jane}, func(event *models.AuditEvent) error {
+		streamed = append(streamed, event.ID)
+		return nil
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []uint{events[1].ID, events[0].ID}, streamed)
+}
+
+func (suite *ConformanceSuite) TestIdentities() {
+	user := suite.createUser("john.doe@example.com")
+	other := suite.createUser("jane.doe@example.com")
+
+	_, err := suite.Identities.FindBySubject(suite.ctx, "google", "1234")
+	assert.ErrorIs(suite.T(), err, repositories.ErrNotFound)
+
+	identity := &models.Identity{UserID: user.ID, Provider: "google", Subject: "1234", Email: user.Email, CreatedAt: time.Now()}
+	suite.Require().NoError(suite.Identities.Create(suite.ctx, identity))
+	assert.NotZero(suite.T(), identity.ID)
+	// The same subject at another provider is another account
+	suite.Require().NoError(suite.Identities.Create(suite.ctx, &models.Identity{UserID: other.ID, Provider: "corp", Subject: "1234", CreatedAt: time.Now()}))
+
+	found, err := suite.Identities.FindBySubject(suite.ctx, "google", "1234")
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), identity.ID, found.ID)
+	assert.Equal(suite.T(), user.ID, found.UserID)
+	assert.Equal(suite.T(), "john.doe@example.com", found.Email)
+
+	assert.Error(suite.T(), suite.Identities.Create(suite.ctx, &models.Identity{UserID: other.ID, Provider: "google", Subject: "1234", CreatedAt: time.Now()}))
+	assert.Error(suite.T(), suite.Identities.Create(suite.ctx, &models.Identity{UserID: 999, Provider: "google", Subject: "5678", CreatedAt: time.Now()}))
+}
+
+func auditEventIDs(events []models.AuditEvent) []uint {
+	ids := []uint{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestMemoryRepositories(t *testing.T) {
+	suite.Run(t, &ConformanceSuite{
+		Reset: func(*testing.T) (repositories.UserRepository, repositories.AddressRepository, repositories.SessionRepository, repositories.IdentityRepository, repositories.AuditRepository) {
+			store := repositories.NewMemoryStore()
+			return store.Users(), store.Addresses(), store.Sessions(), store.Identities(), store.Audit()
+		},
+	})
+}
+
+func TestGormRepositories(t *testing.T) {
+	suite.Run(t, &ConformanceSuite{
+		Reset: func(t *testing.T) (repositories.UserRepository, repositories.AddressRepository, repositories.SessionRepository, repositories.IdentityRepository, repositories.AuditRepository) {
+			db := testutils.NewTestDB(t)
+			return &repositories.GormUserRepository{DB: db}, &repositories.GormAddressRepository{DB: db}, &repositories.GormSessionRepository{DB: db},
+				&repositories.GormIdentityRepository{DB: db}, &repositories.GormAuditRepository{DB: db}
+		},
+	})
+}
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	db := testutils.NewTestDB(t)
+	audit := &repositories.GormAuditRepository{DB: db}
+	event := &models.AuditEvent{OccurredAt: time.Now(), Action: "user.login", TargetType: "user"}
+	assert.NoError(t, audit.Append(context.Background(), event))
+
+	assert.ErrorContains(t, db.Model(event).Update("action", "user.logout").Error, "append-only")
+	assert.ErrorContains(t, db.Delete(event).Error, "append-only")
+
+	found, err := audit.List(context.Background(), repositories.AuditFilter{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "user.login", found[0].Action)
+}
+
+func TestOAuthRepository(t *testing.T) {
+	ctx := context.Background()
+	db := testutils.NewTestDB(t)
+	oauth := &repositories.GormOAuthRepository{DB: db}
+	user := &models.User{Name: "John Doe", Email: "john@example.com", Password: "hash"}
+	assert.NoError(t, (&repositories.GormUserRepository{DB: db}).Create(ctx, user))
+	client := &models.OAuthClient{ClientID: "app", Name: "App", RedirectURIs: models.StringList{"https://app.example.com/cb"},
+		Scopes: models.StringList{"openid", "email"}, GrantTypes: models.StringList{"authorization_code"}, CreatedAt: time.Now()}
+	assert.NoError(t, oauth.CreateClient(ctx, client))
+
+	found, err := oauth.FindClient(ctx, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, models.StringList{"openid", "email"}, found.Scopes)
+	assert.True(t, found.Public())
+
+	// Saving a consent again replaces its scopes
+	assert.NoError(t, oauth.SaveConsent(ctx, &models.OAuthConsent{UserID: user.ID, ClientID: "app", Scopes: models.StringList{"openid"}, UpdatedAt: time.Now()}))
+	assert.NoError(t, oauth.SaveConsent(ctx, &models.OAuthConsent{UserID: user.ID, ClientID: "app", Scopes: models.StringList{"openid", "email"}, UpdatedAt: time.Now()}))
+	consent, err := oauth.FindConsent(ctx, user.ID, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, models.StringList{"openid", "email"}, consent.Scopes)
+
+	// Codes are redeemed once and not after they expire
+	now := time.Now()
+	assert.NoError(t, oauth.CreateCode(ctx, &models.OAuthCode{CodeHash: "a", ClientID: "app", UserID: user.ID, AuthTime: now, ExpiresAt: now.Add(time.Minute)}))
+	assert.NoError(t, oauth.CreateCode(ctx, &models.OAuthCode{CodeHash: "b", ClientID: "app", UserID: user.ID, AuthTime: now, ExpiresAt: now.Add(-time.Second)}))
+	code, err := oauth.RedeemCode(ctx, "a", now)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, code.UserID)
+	_, err = oauth.RedeemCode(ctx, "a", now)
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+	_, err = oauth.RedeemCode(ctx, "b", now)
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+
+	// A refresh token is rotated once
+	token := &models.OAuthRefreshToken{TokenHash: "old", ClientID: "app", UserID: user.ID, AuthTime: now, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, oauth.CreateRefreshToken(ctx, token))
+	replacement := &models.OAuthRefreshToken{TokenHash: "new", ClientID: "app", UserID: user.ID, AuthTime: now, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, oauth.RotateRefreshToken(ctx, token.ID, replacement, now))
+	again := &models.OAuthRefreshToken{TokenHash: "again", ClientID: "app", UserID: user.ID, AuthTime: now, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	assert.ErrorIs(t, oauth.RotateRefreshToken(ctx, token.ID, again, now), repositories.ErrNotFound)
+	_, err = oauth.FindRefreshToken(ctx, "again")
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+
+	assert.NoError(t, oauth.RevokeRefreshTokens(ctx, user.ID, "app", now))
+	revoked, err := oauth.FindRefreshToken(ctx, "new")
+	assert.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+
+	// Deleting the client takes what it was granted along
+	assert.NoError(t, oauth.DeleteClient(ctx, "app"))
+	assert.ErrorIs(t, oauth.DeleteClient(ctx, "app"), repositories.ErrNotFound)
+	_, err = oauth.FindConsent(ctx, user.ID, "app")
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+	_, err = oauth.FindRefreshToken(ctx, "new")
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+}
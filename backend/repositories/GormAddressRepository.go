@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// GormAddressRepository is the AddressRepository backed by the application database
+type GormAddressRepository struct {
+	DB *gorm.DB
+}
+
+func (r *GormAddressRepository) Create(ctx context.Context, address *models.Address) error {
+	return r.DB.WithContext(ctx).Create(address).Error
+}
+
+func (r *GormAddressRepository) CreateBatch(ctx context.Context, addresses []*models.Address, atomic bool) ([]error, error) {
+	rowErrors := make([]error, len(addresses))
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, address := range addresses {
+			if atomic {
+				if err := tx.Create(address).Error; err != nil {
+					rowErrors[i] = err
+					return err
+				}
+				continue
+			}
+			// A savepoint per row keeps a failed insert from aborting the whole transaction
+			rowErrors[i] = tx.Transaction(func(rowTx *gorm.DB) error {
+				return rowTx.Create(address).Error
+			})
+		}
+		return nil
+	})
+	return rowErrors, err
+}
+
+func (r *GormAddressRepository) FindByID(ctx context.Context, addressID, userID uint) (*models.Address, error) {
+	var address models.Address
+	if err := r.DB.WithContext(ctx).Where("address_id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+func (r *GormAddressRepository) ListByUser(ctx context.Context, userID uint) ([]models.Address, error) {
+	var addresses []models.Address
+	if err := r.DB.WithContext(ctx).Where("user_id = ?", userID).Order("address_id").Find(&addresses).Error; err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func (r *GormAddressRepository) ListDeleted(ctx context.Context, userID uint) ([]models.Address, error) {
+	var addresses []models.Address
+	err := r.DB.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").Find(&addresses).Error
+	if err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func (r *GormAddressRepository) Stream(ctx context.Context, userID uint, fn func(*models.Address) error) error {
+	db := r.DB.WithContext(ctx)
+	rows, err := db.Model(&models.Address{}).Where("user_id = ?", userID).Order("address_id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var address models.Address
+		if err := db.ScanRows(rows, &address); err != nil {
+			return err
+		}
+		if err := fn(&address); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *GormAddressRepository) Update(ctx context.Context, address *models.Address, version uint) error {
+	updates := map[string]interface{}{
+		"street":     address.Street,
+		"number":     address.Number,
+		"complement": address.Complement,
+		"city":       address.City,
+		"state":      address.State,
+		"zipcode":    address.Zipcode,
+		"country":    address.Country,
+		"version":    gorm.Expr("version + 1"),
+	}
+
+	db := r.DB.WithContext(ctx)
+	query := db.Model(&models.Address{}).Where("address_id = ? AND user_id = ?", address.AddressID, address.UserID)
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return conflictOrMissing(db.Where("address_id = ? AND user_id = ?", address.AddressID, address.UserID), &models.Address{}, version)
+	}
+	return nil
+}
+
+func (r *GormAddressRepository) Delete(ctx context.Context, addressID, userID, version uint) error {
+	db := r.DB.WithContext(ctx)
+	query := db.Where("address_id = ? AND user_id = ?", addressID, userID)
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Delete(&models.Address{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return conflictOrMissing(db.Where("address_id = ? AND user_id = ?", addressID, userID), &models.Address{}, version)
+	}
+	return nil
+}
+
+func (r *GormAddressRepository) Restore(ctx context.Context, addressID, userID uint) error {
+	result := r.DB.WithContext(ctx).Unscoped().Model(&models.Address{}).
+		Where("address_id = ? AND user_id = ? AND deleted_at IS NOT NULL", addressID, userID).
+		Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+type GormAuditRepository struct {
+	DB *gorm.DB
+}
+
+func (r *GormAuditRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	return r.DB.WithContext(ctx).Create(event).Error
+}
+
+func (r *GormAuditRepository) List(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	err := r.query(r.DB.WithContext(ctx), filter).Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (r *GormAuditRepository) Stream(ctx context.Context, filter AuditFilter, fn func(*models.AuditEvent) error) error {
+	db := r.DB.WithContext(ctx)
+	rows, err := r.query(db, filter).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := db.ScanRows(rows, &event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// query selects the events matching the filter, newest first
+func (r *GormAuditRepository) query(db *gorm.DB, filter AuditFilter) *gorm.DB {
+	query := db.Model(&models.AuditEvent{}).Order("id DESC")
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("occurred_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("occurred_at < ?", filter.To)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	return query
+}
@@ -0,0 +1,25 @@
+package repositories
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+type GormIdentityRepository struct {
+	DB *gorm.DB
+}
+
+func (r *GormIdentityRepository) Create(ctx context.Context, identity *models.Identity) error {
+	return r.DB.WithContext(ctx).Create(identity).Error
+}
+
+func (r *GormIdentityRepository) FindBySubject(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	if err := r.DB.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
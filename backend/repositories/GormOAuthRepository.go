@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+type GormOAuthRepository struct {
+	DB *gorm.DB
+}
+
+func (r *GormOAuthRepository) CreateClient(ctx context.Context, client *models.OAuthClient) error {
+	return r.DB.WithContext(ctx).Create(client).Error
+}
+
+func (r *GormOAuthRepository) FindClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := r.DB.WithContext(ctx).Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *GormOAuthRepository) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := r.DB.WithContext(ctx).Order("id").Find(&clients).Error
+	return clients, err
+}
+
+func (r *GormOAuthRepository) DeleteClient(ctx context.Context, clientID string) error {
+	// The foreign keys cascade to the consents, codes and tokens of the client
+	result := r.DB.WithContext(ctx).Where("client_id = ?", clientID).Delete(&models.OAuthClient{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormOAuthRepository) FindConsent(ctx context.Context, userID uint, clientID string) (*models.OAuthConsent, error) {
+	var consent models.OAuthConsent
+	if err := r.DB.WithContext(ctx).Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error; err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (r *GormOAuthRepository) SaveConsent(ctx context.Context, consent *models.OAuthConsent) error {
+	return r.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+	}).Create(consent).Error
+}
+
+func (r *GormOAuthRepository) CreateCode(ctx context.Context, code *models.OAuthCode) error {
+	return r.DB.WithContext(ctx).Create(code).Error
+}
+
+func (r *GormOAuthRepository) RedeemCode(ctx context.Context, codeHash string, now time.Time) (*models.OAuthCode, error) {
+	var code models.OAuthCode
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("code_hash = ? AND expires_at > ?", codeHash, now).First(&code).Error; err != nil {
+			return err
+		}
+		result := tx.Where("code_hash = ?", codeHash).Delete(&models.OAuthCode{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *GormOAuthRepository) CreateRefreshToken(ctx context.Context, token *models.OAuthRefreshToken) error {
+	return r.DB.WithContext(ctx).Create(token).Error
+}
+
+func (r *GormOAuthRepository) FindRefreshToken(ctx context.Context, tokenHash string) (*models.OAuthRefreshToken, error) {
+	var token models.OAuthRefreshToken
+	if err := r.DB.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *GormOAuthRepository) RotateRefreshToken(ctx context.Context, tokenID uint, replacement *models.OAuthRefreshToken, at time.Time) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.OAuthRefreshToken{}).Where("id = ? AND revoked_at IS NULL", tokenID).Update("revoked_at", at)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Create(replacement).Error
+	})
+}
+
+func (r *GormOAuthRepository) RevokeRefreshTokens(ctx context.Context, userID uint, clientID string, at time.Time) error {
+	return r.DB.WithContext(ctx).Model(&models.OAuthRefreshToken{}).
+		Where("user_id = ? AND client_id = ? AND revoked_at IS NULL", userID, clientID).
+		Update("revoked_at", at).Error
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+type GormSessionRepository struct {
+	DB *gorm.DB
+}
+
+func (r *GormSessionRepository) Create(ctx context.Context, session *models.Session) error {
+	return r.DB.WithContext(ctx).Create(session).Error
+}
+
+func (r *GormSessionRepository) ListActive(ctx context.Context, userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.DB.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC, id DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *GormSessionRepository) Revoke(ctx context.Context, sessionID, userID uint, at time.Time) (*models.Session, error) {
+	return r.revoke(ctx, at, "id = ? AND user_id = ?", sessionID, userID)
+}
+
+func (r *GormSessionRepository) RevokeByToken(ctx context.Context, tokenID string, at time.Time) (*models.Session, error) {
+	return r.revoke(ctx, at, "token_id = ?", tokenID)
+}
+
+func (r *GormSessionRepository) revoke(ctx context.Context, at time.Time, query string, args ...interface{}) (*models.Session, error) {
+	var session models.Session
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(query, args...).Where("revoked_at IS NULL AND expires_at > ?", at).First(&session).Error; err != nil {
+			return err
+		}
+		session.RevokedAt = &at
+		return tx.Model(&session).Update("revoked_at", at).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *GormSessionRepository) Touch(ctx context.Context, tokenID string, at time.Time, interval time.Duration) error {
+	return r.DB.WithContext(ctx).Model(&models.Session{}).
+		Where("token_id = ? AND last_seen_at < ?", tokenID, at.Add(-interval)).
+		Update("last_seen_at", at).Error
+}
+
+func (r *GormSessionRepository) Seen(ctx context.Context, userID uint, userAgent string) (bool, bool, error) {
+	var seen struct {
+		Sessions      int64
+		SameUserAgent int64
+	}
+	err := r.DB.WithContext(ctx).Model(&models.Session{}).
+		Select("COUNT(*) AS sessions, COUNT(CASE WHEN user_agent = ? THEN 1 END) AS same_user_agent", userAgent).
+		Where("user_id = ?", userID).
+		Scan(&seen).Error
+	return seen.Sessions > 0, seen.SameUserAgent > 0, err
+}
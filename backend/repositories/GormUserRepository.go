@@ -0,0 +1,202 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// GormUserRepository is the UserRepository backed by the application database
+type GormUserRepository struct {
+	DB *gorm.DB
+}
+
+func (r *GormUserRepository) Create(ctx context.Context, user *models.User) error {
+	return translateError(r.DB.WithContext(ctx).Create(user).Error)
+}
+
+func (r *GormUserRepository) FindByID(ctx context.Context, userID uint) (*models.User, error) {
+	var user models.User
+	if err := r.DB.WithContext(ctx).Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) FindWithAddresses(ctx context.Context, userID uint) (*models.User, error) {
+	var user models.User
+	err := r.DB.WithContext(ctx).
+		Preload("Addresses", func(db *gorm.DB) *gorm.DB { return db.Order("address_id") }).
+		Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) FindByEmail(ctx context.Context, email string, includeDeleted bool) (*models.User, error) {
+	query := r.DB.WithContext(ctx)
+	if includeDeleted {
+		query = query.Unscoped()
+	}
+	var user models.User
+	if err := query.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormUserRepository) List(ctx context.Context, options ListUsersOptions) ([]models.User, error) {
+	query := r.DB.WithContext(ctx).Order("id")
+	if options.IncludeDeleted {
+		query = query.Unscoped()
+	}
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *GormUserRepository) Update(ctx context.Context, user *models.User, version uint, columns ...string) error {
+	updates := map[string]interface{}{"version": gorm.Expr("version + 1")}
+	for _, column := range columns {
+		switch column {
+		case UserName:
+			updates[column] = user.Name
+		case UserEmail:
+			updates[column] = user.Email
+		case UserPassword:
+			updates[column] = user.Password
+		case UserDisabledAt:
+			updates[column] = user.DisabledAt
+		case UserTokensRevokedAt:
+			updates[column] = user.TokensRevokedAt
+		case UserRole:
+			updates[column] = user.Role
+		default:
+			return errors.New("unknown user column " + column)
+		}
+	}
+
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, ok := updates[UserPassword]; ok {
+			// A missing user is reported by the update below, which rolls this insert back
+			if err := tx.Exec(`INSERT INTO password_history (user_id, password_hash, created_at)
+				SELECT id, password, ? FROM users WHERE id = ? AND deleted_at IS NULL`, time.Now(), user.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		query := tx.Model(&models.User{}).Where("id = ?", user.ID)
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			return translateError(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return conflictOrMissing(tx.Where("id = ?", user.ID), &models.User{}, version)
+		}
+		return nil
+	})
+}
+
+func (r *GormUserRepository) PreviousPasswords(ctx context.Context, userID uint, limit int) ([]string, error) {
+	var hashes []string
+	err := r.DB.WithContext(ctx).Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Pluck("password_hash", &hashes).Error
+	return hashes, err
+}
+
+func (r *GormUserRepository) ReplacePasswordHash(ctx context.Context, userID uint, current, replacement string) error {
+	return r.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND password = ?", userID, current).
+		UpdateColumn("password", replacement).Error
+}
+
+func (r *GormUserRepository) SoftDelete(ctx context.Context, userID, version uint, at time.Time) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.User{}).Where("id = ?", userID)
+		if version != 0 {
+			query = query.Where("version = ?", version)
+		}
+		result := query.Update("deleted_at", at)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return conflictOrMissing(tx.Where("id = ?", userID), &models.User{}, version)
+		}
+		return tx.Model(&models.Address{}).Where("user_id = ?", userID).Update("deleted_at", at).Error
+	})
+}
+
+func (r *GormUserRepository) Restore(ctx context.Context, userID uint) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", userID).First(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Address{}).
+			Where("user_id = ? AND deleted_at >= ?", userID, user.DeletedAt.Time).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Update("deleted_at", nil).Error
+	})
+}
+
+func (r *GormUserRepository) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	return r.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error
+}
+
+func (r *GormUserRepository) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked int64
+	if err := r.DB.WithContext(ctx).Model(&models.RevokedToken{}).Where("token_id = ?", tokenID).Count(&revoked).Error; err != nil {
+		return false, err
+	}
+	return revoked > 0, nil
+}
+
+// conflictOrMissing explains why a write matched no row: the row is gone, or its version moved on
+func conflictOrMissing(query *gorm.DB, model interface{}, version uint) error {
+	if err := query.First(model).Error; err != nil {
+		return err
+	}
+	if version == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+// translateError maps unique violations on users.email to ErrEmailTaken
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "uni_users_email" {
+		return ErrEmailTaken
+	}
+	// SQLite only reports the constraint in the message
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+		return ErrEmailTaken
+	}
+	return err
+}
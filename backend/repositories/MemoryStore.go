@@ -0,0 +1,754 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// MemoryStore keeps users, addresses, sessions, identities and the audit log in memory with the same semantics as the database:
+// unique emails, soft deletes, ownership filtering and versioning. It is safe for concurrent use
+// and meant for tests and local experiments, nothing survives a restart.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	users     map[uint]*models.User
+	addresses map[uint]*models.Address
+	revoked   map[string]models.RevokedToken
+	// passwords holds the password history of each user, oldest first
+	passwords      map[uint][]string
+	sessions       map[uint]*models.Session
+	identities     []models.Identity
+	auditEvents    []models.AuditEvent
+	lastUserID     uint
+	lastAddressID  uint
+	lastSessionID  uint
+	lastIdentityID uint
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:     map[uint]*models.User{},
+		addresses: map[uint]*models.Address{},
+		revoked:   map[string]models.RevokedToken{},
+		passwords: map[uint][]string{},
+		sessions:  map[uint]*models.Session{},
+	}
+}
+
+// Users returns the UserRepository view of the store
+func (s *MemoryStore) Users() UserRepository {
+	return &memoryUsers{s}
+}
+
+// Addresses returns the AddressRepository view of the store
+func (s *MemoryStore) Addresses() AddressRepository {
+	return &memoryAddresses{s}
+}
+
+// Sessions returns the SessionRepository view of the store
+func (s *MemoryStore) Sessions() SessionRepository {
+	return &memorySessions{s}
+}
+
+// Identities returns the IdentityRepository view of the store
+func (s *MemoryStore) Identities() IdentityRepository {
+	return &memoryIdentities{s}
+}
+
+// Audit returns the AuditRepository view of the store
+func (s *MemoryStore) Audit() AuditRepository {
+	return &memoryAudit{s}
+}
+
+type memoryUsers struct {
+	store *MemoryStore
+}
+
+type memoryAddresses struct {
+	store *MemoryStore
+}
+
+type memorySessions struct {
+	store *MemoryStore
+}
+
+type memoryIdentities struct {
+	store *MemoryStore
+}
+
+type memoryAudit struct {
+	store *MemoryStore
+}
+
+// cloneUser copies the user without its addresses, which are stored separately
+func cloneUser(user *models.User) *models.User {
+	clone := *user
+	clone.Addresses = nil
+	clone.DisabledAt = cloneTime(user.DisabledAt)
+	clone.TokensRevokedAt = cloneTime(user.TokensRevokedAt)
+	return &clone
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	clone := *t
+	return &clone
+}
+
+func (s *MemoryStore) activeUser(userID uint) (*models.User, bool) {
+	user, ok := s.users[userID]
+	if !ok || user.DeletedAt.Valid {
+		return nil, false
+	}
+	return user, true
+}
+
+func (s *MemoryStore) activeAddress(addressID, userID uint) (*models.Address, bool) {
+	address, ok := s.addresses[addressID]
+	if !ok || address.UserID != userID || address.DeletedAt.Valid {
+		return nil, false
+	}
+	return address, true
+}
+
+func (s *MemoryStore) emailTaken(email string, exceptID uint) bool {
+	for _, user := range s.users {
+		if user.Email == email && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// insertAddress mirrors the foreign key to users: the owner must exist, even if soft-deleted
+func (s *MemoryStore) insertAddress(address *models.Address, now time.Time) error {
+	if _, ok := s.users[address.UserID]; !ok {
+		return fmt.Errorf("address owner %d does not exist", address.UserID)
+	}
+	s.lastAddressID++
+	address.AddressID = s.lastAddressID
+	address.Version = 1
+	address.CreatedAt = now
+	address.UpdatedAt = now
+	address.DeletedAt = gorm.DeletedAt{}
+	stored := *address
+	s.addresses[address.AddressID] = &stored
+	return nil
+}
+
+func (s *MemoryStore) sortedAddresses(match func(*models.Address) bool) []models.Address {
+	addresses := []models.Address{}
+	for _, address := range s.addresses {
+		if match(address) {
+			addresses = append(addresses, *address)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].AddressID < addresses[j].AddressID })
+	return addresses
+}
+
+func (r *memoryUsers) Create(ctx context.Context, user *models.User) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.Email, 0) {
+		return ErrEmailTaken
+	}
+	now := time.Now()
+	s.lastUserID++
+	user.ID = s.lastUserID
+	user.Version = 1
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.DeletedAt = gorm.DeletedAt{}
+	s.users[user.ID] = cloneUser(user)
+
+	// Like gorm, addresses sent along with the user are created with it, in the same transaction
+	for i := range user.Addresses {
+		user.Addresses[i].UserID = user.ID
+		err := ctx.Err()
+		if err == nil {
+			err = s.insertAddress(&user.Addresses[i], now)
+		}
+		if err != nil {
+			for _, created := range user.Addresses[:i] {
+				delete(s.addresses, created.AddressID)
+			}
+			for j := range user.Addresses {
+				user.Addresses[j].AddressID = 0
+			}
+			delete(s.users, user.ID)
+			user.ID = 0
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryUsers) FindByID(ctx context.Context, userID uint) (*models.User, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.activeUser(userID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (r *memoryUsers) FindWithAddresses(ctx context.Context, userID uint) (*models.User, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.activeUser(userID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	clone := cloneUser(user)
+	clone.Addresses = s.sortedAddresses(func(a *models.Address) bool {
+		return a.UserID == userID && !a.DeletedAt.Valid
+	})
+	return clone, nil
+}
+
+func (r *memoryUsers) FindByEmail(ctx context.Context, email string, includeDeleted bool) (*models.User, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email && (includeDeleted || !user.DeletedAt.Valid) {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUsers) List(ctx context.Context, options ListUsersOptions) ([]models.User, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := []models.User{}
+	for _, user := range s.users {
+		if options.IncludeDeleted || !user.DeletedAt.Valid {
+			users = append(users, *cloneUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	if options.Offset > 0 {
+		if options.Offset >= len(users) {
+			return []models.User{}, nil
+		}
+		users = users[options.Offset:]
+	}
+	if options.Limit > 0 && options.Limit < len(users) {
+		users = users[:options.Limit]
+	}
+	return users, nil
+}
+
+func (r *memoryUsers) Update(ctx context.Context, user *models.User, version uint, columns ...string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.activeUser(user.ID)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && stored.Version != version {
+		return ErrVersionConflict
+	}
+
+	updated := cloneUser(stored)
+	passwordChanged := false
+	for _, column := range columns {
+		switch column {
+		case UserName:
+			updated.Name = user.Name
+		case UserEmail:
+			if s.emailTaken(user.Email, user.ID) {
+				return ErrEmailTaken
+			}
+			updated.Email = user.Email
+		case UserPassword:
+			updated.Password = user.Password
+			passwordChanged = true
+		case UserDisabledAt:
+			updated.DisabledAt = cloneTime(user.DisabledAt)
+		case UserTokensRevokedAt:
+			updated.TokensRevokedAt = cloneTime(user.TokensRevokedAt)
+		case UserRole:
+			updated.Role = user.Role
+		default:
+			return errors.New("unknown user column " + column)
+		}
+	}
+	if passwordChanged {
+		s.passwords[user.ID] = append(s.passwords[user.ID], stored.Password)
+	}
+	updated.Version++
+	updated.UpdatedAt = time.Now()
+	s.users[user.ID] = updated
+	return nil
+}
+
+func (r *memoryUsers) PreviousPasswords(ctx context.Context, userID uint, limit int) ([]string, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.passwords[userID]
+	var hashes []string
+	for i := len(history) - 1; i >= 0 && len(hashes) < limit; i-- {
+		hashes = append(hashes, history[i])
+	}
+	return hashes, nil
+}
+
+func (r *memoryUsers) ReplacePasswordHash(ctx context.Context, userID uint, current, replacement string) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.activeUser(userID)
+	if !ok || stored.Password != current {
+		return nil
+	}
+	updated := cloneUser(stored)
+	updated.Password = replacement
+	s.users[userID] = updated
+	return nil
+}
+
+func (r *memoryUsers) SoftDelete(ctx context.Context, userID, version uint, at time.Time) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.activeUser(userID)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && user.Version != version {
+		return ErrVersionConflict
+	}
+
+	user.DeletedAt = gorm.DeletedAt{Time: at, Valid: true}
+	for _, address := range s.addresses {
+		if address.UserID == userID && !address.DeletedAt.Valid {
+			address.DeletedAt = gorm.DeletedAt{Time: at, Valid: true}
+			address.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (r *memoryUsers) Restore(ctx context.Context, userID uint) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || !user.DeletedAt.Valid {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	for _, address := range s.addresses {
+		if address.UserID == userID && address.DeletedAt.Valid && !address.DeletedAt.Time.Before(user.DeletedAt.Time) {
+			address.DeletedAt = gorm.DeletedAt{}
+			address.UpdatedAt = now
+		}
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	user.UpdatedAt = now
+	return nil
+}
+
+func (r *memoryUsers) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revoked[token.TokenID]; !ok {
+		s.revoked[token.TokenID] = *token
+	}
+	return nil
+}
+
+func (r *memoryUsers) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revoked[tokenID]
+	return ok, nil
+}
+
+func (r *memoryAddresses) Create(ctx context.Context, address *models.Address) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertAddress(address, time.Now())
+}
+
+func (r *memoryAddresses) CreateBatch(ctx context.Context, addresses []*models.Address, atomic bool) ([]error, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rowErrors := make([]error, len(addresses))
+	now := time.Now()
+	for i, address := range addresses {
+		if err := s.insertAddress(address, now); err != nil {
+			rowErrors[i] = err
+			if atomic {
+				// Roll back the rows inserted so far
+				for _, created := range addresses[:i] {
+					delete(s.addresses, created.AddressID)
+					created.AddressID = 0
+				}
+				return rowErrors, err
+			}
+		}
+	}
+	return rowErrors, nil
+}
+
+func (r *memoryAddresses) FindByID(ctx context.Context, addressID, userID uint) (*models.Address, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	address, ok := s.activeAddress(addressID, userID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	clone := *address
+	return &clone, nil
+}
+
+func (r *memoryAddresses) ListByUser(ctx context.Context, userID uint) ([]models.Address, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sortedAddresses(func(a *models.Address) bool {
+		return a.UserID == userID && !a.DeletedAt.Valid
+	}), nil
+}
+
+func (r *memoryAddresses) ListDeleted(ctx context.Context, userID uint) ([]models.Address, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addresses := s.sortedAddresses(func(a *models.Address) bool {
+		return a.UserID == userID && a.DeletedAt.Valid
+	})
+	sort.SliceStable(addresses, func(i, j int) bool {
+		return addresses[i].DeletedAt.Time.After(addresses[j].DeletedAt.Time)
+	})
+	return addresses, nil
+}
+
+func (r *memoryAddresses) Stream(ctx context.Context, userID uint, fn func(*models.Address) error) error {
+	// Work on a snapshot so fn can call back into the store
+	addresses, _ := r.ListByUser(ctx, userID)
+	for i := range addresses {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&addresses[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryAddresses) Update(ctx context.Context, address *models.Address, version uint) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.activeAddress(address.AddressID, address.UserID)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && stored.Version != version {
+		return ErrVersionConflict
+	}
+
+	stored.Street = address.Street
+	stored.Number = address.Number
+	stored.Complement = address.Complement
+	stored.City = address.City
+	stored.State = address.State
+	stored.Zipcode = address.Zipcode
+	stored.Country = address.Country
+	stored.Version++
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryAddresses) Delete(ctx context.Context, addressID, userID, version uint) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address, ok := s.activeAddress(addressID, userID)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != 0 && address.Version != version {
+		return ErrVersionConflict
+	}
+	address.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (r *memoryAddresses) Restore(ctx context.Context, addressID, userID uint) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address, ok := s.addresses[addressID]
+	if !ok || address.UserID != userID || !address.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	address.DeletedAt = gorm.DeletedAt{}
+	address.Version++
+	address.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryIdentities) Create(ctx context.Context, identity *models.Identity) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[identity.UserID]; !ok {
+		return fmt.Errorf("identity owner %d does not exist", identity.UserID)
+	}
+	for _, existing := range s.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return fmt.Errorf("identity %s of %s is already linked", identity.Subject, identity.Provider)
+		}
+	}
+	s.lastIdentityID++
+	identity.ID = s.lastIdentityID
+	s.identities = append(s.identities, *identity)
+	return nil
+}
+
+func (r *memoryIdentities) FindBySubject(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memorySessions) Create(ctx context.Context, session *models.Session) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.sessions {
+		if existing.TokenID == session.TokenID {
+			return fmt.Errorf("session of token %s already exists", session.TokenID)
+		}
+	}
+	s.lastSessionID++
+	session.ID = s.lastSessionID
+	clone := *session
+	s.sessions[session.ID] = &clone
+	return nil
+}
+
+func (r *memorySessions) ListActive(ctx context.Context, userID uint, now time.Time) ([]models.Session, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := []models.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (r *memorySessions) Revoke(ctx context.Context, sessionID, userID uint, at time.Time) (*models.Session, error) {
+	return r.revoke(at, func(session *models.Session) bool {
+		return session.ID == sessionID && session.UserID == userID
+	})
+}
+
+func (r *memorySessions) RevokeByToken(ctx context.Context, tokenID string, at time.Time) (*models.Session, error) {
+	return r.revoke(at, func(session *models.Session) bool {
+		return session.TokenID == tokenID
+	})
+}
+
+func (r *memorySessions) revoke(at time.Time, match func(*models.Session) bool) (*models.Session, error) {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if match(session) && session.RevokedAt == nil && session.ExpiresAt.After(at) {
+			session.RevokedAt = cloneTime(&at)
+			clone := *session
+			clone.RevokedAt = cloneTime(session.RevokedAt)
+			return &clone, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memorySessions) Touch(ctx context.Context, tokenID string, at time.Time, interval time.Duration) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.TokenID == tokenID && session.LastSeenAt.Before(at.Add(-interval)) {
+			session.LastSeenAt = at
+		}
+	}
+	return nil
+}
+
+func (r *memorySessions) Seen(ctx context.Context, userID uint, userAgent string) (bool, bool, error) {
+	s := r.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	anySession, sameUserAgent := false, false
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			anySession = true
+			sameUserAgent = sameUserAgent || session.UserAgent == userAgent
+		}
+	}
+	return anySession, sameUserAgent, nil
+}
+
+func (r *memoryAudit) Append(ctx context.Context, event *models.AuditEvent) error {
+	s := r.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = uint(len(s.auditEvents)) + 1
+	s.auditEvents = append(s.auditEvents, cloneAuditEvent(event))
+	return nil
+}
+
+func (r *memoryAudit) List(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	err := r.Stream(ctx, filter, func(event *models.AuditEvent) error {
+		if len(events) == limit {
+			return errStopStream
+		}
+		events = append(events, *event)
+		return nil
+	})
+	if errors.Is(err, errStopStream) {
+		err = nil
+	}
+	return events, err
+}
+
+// errStopStream ends a Stream early from List
+var errStopStream = errors.New("stop stream")
+
+func (r *memoryAudit) Stream(ctx context.Context, filter AuditFilter, fn func(*models.AuditEvent) error) error {
+	// Work on a snapshot so fn can call back into the store
+	s := r.store
+	s.mu.RLock()
+	var events []models.AuditEvent
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if auditEventMatches(&s.auditEvents[i], filter) {
+			events = append(events, cloneAuditEvent(&s.auditEvents[i]))
+		}
+	}
+	s.mu.RUnlock()
+
+	for i := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditEventMatches(event *models.AuditEvent, filter AuditFilter) bool {
+	matchesID := func(id *uint, want uint) bool {
+		return want == 0 || (id != nil && *id == want)
+	}
+	return matchesID(event.UserID, filter.UserID) &&
+		matchesID(event.ActorID, filter.ActorID) &&
+		(filter.Action == "" || event.Action == filter.Action) &&
+		(filter.TargetType == "" || event.TargetType == filter.TargetType) &&
+		(filter.TargetID == "" || event.TargetID == filter.TargetID) &&
+		(filter.From.IsZero() || !event.OccurredAt.Before(filter.From)) &&
+		(filter.To.IsZero() || event.OccurredAt.Before(filter.To)) &&
+		(filter.BeforeID == 0 || event.ID < filter.BeforeID)
+}
+
+func cloneAuditEvent(event *models.AuditEvent) models.AuditEvent {
+	clone := *event
+	clone.ActorID = cloneID(event.ActorID)
+	clone.UserID = cloneID(event.UserID)
+	clone.Changes = nil
+	for field, change := range event.Changes {
+		if clone.Changes == nil {
+			clone.Changes = models.AuditChanges{}
+		}
+		clone.Changes[field] = change
+	}
+	return clone
+}
+
+func cloneID(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+	clone := *id
+	return &clone
+}
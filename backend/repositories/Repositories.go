@@ -0,0 +1,169 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+var (
+	// ErrNotFound is gorm's own sentinel, so callers checking gorm.ErrRecordNotFound keep working
+	// whichever implementation is behind the interfaces
+	ErrNotFound = gorm.ErrRecordNotFound
+	// ErrVersionConflict is returned when a conditional write targets a version that is no longer current
+	ErrVersionConflict = errors.New("resource was modified by another request")
+	// ErrEmailTaken is returned when another user, deleted or not, already has the email
+	ErrEmailTaken = errors.New("email is already registered")
+)
+
+// Mutable user columns accepted by UserRepository.Update
+const (
+	UserName            = "name"
+	UserEmail           = "email"
+	UserPassword        = "password"
+	UserDisabledAt      = "disabled_at"
+	UserTokensRevokedAt = "tokens_revoked_at"
+	UserRole            = "role"
+)
+
+type ListUsersOptions struct {
+	IncludeDeleted bool
+	Limit          int
+	Offset         int
+}
+
+// UserRepository stores users. Soft-deleted users are invisible unless a method says otherwise.
+type UserRepository interface {
+	// Create inserts the user at version 1 and sets its ID and timestamps
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, userID uint) (*models.User, error)
+	// FindWithAddresses is FindByID with the active addresses of the user loaded
+	FindWithAddresses(ctx context.Context, userID uint) (*models.User, error)
+	FindByEmail(ctx context.Context, email string, includeDeleted bool) (*models.User, error)
+	// List returns users ordered by ID
+	List(ctx context.Context, options ListUsersOptions) ([]models.User, error)
+	// Update writes the given columns of user and bumps its version. A non-zero version makes
+	// the write conditional and returns ErrVersionConflict when it no longer matches.
+	// Writing the password keeps the replaced hash in the password history.
+	Update(ctx context.Context, user *models.User, version uint, columns ...string) error
+	// PreviousPasswords returns up to limit hashes from the password history of the user, newest first
+	PreviousPasswords(ctx context.Context, userID uint, limit int) ([]string, error)
+	// ReplacePasswordHash swaps the stored hash for another hash of the same password, made with a
+	// stronger algorithm or parameters. The version is left alone since nothing visible changes,
+	// and nothing is written when the stored hash is no longer current.
+	ReplacePasswordHash(ctx context.Context, userID uint, current, replacement string) error
+	// SoftDelete deletes the user and its active addresses at the given time, conditionally like Update
+	SoftDelete(ctx context.Context, userID, version uint, at time.Time) error
+	// Restore undeletes the user and the addresses deleted along with it, leaving the ones
+	// that were already in the trash before the account was deleted
+	Restore(ctx context.Context, userID uint) error
+	// RevokeToken records a revoked token, revoking one twice is not an error
+	RevokeToken(ctx context.Context, token *models.RevokedToken) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// AddressRepository stores addresses. Every lookup is filtered by owner, so an address
+// of another user behaves exactly like a missing one.
+type AddressRepository interface {
+	// Create inserts the address at version 1 and sets its ID and timestamps
+	Create(ctx context.Context, address *models.Address) error
+	// CreateBatch inserts the addresses in a single transaction and reports the error of each row,
+	// nil for the ones created. When atomic, the first failure rolls every row back and is also returned as err.
+	CreateBatch(ctx context.Context, addresses []*models.Address, atomic bool) (rowErrors []error, err error)
+	FindByID(ctx context.Context, addressID, userID uint) (*models.Address, error)
+	// ListByUser returns the active addresses of the user ordered by ID
+	ListByUser(ctx context.Context, userID uint) ([]models.Address, error)
+	// ListDeleted returns the soft-deleted addresses of the user, most recently deleted first
+	ListDeleted(ctx context.Context, userID uint) ([]models.Address, error)
+	// Stream calls fn with each active address of the user ordered by ID, without loading them all at once
+	Stream(ctx context.Context, userID uint, fn func(*models.Address) error) error
+	// Update replaces the editable fields and bumps the version, conditionally like UserRepository.Update
+	Update(ctx context.Context, address *models.Address, version uint) error
+	// Delete soft-deletes the address, conditionally like Update
+	Delete(ctx context.Context, addressID, userID, version uint) error
+	// Restore takes the address out of the trash and bumps its version
+	Restore(ctx context.Context, addressID, userID uint) error
+}
+
+// SessionRepository stores the sessions opened by logins. Like addresses, a session of another user
+// behaves exactly like a missing one.
+type SessionRepository interface {
+	// Create inserts the session and sets its ID
+	Create(ctx context.Context, session *models.Session) error
+	// ListActive returns the sessions of the user neither revoked nor expired at now, most recently seen first
+	ListActive(ctx context.Context, userID uint, now time.Time) ([]models.Session, error)
+	// Revoke marks an active session of the user as revoked and returns it, ErrNotFound when there is none
+	Revoke(ctx context.Context, sessionID, userID uint, at time.Time) (*models.Session, error)
+	// RevokeByToken is Revoke for the session of the token
+	RevokeByToken(ctx context.Context, tokenID string, at time.Time) (*models.Session, error)
+	// Touch moves the last seen time of the session of the token to at, skipping the write while
+	// the recorded time is less than interval old
+	Touch(ctx context.Context, tokenID string, at time.Time, interval time.Duration) error
+	// Seen reports whether the user has any session, expired and revoked ones included, and whether one came from userAgent
+	Seen(ctx context.Context, userID uint, userAgent string) (anySession, sameUserAgent bool, err error)
+}
+
+// IdentityRepository stores the links between users and their accounts at OpenID Connect providers
+type IdentityRepository interface {
+	// Create links the identity to its user and sets its ID, failing when the provider's subject is already linked
+	Create(ctx context.Context, identity *models.Identity) error
+	// FindBySubject returns the identity of the subject at the provider, ErrNotFound when it isn't linked
+	FindBySubject(ctx context.Context, provider, subject string) (*models.Identity, error)
+}
+
+// AuditFilter selects audit events, zero fields match every event
+type AuditFilter struct {
+	UserID     uint
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   string
+	// From and To bound OccurredAt, From included and To excluded
+	From time.Time
+	To   time.Time
+	// BeforeID pages through the events, only the ones older than the event with that ID match
+	BeforeID uint
+}
+
+// AuditRepository stores the audit log. Events can only be appended, the database refuses
+// to update or delete them.
+type AuditRepository interface {
+	// Append inserts the event and sets its ID
+	Append(ctx context.Context, event *models.AuditEvent) error
+	// List returns up to limit events matching the filter, newest first
+	List(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEvent, error)
+	// Stream calls fn with each event matching the filter, newest first, without loading them all at once
+	Stream(ctx context.Context, filter AuditFilter, fn func(*models.AuditEvent) error) error
+}
+
+// OAuthRepository stores the clients of the OpenID Connect provider and what they were granted.
+// Codes and refresh tokens are looked up by the SHA-256 of their value.
+type OAuthRepository interface {
+	// CreateClient inserts the client and sets its ID
+	CreateClient(ctx context.Context, client *models.OAuthClient) error
+	FindClient(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	// ListClients returns every client ordered by ID
+	ListClients(ctx context.Context) ([]models.OAuthClient, error)
+	// DeleteClient deletes the client along with its consents, codes and tokens, ErrNotFound when there is none
+	DeleteClient(ctx context.Context, clientID string) error
+	// FindConsent returns the consent of the user to the client, ErrNotFound when the user never gave one
+	FindConsent(ctx context.Context, userID uint, clientID string) (*models.OAuthConsent, error)
+	// SaveConsent creates the consent or replaces the scopes of the existing one
+	SaveConsent(ctx context.Context, consent *models.OAuthConsent) error
+	CreateCode(ctx context.Context, code *models.OAuthCode) error
+	// RedeemCode deletes and returns the code unexpired at now, ErrNotFound when there is none,
+	// so that only one of concurrent redemptions gets it
+	RedeemCode(ctx context.Context, codeHash string, now time.Time) (*models.OAuthCode, error)
+	CreateRefreshToken(ctx context.Context, token *models.OAuthRefreshToken) error
+	// FindRefreshToken returns the token revoked or not, ErrNotFound when there is none
+	FindRefreshToken(ctx context.Context, tokenHash string) (*models.OAuthRefreshToken, error)
+	// RotateRefreshToken revokes the token and creates its replacement in one transaction,
+	// ErrNotFound when the token was already revoked
+	RotateRefreshToken(ctx context.Context, tokenID uint, replacement *models.OAuthRefreshToken, at time.Time) error
+	// RevokeRefreshTokens revokes every token the user granted the client
+	RevokeRefreshTokens(ctx context.Context, userID uint, clientID string, at time.Time) error
+}
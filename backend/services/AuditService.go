@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/logging"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditUserRegistered    = "user.registered"
+	AuditLogin             = "user.login"
+	AuditLoginFailed       = "user.login_failed"
+	AuditLogout            = "user.logout"
+	AuditUserUpdated       = "user.updated"
+	AuditUserDeleted       = "user.deleted"
+	AuditUserRestored      = "user.restored"
+	AuditUserDisabled      = "user.disabled"
+	AuditUserEnabled       = "user.enabled"
+	AuditPasswordReset     = "user.password_reset"
+	AuditTokenRevoked      = "user.token_revoked"
+	AuditTokensRevoked     = "user.tokens_revoked"
+	AuditRoleChanged       = "user.role_changed"
+	AuditIdentityLinked    = "user.identity_linked"
+	AuditSessionRevoked    = "session.revoked"
+	AuditAddressCreated    = "address.created"
+	AuditAddressUpdated    = "address.updated"
+	AuditAddressDeleted    = "address.deleted"
+	AuditAddressRestored   = "address.restored"
+	AuditAddressesImported = "address.imported"
+
+	AuditOAuthClientRegistered   = "oauth.client_registered"
+	AuditOAuthClientDeleted      = "oauth.client_deleted"
+	AuditOAuthConsentGranted     = "oauth.consent_granted"
+	AuditOAuthRefreshTokenReused = "oauth.refresh_token_reused"
+)
+
+// Types of the targets of audit events
+const (
+	AuditTargetUser    = "user"
+	AuditTargetSession = "session"
+	AuditTargetAddress = "address"
+	// AuditTargetOAuthClient events have the client_id as target
+	AuditTargetOAuthClient = "oauth_client"
+)
+
+type AuditFilter = repositories.AuditFilter
+
+// auditMask stands for the values of sensitive fields, the audit log only shows that they changed
+const auditMask = "[REDACTED]"
+
+var auditSensitiveFields = map[string]bool{"password": true}
+
+// maxAuditIPLength is the size of the audit_events.ip column
+const maxAuditIPLength = 64
+
+// AuditService writes and reads the audit log. A nil AuditService records nothing.
+type AuditService struct {
+	Events repositories.AuditRepository
+	Logger *slog.Logger
+}
+
+func (s *AuditService) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// Record appends the event to the log, with the authenticated user of ctx as the actor unless
+// the event already has one, and the client IP and request ID of ctx. Events are recorded once
+// the action is done, so a failed write is logged instead of failing the action.
+func (s *AuditService) Record(ctx context.Context, event models.AuditEvent) {
+	if s == nil || s.Events == nil {
+		return
+	}
+
+	event.OccurredAt = time.Now()
+	if actorID, ok := logging.UserID(ctx); ok && event.ActorID == nil {
+		event.ActorID = &actorID
+	}
+	event.IP = logging.ClientIP(ctx)
+	if len(event.IP) > maxAuditIPLength {
+		event.IP = event.IP[:maxAuditIPLength]
+	}
+	event.RequestID = logging.RequestID(ctx)
+
+	// The action already happened, a client going away must not lose its record
+	if err := s.Events.Append(context.WithoutCancel(ctx), &event); err != nil {
+		s.logger().ErrorContext(ctx, "audit event write failed", "event", event, "error", err)
+	}
+}
+
+// ListEvents returns up to limit events matching the filter, newest first
+func (s *AuditService) ListEvents(ctx context.Context, filter AuditFilter, limit int) (_ []models.AuditEvent, err error) {
+	ctx, span := tracing.Start(ctx, "AuditService.ListEvents")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Events.List(ctx, filter, limit)
+}
+
+// ExportEvents writes every event matching the filter to w as NDJSON, newest first
+func (s *AuditService) ExportEvents(ctx context.Context, filter AuditFilter, w io.Writer) (err error) {
+	ctx, span := tracing.Start(ctx, "AuditService.ExportEvents")
+	defer func() { tracing.End(span, err) }()
+
+	encoder := json.NewEncoder(w)
+	return s.Events.Stream(ctx, filter, func(event *models.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+}
+
+// auditDiff returns the fields whose value differs between before and after, a nil map standing for
+// a record that doesn't exist on that side. Sensitive fields are masked.
+func auditDiff(before, after map[string]string) models.AuditChanges {
+	var changes models.AuditChanges
+	add := func(field string) {
+		beforeValue, inBefore := before[field]
+		afterValue, inAfter := after[field]
+		if beforeValue == afterValue {
+			return
+		}
+		var change models.AuditChange
+		if inBefore {
+			change.Before = beforeValue
+		}
+		if inAfter {
+			change.After = afterValue
+		}
+		if auditSensitiveFields[field] {
+			if inBefore {
+				change.Before = auditMask
+			}
+			if inAfter {
+				change.After = auditMask
+			}
+		}
+		if changes == nil {
+			changes = models.AuditChanges{}
+		}
+		changes[field] = change
+	}
+	for field := range before {
+		add(field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			add(field)
+		}
+	}
+	return changes
+}
+
+func userAuditFields(user *models.User) map[string]string {
+	return map[string]string{"name": user.Name, "email": user.Email, "password": user.Password}
+}
+
+func addressAuditFields(address *models.Address) map[string]string {
+	return map[string]string{
+		"street":     address.Street,
+		"number":     address.Number,
+		"complement": address.Complement,
+		"city":       address.City,
+		"state":      address.State,
+		"zipcode":    address.Zipcode,
+		"country":    address.Country,
+	}
+}
+
+// userEvent returns an event about the account of userID
+func userEvent(action string, userID uint) models.AuditEvent {
+	return models.AuditEvent{Action: action, UserID: &userID, TargetType: AuditTargetUser, TargetID: auditID(userID)}
+}
+
+// addressEvent returns an event about an address of userID
+func addressEvent(action string, userID, addressID uint) models.AuditEvent {
+	return models.AuditEvent{Action: action, UserID: &userID, TargetType: AuditTargetAddress, TargetID: auditID(addressID)}
+}
+
+func auditID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/passwords"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+)
+
+var (
+	// ErrVersionConflict is returned when a conditional write targets a version that is no longer current
+	ErrVersionConflict = repositories.ErrVersionConflict
+	ErrEmailTaken      = repositories.ErrEmailTaken
+	ErrAccountDisabled = errors.New("account is disabled")
+	ErrTokenRevoked    = errors.New("token has been revoked")
+	// ErrHashingBusy is returned when password hashing is saturated, the request can be retried shortly
+	ErrHashingBusy = hashing.ErrBusy
+	// ErrPasswordRejected is wrapped by the errors listing why a new password doesn't meet the policy
+	ErrPasswordRejected = passwords.ErrRejected
+	// ErrEmailNotVerified refuses OpenID Connect logins of accounts not linked yet whose email the provider didn't verify
+	ErrEmailNotVerified = errors.New("the provider hasn't verified the email of this account")
+	// ErrAccountDeleted refuses OpenID Connect logins of deleted accounts, only a password login restores them
+	ErrAccountDeleted = errors.New("account is deleted")
+)
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// DefaultIdempotencyTTL is how long a stored response can be replayed
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyLockTimeout is how long an in-flight key is kept before it is considered abandoned,
+// for instance because the instance processing it crashed
+const idempotencyLockTimeout = time.Minute
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+type IdempotencyService struct {
+	DB  *gorm.DB
+	TTL time.Duration
+}
+
+func (s *IdempotencyService) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return DefaultIdempotencyTTL
+	}
+	return s.TTL
+}
+
+// Begin reserves the key for the user. A returned record with ResponseStatus 0 belongs to the caller, which
+// must run the request and then call Complete or Release; any other record holds the response to replay.
+func (s *IdempotencyService) Begin(key string, userID uint, requestHash string) (*models.IdempotencyKey, error) {
+	now := time.Now()
+	if err := s.DB.Where("idempotency_key = ? AND user_id = ? AND expires_at < ?", key, userID, now).Delete(&models.IdempotencyKey{}).Error; err != nil {
+		return nil, err
+	}
+
+	record := &models.IdempotencyKey{
+		Key:         key,
+		UserID:      userID,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(s.ttl()),
+	}
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return record, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := s.DB.Where("idempotency_key = ? AND user_id = ?", key, userID).First(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if existing.ResponseStatus != 0 {
+		return &existing, nil
+	}
+
+	// Take over a reservation whose owner never completed it
+	taken := s.DB.Model(&models.IdempotencyKey{}).
+		Where("id = ? AND response_status = 0 AND created_at < ?", existing.ID, now.Add(-idempotencyLockTimeout)).
+		Updates(map[string]interface{}{"created_at": now, "expires_at": now.Add(s.ttl())})
+	if taken.Error != nil {
+		return nil, taken.Error
+	}
+	if taken.RowsAffected == 0 {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	existing.CreatedAt = now
+	return &existing, nil
+}
+
+// Complete stores the response of a request that was reserved with Begin
+func (s *IdempotencyService) Complete(record *models.IdempotencyKey, status int, headers http.Header, body []byte) error {
+	encodedHeaders, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	record.ResponseStatus = status
+	record.ResponseHeaders = string(encodedHeaders)
+	record.ResponseBody = body
+	return s.DB.Model(record).Updates(map[string]interface{}{
+		"response_status":  status,
+		"response_headers": record.ResponseHeaders,
+		"response_body":    body,
+	}).Error
+}
+
+// Release drops a reservation so that the request can be retried, used when it failed on the server side
+func (s *IdempotencyService) Release(record *models.IdempotencyKey) error {
+	return s.DB.Delete(record).Error
+}
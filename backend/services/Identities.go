@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/metrics"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+// ExternalIdentity is an account at an OpenID Connect provider, as asserted by its ID token
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// LoginWithIdentity opens a session for the user linked to the identity. An identity seen for the first
+// time is linked to the account with its email, or to a new account without a password, but only once
+// the provider verified the email: otherwise anyone could take over an account by signing up at the
+// provider with its email.
+func (s *UserService) LoginWithIdentity(ctx context.Context, identity ExternalIdentity, device Device) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.LoginWithIdentity")
+	defer func() { tracing.End(span, err) }()
+
+	linked, err := s.Identities.FindBySubject(ctx, identity.Provider, identity.Subject)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return "", err
+	}
+
+	var user *models.User
+	if linked != nil {
+		user, err = s.Users.FindByID(ctx, linked.UserID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			s.Metrics.LoginAttempt(metrics.LoginFailure)
+			s.logger().InfoContext(ctx, "login rejected", "reason", "account deleted", "identity", linked)
+			s.Audit.Record(ctx, userEvent(AuditLoginFailed, linked.UserID))
+			return "", ErrAccountDeleted
+		}
+	} else {
+		user, err = s.identityAccount(ctx, identity)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if user.DisabledAt != nil {
+		s.Metrics.LoginAttempt(metrics.LoginDisabled)
+		s.logger().InfoContext(ctx, "login rejected", "reason", "account disabled", "user", user)
+		s.Audit.Record(ctx, userEvent(AuditLoginFailed, user.ID))
+		return "", ErrAccountDisabled
+	}
+
+	if linked == nil {
+		linked = &models.Identity{
+			UserID:    user.ID,
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: time.Now(),
+		}
+		if err := s.Identities.Create(ctx, linked); err != nil {
+			return "", err
+		}
+		s.logger().InfoContext(ctx, "identity linked", "identity", linked)
+		event := userEvent(AuditIdentityLinked, user.ID)
+		event.ActorID = &user.ID
+		event.Changes = models.AuditChanges{"provider": {After: identity.Provider}}
+		s.Audit.Record(ctx, event)
+	}
+
+	return s.openSession(ctx, user, device)
+}
+
+// identityAccount returns the account an identity seen for the first time belongs to, registering one
+// when no user has its email. Deleted accounts keep their email, so they aren't taken over by a new one.
+func (s *UserService) identityAccount(ctx context.Context, identity ExternalIdentity) (*models.User, error) {
+	if !identity.EmailVerified || identity.Email == "" {
+		s.Metrics.LoginAttempt(metrics.LoginFailure)
+		s.logger().InfoContext(ctx, "login rejected", "reason", "unverified email", "provider", identity.Provider)
+		s.Audit.Record(ctx, models.AuditEvent{Action: AuditLoginFailed, TargetType: AuditTargetUser})
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err := s.Users.FindByEmail(ctx, identity.Email, false)
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return user, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	// Without a password the account can only log in through its identities until the user sets one
+	user = &models.User{Name: name, Email: identity.Email, Role: models.RoleUser}
+	if err := s.Users.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	s.Metrics.UserRegistered()
+
+	event := userEvent(AuditUserRegistered, user.ID)
+	event.ActorID = &user.ID
+	event.Changes = auditDiff(nil, map[string]string{"name": user.Name, "email": user.Email})
+	s.Audit.Record(ctx, event)
+	return user, nil
+}
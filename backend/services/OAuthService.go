@@ -0,0 +1,578 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+// Grant types clients can be allowed to use
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Scopes clients can request, each one releasing some claims of the user
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// Error codes of RFC 6749 and OpenID Connect used by the provider
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthAccessDenied            = "access_denied"
+	OAuthLoginRequired           = "login_required"
+	OAuthConsentRequired         = "consent_required"
+	OAuthInvalidToken            = "invalid_token"
+	OAuthInsufficientScope       = "insufficient_scope"
+)
+
+// OAuthCodeLifetime is how long an authorization code can be exchanged for tokens
+const OAuthCodeLifetime = time.Minute
+
+var (
+	supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+	supportedGrants = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials}
+)
+
+// OAuthError is an error the provider reports to the client with its OAuth error code
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// OAuthService makes the API an OpenID Connect provider: clients send users to the authorization
+// endpoint, where they log in with UserService and consent, and get tokens for them in exchange
+type OAuthService struct {
+	OAuth repositories.OAuthRepository
+	Users *UserService
+	// Issuer is the public URL of the API, the prefix of every endpoint of the provider
+	Issuer     string
+	SigningKey *rsa.PrivateKey
+	// AccessTokenLifetime defaults to 15 minutes, RefreshTokenLifetime to 30 days
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	Logger               *slog.Logger
+	Audit                *AuditService
+}
+
+func (s *OAuthService) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *OAuthService) accessTokenLifetime() time.Duration {
+	if s.AccessTokenLifetime <= 0 {
+		return 15 * time.Minute
+	}
+	return s.AccessTokenLifetime
+}
+
+func (s *OAuthService) refreshTokenLifetime() time.Duration {
+	if s.RefreshTokenLifetime <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return s.RefreshTokenLifetime
+}
+
+// NewOAuthClient describes a client to register. Scopes default to every supported scope,
+// GrantTypes to authorization_code and refresh_token.
+type NewOAuthClient struct {
+	Name         string
+	RedirectURIs []string
+	Scopes       []string
+	GrantTypes   []string
+	// Public clients, like single page and mobile apps, get no secret and must use PKCE
+	Public bool
+}
+
+// RegisterClient creates a client and returns it with its secret, which is not stored and can't be shown again
+func (s *OAuthService) RegisterClient(ctx context.Context, input NewOAuthClient) (*models.OAuthClient, string, error) {
+	client := &models.OAuthClient{
+		Name:         strings.TrimSpace(input.Name),
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+		GrantTypes:   input.GrantTypes,
+		CreatedAt:    time.Now(),
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = supportedScopes
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+	}
+	if err := validateClient(client, input.Public); err != nil {
+		return nil, "", err
+	}
+
+	clientID, err := oidc.RandomString()
+	if err != nil {
+		return nil, "", err
+	}
+	client.ClientID = clientID[:22]
+	var secret string
+	if !input.Public {
+		if secret, err = oidc.RandomString(); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashSecret(secret)
+	}
+
+	if err := s.OAuth.CreateClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+	s.logger().InfoContext(ctx, "oauth client registered", "client", client)
+	s.Audit.Record(ctx, clientEvent(AuditOAuthClientRegistered, client.ClientID))
+	return client, secret, nil
+}
+
+func validateClient(client *models.OAuthClient, public bool) error {
+	var errs []error
+	if client.Name == "" {
+		errs = append(errs, errors.New("the client needs a name"))
+	}
+	for _, scope := range client.Scopes {
+		if !slices.Contains(supportedScopes, scope) {
+			errs = append(errs, fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(supportedScopes, ", ")))
+		}
+	}
+	for _, grant := range client.GrantTypes {
+		if !slices.Contains(supportedGrants, grant) {
+			errs = append(errs, fmt.Errorf("unknown grant type %q, expected one of %s", grant, strings.Join(supportedGrants, ", ")))
+		}
+	}
+	if public && client.GrantTypes.Contains(GrantClientCredentials) {
+		errs = append(errs, errors.New("public clients can't use the client_credentials grant"))
+	}
+	if client.GrantTypes.Contains(GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		errs = append(errs, errors.New("the authorization_code grant needs at least one redirect URI"))
+	}
+	for _, redirectURI := range client.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRedirectURI accepts absolute URIs without fragment. Plain http is only allowed on the
+// loopback interface, for native apps and development.
+func validateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.Contains(redirectURI, "#") {
+		return fmt.Errorf("redirect URI %q must be absolute and without fragment", redirectURI)
+	}
+	if parsed.Scheme == "http" {
+		host := parsed.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("redirect URI %q must use https", redirectURI)
+		}
+	}
+	return nil
+}
+
+func (s *OAuthService) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	return s.OAuth.ListClients(ctx)
+}
+
+// DeleteClient deletes the client, its consents and tokens. Access tokens already issued stay valid until they expire.
+func (s *OAuthService) DeleteClient(ctx context.Context, clientID string) error {
+	if err := s.OAuth.DeleteClient(ctx, clientID); err != nil {
+		return err
+	}
+	s.logger().InfoContext(ctx, "oauth client deleted", "client_id", clientID)
+	s.Audit.Record(ctx, clientEvent(AuditOAuthClientDeleted, clientID))
+	return nil
+}
+
+// AuthorizationRequest holds the parameters a client sends to the authorization endpoint
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// CheckAuthorization validates an authorization request and returns the client and the requested scopes.
+// Errors are OAuthErrors, with a nil client when the client or redirect URI is unknown: the user
+// must then be told instead of being redirected.
+func (s *OAuthService) CheckAuthorization(ctx context.Context, req AuthorizationRequest) (*models.OAuthClient, models.StringList, error) {
+	client, err := s.OAuth.FindClient(ctx, req.ClientID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil, oauthError(OAuthInvalidClient, "unknown client_id")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, oauthError(OAuthInvalidRequest, "redirect_uri isn't registered for this client")
+	}
+
+	if req.ResponseType != "code" {
+		return client, nil, oauthError(OAuthUnsupportedResponseType, "only the code response type is supported")
+	}
+	if !client.GrantTypes.Contains(GrantAuthorizationCode) {
+		return client, nil, oauthError(OAuthUnauthorizedClient, "the client isn't allowed the authorization_code grant")
+	}
+	scopes, err := requestedScopes(req.Scope, client.Scopes)
+	if err != nil {
+		return client, nil, err
+	}
+	if req.CodeChallenge == "" {
+		if client.Public() {
+			return client, nil, oauthError(OAuthInvalidRequest, "public clients must send a PKCE code_challenge")
+		}
+	} else if req.CodeChallengeMethod != "S256" {
+		return client, nil, oauthError(OAuthInvalidRequest, "code_challenge_method must be S256")
+	}
+	return client, scopes, nil
+}
+
+// requestedScopes parses a scope parameter, which must only hold allowed scopes and defaults to all of them
+func requestedScopes(scope string, allowed models.StringList) (models.StringList, error) {
+	scopes := models.StringList(strings.Fields(scope))
+	if len(scopes) == 0 {
+		return allowed, nil
+	}
+	for _, requested := range scopes {
+		if !allowed.Contains(requested) {
+			return nil, oauthError(OAuthInvalidScope, fmt.Sprintf("scope %q isn't allowed", requested))
+		}
+	}
+	return slices.Compact(scopes), nil
+}
+
+// HasConsent reports whether the user already allowed the client every scope
+func (s *OAuthService) HasConsent(ctx context.Context, userID uint, clientID string, scopes models.StringList) (bool, error) {
+	consent, err := s.OAuth.FindConsent(ctx, userID, clientID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return consent.Scopes.Contains(scopes...), nil
+}
+
+// Authorize records the consent of the user, who logged in at authTime, and returns the URL redirecting
+// back to the client with an authorization code
+func (s *OAuthService) Authorize(ctx context.Context, userID uint, authTime time.Time, client *models.OAuthClient, req AuthorizationRequest, scopes models.StringList) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.Authorize")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.saveConsent(ctx, userID, client.ClientID, scopes); err != nil {
+		return "", err
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	err = s.OAuth.CreateCode(ctx, &models.OAuthCode{
+		CodeHash:      hashSecret(code),
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      authTime,
+		ExpiresAt:     now.Add(OAuthCodeLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s.redirect(req.RedirectURI, req.State, url.Values{"code": {code}}), nil
+}
+
+// saveConsent adds the scopes to the ones the user already allowed the client
+func (s *OAuthService) saveConsent(ctx context.Context, userID uint, clientID string, scopes models.StringList) error {
+	consent, err := s.OAuth.FindConsent(ctx, userID, clientID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		consent, err = &models.OAuthConsent{UserID: userID, ClientID: clientID}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if consent.ID != 0 && consent.Scopes.Contains(scopes...) {
+		return nil
+	}
+
+	var before map[string]string
+	if consent.ID != 0 {
+		before = map[string]string{"scopes": consent.Scopes.String()}
+	}
+	for _, scope := range scopes {
+		if !consent.Scopes.Contains(scope) {
+			consent.Scopes = append(consent.Scopes, scope)
+		}
+	}
+	consent.UpdatedAt = time.Now()
+	if err := s.OAuth.SaveConsent(ctx, consent); err != nil {
+		return err
+	}
+	event := clientEvent(AuditOAuthConsentGranted, clientID)
+	event.UserID, event.ActorID = &userID, &userID
+	event.Changes = auditDiff(before, map[string]string{"scopes": consent.Scopes.String()})
+	s.Audit.Record(ctx, event)
+	return nil
+}
+
+// RedirectWithError returns the URL sending the error back to the client
+func (s *OAuthService) RedirectWithError(redirectURI, state string, oauthErr *OAuthError) string {
+	params := url.Values{"error": {oauthErr.Code}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	return s.redirect(redirectURI, state, params)
+}
+
+// redirect adds the response parameters to the redirect URI, along with the state and the issuer
+// which lets clients talking to several providers check where the response comes from (RFC 9207)
+func (s *OAuthService) redirect(redirectURI, state string, params url.Values) string {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	query.Set("iss", s.Issuer)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// AuthenticateClient checks the credentials a client sent to the token endpoint. Public clients send no secret.
+func (s *OAuthService) AuthenticateClient(ctx context.Context, clientID, secret string) (*models.OAuthClient, error) {
+	client, err := s.OAuth.FindClient(ctx, clientID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, oauthError(OAuthInvalidClient, "client authentication failed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Public() {
+		if secret != "" {
+			return nil, oauthError(OAuthInvalidClient, "client authentication failed")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(client.SecretHash)) != 1 {
+		s.logger().InfoContext(ctx, "oauth client authentication failed", "client", client)
+		return nil, oauthError(OAuthInvalidClient, "client authentication failed")
+	}
+	return client, nil
+}
+
+// ExchangeCode redeems an authorization code issued to the client for tokens
+func (s *OAuthService) ExchangeCode(ctx context.Context, client *models.OAuthClient, code, redirectURI, verifier string) (_ *TokenResponse, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.ExchangeCode")
+	defer func() { tracing.End(span, err) }()
+
+	if !client.GrantTypes.Contains(GrantAuthorizationCode) {
+		return nil, oauthError(OAuthUnauthorizedClient, "the client isn't allowed the authorization_code grant")
+	}
+	issued, err := s.OAuth.RedeemCode(ctx, hashSecret(code), time.Now())
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, oauthError(OAuthInvalidGrant, "the code is invalid or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if issued.ClientID != client.ClientID || issued.RedirectURI != redirectURI {
+		return nil, oauthError(OAuthInvalidGrant, "the code was issued to another client or redirect_uri")
+	}
+	// A verifier without challenge could be an attacker injecting a code stolen from a client without PKCE
+	if (issued.CodeChallenge == "") != (verifier == "") ||
+		(verifier != "" && subtle.ConstantTimeCompare([]byte(oidc.CodeChallenge(verifier)), []byte(issued.CodeChallenge)) != 1) {
+		return nil, oauthError(OAuthInvalidGrant, "code_verifier doesn't match the code_challenge")
+	}
+
+	user, err := s.activeUser(ctx, issued.UserID, issued.AuthTime)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, client, user, issued.Scopes, issued.Nonce, issued.AuthTime, nil)
+}
+
+// Refresh exchanges a refresh token for new tokens, scope optionally narrowing the access token.
+// The refresh token is replaced, and presenting a replaced one revokes every token the user granted
+// the client since one of them leaked.
+func (s *OAuthService) Refresh(ctx context.Context, client *models.OAuthClient, refreshToken, scope string) (_ *TokenResponse, err error) {
+	ctx, span := tracing.Start(ctx, "OAuthService.Refresh")
+	defer func() { tracing.End(span, err) }()
+
+	if !client.GrantTypes.Contains(GrantRefreshToken) {
+		return nil, oauthError(OAuthUnauthorizedClient, "the client isn't allowed the refresh_token grant")
+	}
+	token, err := s.OAuth.FindRefreshToken(ctx, hashSecret(refreshToken))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, oauthError(OAuthInvalidGrant, "the refresh token is invalid")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.ClientID != client.ClientID {
+		return nil, oauthError(OAuthInvalidGrant, "the refresh token is invalid")
+	}
+	if token.RevokedAt != nil {
+		return nil, s.refreshTokenReused(ctx, token)
+	}
+	if !token.ExpiresAt.After(time.Now()) {
+		return nil, oauthError(OAuthInvalidGrant, "the refresh token expired")
+	}
+	scopes, err := requestedScopes(scope, token.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.activeUser(ctx, token.UserID, token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, client, user, scopes, "", token.AuthTime, token)
+}
+
+// refreshTokenReused revokes the tokens of the user and client after a replaced refresh token came back
+func (s *OAuthService) refreshTokenReused(ctx context.Context, token *models.OAuthRefreshToken) error {
+	if err := s.OAuth.RevokeRefreshTokens(ctx, token.UserID, token.ClientID, time.Now()); err != nil {
+		return err
+	}
+	s.logger().WarnContext(ctx, "oauth refresh token reused, tokens of the client revoked", "user_id", token.UserID, "client_id", token.ClientID)
+	event := clientEvent(AuditOAuthRefreshTokenReused, token.ClientID)
+	event.UserID = &token.UserID
+	s.Audit.Record(ctx, event)
+	return oauthError(OAuthInvalidGrant, "the refresh token is invalid")
+}
+
+// ClientCredentials issues an access token to a confidential client acting on its own behalf.
+// It carries no user, so the OpenID Connect scopes can't be requested.
+func (s *OAuthService) ClientCredentials(ctx context.Context, client *models.OAuthClient, scope string) (*TokenResponse, error) {
+	if client.Public() || !client.GrantTypes.Contains(GrantClientCredentials) {
+		return nil, oauthError(OAuthUnauthorizedClient, "the client isn't allowed the client_credentials grant")
+	}
+	if strings.TrimSpace(scope) != "" {
+		return nil, oauthError(OAuthInvalidScope, "client_credentials tokens carry no user scope")
+	}
+	accessToken, err := s.signAccessToken(client.ClientID, client.ClientID, nil, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{AccessToken: accessToken, TokenType: "Bearer", ExpiresIn: int(s.accessTokenLifetime().Seconds())}, nil
+}
+
+// activeUser returns the user tokens are issued for, refusing deleted and disabled accounts and
+// grants made before every token of the user was revoked
+func (s *OAuthService) activeUser(ctx context.Context, userID uint, grantedAt time.Time) (*models.User, error) {
+	user, err := s.Users.Users.FindByID(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, oauthError(OAuthInvalidGrant, "the account no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil || revokedByUser(user, grantedAt) {
+		return nil, oauthError(OAuthInvalidGrant, "the grant has been revoked")
+	}
+	return user, nil
+}
+
+// issueTokens returns the access token of the grant, an ID token with the openid scope and a refresh token
+// when the client may use them. A refresh token being redeemed is replaced by the new one.
+func (s *OAuthService) issueTokens(ctx context.Context, client *models.OAuthClient, user *models.User, scopes models.StringList,
+	nonce string, authTime time.Time, redeemed *models.OAuthRefreshToken) (*TokenResponse, error) {
+	now := time.Now()
+	response := &TokenResponse{TokenType: "Bearer", ExpiresIn: int(s.accessTokenLifetime().Seconds()), Scope: scopes.String()}
+	var err error
+	if response.AccessToken, err = s.signAccessToken(auditID(user.ID), client.ClientID, scopes, now); err != nil {
+		return nil, err
+	}
+	if scopes.Contains(ScopeOpenID) {
+		if response.IDToken, err = s.signIDToken(user, client.ClientID, scopes, nonce, authTime, now); err != nil {
+			return nil, err
+		}
+	}
+	if !client.GrantTypes.Contains(GrantRefreshToken) {
+		return response, nil
+	}
+
+	if response.RefreshToken, err = oidc.RandomString(); err != nil {
+		return nil, err
+	}
+	token := &models.OAuthRefreshToken{
+		TokenHash: hashSecret(response.RefreshToken),
+		ClientID:  client.ClientID,
+		UserID:    user.ID,
+		Scopes:    scopes,
+		AuthTime:  authTime,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.refreshTokenLifetime()),
+	}
+	if redeemed == nil {
+		err = s.OAuth.CreateRefreshToken(ctx, token)
+	} else {
+		// The new refresh token keeps the scopes of the grant, only the access token is narrowed
+		token.Scopes = redeemed.Scopes
+		err = s.OAuth.RotateRefreshToken(ctx, redeemed.ID, token, now)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, s.refreshTokenReused(ctx, redeemed)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// hashSecret is how client secrets, codes and refresh tokens are stored. They are random, so unlike
+// passwords they don't need a slow hash.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientEvent returns an event about an OAuth client
+func clientEvent(action, clientID string) models.AuditEvent {
+	return models.AuditEvent{Action: action, TargetType: AuditTargetOAuthClient, TargetID: clientID}
+}
@@ -0,0 +1,262 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+)
+
+const oauthIssuer = "https://api.example.com"
+
+type OAuthServiceTestSuite struct {
+	suite.Suite
+	OAuthService *services.OAuthService
+	DB           *gorm.DB
+	ctx          context.Context
+}
+
+func (suite *OAuthServiceTestSuite) SetupTest() {
+	suite.ctx = context.Background()
+	suite.DB = testutils.NewTestDB(suite.T())
+	suite.OAuthService = &services.OAuthService{
+		OAuth:      &repositories.GormOAuthRepository{DB: suite.DB},
+		Users:      &services.UserService{Users: &repositories.GormUserRepository{DB: suite.DB}, JWTSecret: testutils.JWTSecret},
+		Issuer:     oauthIssuer,
+		SigningKey: testutils.OAuthSigningKey(suite.T()),
+		Audit:      &services.AuditService{Events: &repositories.GormAuditRepository{DB: suite.DB}},
+	}
+}
+
+func (suite *OAuthServiceTestSuite) registerClient(input services.NewOAuthClient) (*models.OAuthClient, string) {
+	if input.Name == "" {
+		input.Name = "Internal App"
+	}
+	if input.RedirectURIs == nil {
+		input.RedirectURIs = []string{"https://app.example.com/callback"}
+	}
+	client, secret, err := suite.OAuthService.RegisterClient(suite.ctx, input)
+	suite.Require().NoError(err)
+	return client, secret
+}
+
+// authorize runs the authorization endpoint for the user and returns the code sent to the client
+func (suite *OAuthServiceTestSuite) authorize(user *models.User, client *models.OAuthClient, req services.AuthorizationRequest) string {
+	req.ClientID, req.RedirectURI, req.ResponseType = client.ClientID, client.RedirectURIs[0], "code"
+	_, scopes, err := suite.OAuthService.CheckAuthorization(suite.ctx, req)
+	suite.Require().NoError(err)
+	redirect, err := suite.OAuthService.Authorize(suite.ctx, user.ID, time.Now(), client, req, scopes)
+	suite.Require().NoError(err)
+	parsed, err := url.Parse(redirect)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), oauthIssuer, parsed.Query().Get("iss"))
+	assert.Equal(suite.T(), req.State, parsed.Query().Get("state"))
+	return parsed.Query().Get("code")
+}
+
+func (suite *OAuthServiceTestSuite) TestRegisterClient() {
+	client, secret := suite.registerClient(services.NewOAuthClient{})
+	assert.NotEmpty(suite.T(), secret)
+	assert.Equal(suite.T(), models.StringList{"openid", "profile", "email"}, client.Scopes)
+	assert.Equal(suite.T(), models.StringList{"authorization_code", "refresh_token"}, client.GrantTypes)
+
+	authenticated, err := suite.OAuthService.AuthenticateClient(suite.ctx, client.ClientID, secret)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), client.ClientID, authenticated.ClientID)
+	_, err = suite.OAuthService.AuthenticateClient(suite.ctx, client.ClientID, "wrong")
+	assert.ErrorContains(suite.T(), err, "invalid_client")
+
+	public, secret := suite.registerClient(services.NewOAuthClient{Public: true})
+	assert.Empty(suite.T(), secret)
+	assert.True(suite.T(), public.Public())
+
+	_, _, err = suite.OAuthService.RegisterClient(suite.ctx, services.NewOAuthClient{
+		Name:         "Bad",
+		RedirectURIs: []string{"http://app.example.com/callback", "/relative", "https://app.example.com/#fragment"},
+		Scopes:       []string{"admin"},
+		GrantTypes:   []string{"client_credentials", "password"},
+		Public:       true,
+	})
+	for _, message := range []string{
+		`redirect URI "http://app.example.com/callback" must use https`,
+		`redirect URI "/relative" must be absolute`,
+		`redirect URI "https://app.example.com/#fragment" must be absolute and without fragment`,
+		`unknown scope "admin"`,
+		`unknown grant type "password"`,
+		"public clients can't use the client_credentials grant",
+	} {
+		assert.ErrorContains(suite.T(), err, message)
+	}
+}
+
+func (suite *OAuthServiceTestSuite) TestCheckAuthorization() {
+	client, _ := suite.registerClient(services.NewOAuthClient{Scopes: []string{"openid", "email"}})
+	public, _ := suite.registerClient(services.NewOAuthClient{Public: true})
+	valid := services.AuthorizationRequest{ClientID: client.ClientID, RedirectURI: client.RedirectURIs[0], ResponseType: "code"}
+
+	_, scopes, err := suite.OAuthService.CheckAuthorization(suite.ctx, valid)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), models.StringList{"openid", "email"}, scopes)
+
+	// The user can't be sent back to an unknown client or redirect URI
+	for _, req := range []services.AuthorizationRequest{
+		{ClientID: "unknown", RedirectURI: client.RedirectURIs[0], ResponseType: "code"},
+		{ClientID: client.ClientID, RedirectURI: "https://evil.example.com/callback", ResponseType: "code"},
+	} {
+		found, _, err := suite.OAuthService.CheckAuthorization(suite.ctx, req)
+		assert.Error(suite.T(), err)
+		assert.Nil(suite.T(), found)
+	}
+
+	for code, req := range map[string]services.AuthorizationRequest{
+		"unsupported_response_type": {ClientID: client.ClientID, RedirectURI: client.RedirectURIs[0], ResponseType: "token"},
+		"invalid_scope":             {ClientID: client.ClientID, RedirectURI: client.RedirectURIs[0], ResponseType: "code", Scope: "openid profile"},
+		"invalid_request":           {ClientID: public.ClientID, RedirectURI: public.RedirectURIs[0], ResponseType: "code"},
+	} {
+		found, _, err := suite.OAuthService.CheckAuthorization(suite.ctx, req)
+		assert.ErrorContains(suite.T(), err, code)
+		assert.NotNil(suite.T(), found)
+	}
+	_, _, err = suite.OAuthService.CheckAuthorization(suite.ctx, services.AuthorizationRequest{
+		ClientID: public.ClientID, RedirectURI: public.RedirectURIs[0], ResponseType: "code", CodeChallenge: "challenge", CodeChallengeMethod: "plain",
+	})
+	assert.ErrorContains(suite.T(), err, "code_challenge_method must be S256")
+}
+
+func (suite *OAuthServiceTestSuite) TestExchangeCode() {
+	user := testutils.CreateUser(suite.T(), suite.OAuthService.Users.Users)
+	client, _ := suite.registerClient(services.NewOAuthClient{})
+	verifier, err := oidc.RandomString()
+	suite.Require().NoError(err)
+
+	code := suite.authorize(user, client, services.AuthorizationRequest{
+		Scope: "openid email", State: "state", Nonce: "nonce", CodeChallenge: oidc.CodeChallenge(verifier), CodeChallengeMethod: "S256",
+	})
+	consented, err := suite.OAuthService.HasConsent(suite.ctx, user.ID, client.ClientID, models.StringList{"openid", "email"})
+	suite.Require().NoError(err)
+	assert.True(suite.T(), consented)
+
+	_, err = suite.OAuthService.ExchangeCode(suite.ctx, client, code, client.RedirectURIs[0], "wrong verifier")
+	assert.ErrorContains(suite.T(), err, "invalid_grant")
+	// The failed attempt used the code up
+	_, err = suite.OAuthService.ExchangeCode(suite.ctx, client, code, client.RedirectURIs[0], verifier)
+	assert.ErrorContains(suite.T(), err, "invalid_grant")
+
+	code = suite.authorize(user, client, services.AuthorizationRequest{Scope: "openid email", Nonce: "nonce", CodeChallenge: oidc.CodeChallenge(verifier), CodeChallengeMethod: "S256"})
+	tokens, err := suite.OAuthService.ExchangeCode(suite.ctx, client, code, client.RedirectURIs[0], verifier)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), "openid email", tokens.Scope)
+	assert.NotEmpty(suite.T(), tokens.RefreshToken)
+
+	idToken, err := jwt.Parse(tokens.IDToken, func(*jwt.Token) (interface{}, error) { return &suite.OAuthService.SigningKey.PublicKey, nil })
+	suite.Require().NoError(err)
+	claims := idToken.Claims.(jwt.MapClaims)
+	assert.Equal(suite.T(), oauthIssuer, claims["iss"])
+	assert.Equal(suite.T(), client.ClientID, claims["aud"])
+	assert.Equal(suite.T(), "nonce", claims["nonce"])
+	assert.Equal(suite.T(), user.Email, claims["email"])
+	assert.NotContains(suite.T(), claims, "name")
+
+	info, err := suite.OAuthService.UserInfo(suite.ctx, tokens.AccessToken)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), claims["sub"], info["sub"])
+	assert.Equal(suite.T(), user.Email, info["email"])
+
+	// ID tokens are no access tokens
+	_, err = suite.OAuthService.UserInfo(suite.ctx, tokens.IDToken)
+	assert.ErrorContains(suite.T(), err, "invalid_token")
+
+	// Disabling the user stops its tokens
+	suite.Require().NoError(suite.DB.Model(user).Update("disabled_at", time.Now()).Error)
+	_, err = suite.OAuthService.UserInfo(suite.ctx, tokens.AccessToken)
+	assert.ErrorContains(suite.T(), err, "invalid_token")
+	_, err = suite.OAuthService.Refresh(suite.ctx, client, tokens.RefreshToken, "")
+	assert.ErrorContains(suite.T(), err, "invalid_grant")
+}
+
+func (suite *OAuthServiceTestSuite) TestRefreshRotatesTokens() {
+	user := testutils.CreateUser(suite.T(), suite.OAuthService.Users.Users)
+	client, _ := suite.registerClient(services.NewOAuthClient{})
+	code := suite.authorize(user, client, services.AuthorizationRequest{})
+	first, err := suite.OAuthService.ExchangeCode(suite.ctx, client, code, client.RedirectURIs[0], "")
+	suite.Require().NoError(err)
+
+	// A narrower scope only applies to the access token
+	second, err := suite.OAuthService.Refresh(suite.ctx, client, first.RefreshToken, "openid")
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), "openid", second.Scope)
+	assert.NotEqual(suite.T(), first.RefreshToken, second.RefreshToken)
+	third, err := suite.OAuthService.Refresh(suite.ctx, client, second.RefreshToken, "")
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), "openid profile email", third.Scope)
+
+	_, err = suite.OAuthService.Refresh(suite.ctx, client, third.RefreshToken, "openid admin")
+	assert.ErrorContains(suite.T(), err, "invalid_scope")
+
+	// Another client can't use the token
+	other, _ := suite.registerClient(services.NewOAuthClient{})
+	_, err = suite.OAuthService.Refresh(suite.ctx, other, third.RefreshToken, "")
+	assert.ErrorContains(suite.T(), err, "invalid_grant")
+
+	// Replaying a replaced token revokes the current one too
+	_, err = suite.OAuthService.Refresh(suite.ctx, client, first.RefreshToken, "")
+	assert.ErrorContains(suite.T(), err, "invalid_grant")
+	_, err = suite.OAuthService.Refresh(suite.ctx, client, third.RefreshToken, "")
+	assert.ErrorContains(suite.T(), err, "invalid_grant")
+
+	events, err := suite.OAuthService.Audit.Events.List(suite.ctx, repositories.AuditFilter{Action: services.AuditOAuthRefreshTokenReused}, 10)
+	suite.Require().NoError(err)
+	assert.NotEmpty(suite.T(), events)
+}
+
+func (suite *OAuthServiceTestSuite) TestClientCredentials() {
+	client, secret := suite.registerClient(services.NewOAuthClient{GrantTypes: []string{"client_credentials"}, RedirectURIs: []string{}})
+	authenticated, err := suite.OAuthService.AuthenticateClient(suite.ctx, client.ClientID, secret)
+	suite.Require().NoError(err)
+
+	tokens, err := suite.OAuthService.ClientCredentials(suite.ctx, authenticated, "")
+	suite.Require().NoError(err)
+	assert.Empty(suite.T(), tokens.RefreshToken)
+	assert.Empty(suite.T(), tokens.IDToken)
+	_, err = suite.OAuthService.UserInfo(suite.ctx, tokens.AccessToken)
+	assert.ErrorContains(suite.T(), err, "insufficient_scope")
+
+	_, err = suite.OAuthService.ClientCredentials(suite.ctx, authenticated, "openid")
+	assert.ErrorContains(suite.T(), err, "invalid_scope")
+
+	codeClient, _ := suite.registerClient(services.NewOAuthClient{})
+	_, err = suite.OAuthService.ClientCredentials(suite.ctx, codeClient, "")
+	assert.ErrorContains(suite.T(), err, "unauthorized_client")
+}
+
+func (suite *OAuthServiceTestSuite) TestPurgeRemovesExpiredGrants() {
+	user := testutils.CreateUser(suite.T(), suite.OAuthService.Users.Users)
+	client, _ := suite.registerClient(services.NewOAuthClient{})
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for i, expiresAt := range []time.Time{past, future} {
+		suite.Require().NoError(suite.DB.Create(&models.OAuthCode{CodeHash: fmt.Sprint("code-", i), ClientID: client.ClientID, UserID: user.ID, AuthTime: past, ExpiresAt: expiresAt}).Error)
+		suite.Require().NoError(suite.DB.Create(&models.OAuthRefreshToken{TokenHash: fmt.Sprint("token-", i), ClientID: client.ClientID, UserID: user.ID,
+			AuthTime: past, CreatedAt: past, ExpiresAt: expiresAt, RevokedAt: &past}).Error)
+	}
+
+	result, err := (&services.PurgeService{DB: suite.DB}).Purge()
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), int64(1), result.OAuthCodes)
+	assert.Equal(suite.T(), int64(1), result.OAuthTokens)
+}
+
+func TestOAuthServiceTestSuite(t *testing.T) {
+	suite.Run(t, new(OAuthServiceTestSuite))
+}
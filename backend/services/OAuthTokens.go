@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/oidc"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+)
+
+// accessTokenType tells access tokens apart from ID tokens, which are signed with the same key (RFC 9068)
+const accessTokenType = "at+jwt"
+
+// TokenResponse is the body of a successful token endpoint response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// ParseSigningKey reads the PEM encoded RSA private key signing the tokens of the provider, in PKCS #1 or PKCS #8
+func ParseSigningKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in the signing key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); pkcs8Err != nil || !ok {
+			return nil, errors.New("the signing key must be an RSA private key")
+		}
+	}
+	if key.N.BitLen() < 2048 {
+		return nil, errors.New("the signing key must be at least 2048 bits")
+	}
+	return key, nil
+}
+
+// keyID is the RFC 7638 thumbprint of the public key, so it changes along with the key
+func (s *OAuthService) keyID() string {
+	jwk := s.publicJWK()
+	thumbprint, _ := json.Marshal(map[string]string{"e": jwk["e"], "kty": jwk["kty"], "n": jwk["n"]})
+	sum := sha256.Sum256(thumbprint)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (s *OAuthService) publicJWK() map[string]string {
+	public := s.SigningKey.PublicKey
+	return map[string]string{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}
+}
+
+func (s *OAuthService) sign(claims jwt.MapClaims, tokenType string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID()
+	if tokenType != "" {
+		token.Header["typ"] = tokenType
+	}
+	return token.SignedString(s.SigningKey)
+}
+
+// signAccessToken issues an access token of the provider itself, the userinfo endpoint being its resource server
+func (s *OAuthService) signAccessToken(subject, clientID string, scopes models.StringList, now time.Time) (string, error) {
+	jti, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	return s.sign(jwt.MapClaims{
+		"iss":       s.Issuer,
+		"sub":       subject,
+		"aud":       s.Issuer,
+		"client_id": clientID,
+		"scope":     scopes.String(),
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.accessTokenLifetime()).Unix(),
+		"jti":       jti,
+	}, accessTokenType)
+}
+
+func (s *OAuthService) signIDToken(user *models.User, clientID string, scopes models.StringList, nonce string, authTime, now time.Time) (string, error) {
+	claims := userClaims(user, scopes)
+	claims["iss"] = s.Issuer
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.accessTokenLifetime()).Unix()
+	claims["auth_time"] = authTime.Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return s.sign(claims, "")
+}
+
+// userClaims returns the claims about the user the scopes release
+func userClaims(user *models.User, scopes models.StringList) jwt.MapClaims {
+	claims := jwt.MapClaims{"sub": auditID(user.ID)}
+	if scopes.Contains(ScopeProfile) {
+		claims["name"] = user.Name
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if scopes.Contains(ScopeEmail) {
+		claims["email"] = user.Email
+		// Emails given at registration are never confirmed
+		claims["email_verified"] = false
+	}
+	return claims
+}
+
+// UserInfo returns the claims about the user of an access token, which needs the openid scope
+func (s *OAuthService) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	claims, err := s.parseAccessToken(accessToken)
+	if err != nil {
+		return nil, oauthError(OAuthInvalidToken, "the access token is invalid")
+	}
+	scope, _ := claims["scope"].(string)
+	scopes := models.StringList(strings.Fields(scope))
+	if !scopes.Contains(ScopeOpenID) {
+		return nil, oauthError(OAuthInsufficientScope, "the access token lacks the openid scope")
+	}
+
+	subject, _ := claims["sub"].(string)
+	userID, err := strconv.ParseUint(subject, 10, 0)
+	if err != nil {
+		return nil, oauthError(OAuthInvalidToken, "the access token is invalid")
+	}
+	issuedAt, _ := claims["iat"].(float64)
+	user, err := s.Users.Users.FindByID(ctx, uint(userID))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, oauthError(OAuthInvalidToken, "the account no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil || revokedByUser(user, time.Unix(int64(issuedAt), 0)) {
+		return nil, oauthError(OAuthInvalidToken, "the access token has been revoked")
+	}
+	return userClaims(user, scopes), nil
+}
+
+// parseAccessToken verifies an access token issued by the provider and returns its claims
+func (s *OAuthService) parseAccessToken(accessToken string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 || token.Header["typ"] != accessTokenType {
+			return nil, errors.New("unexpected token type")
+		}
+		return &s.SigningKey.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyIssuer(s.Issuer, true) || !claims.VerifyAudience(s.Issuer, true) {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys clients verify the tokens with
+func (s *OAuthService) JWKS() map[string]interface{} {
+	jwk := s.publicJWK()
+	jwk["kid"] = s.keyID()
+	return map[string]interface{}{"keys": []map[string]string{jwk}}
+}
+
+// Discovery returns the OpenID Connect discovery document of the provider
+func (s *OAuthService) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                         s.Issuer,
+		"authorization_endpoint":                         s.Issuer + "/oauth/authorize",
+		"token_endpoint":                                 s.Issuer + "/oauth/token",
+		"userinfo_endpoint":                              s.Issuer + "/oauth/userinfo",
+		"jwks_uri":                                       s.Issuer + "/oauth/jwks",
+		"response_types_supported":                       []string{"code"},
+		"response_modes_supported":                       []string{"query"},
+		"grant_types_supported":                          supportedGrants,
+		"subject_types_supported":                        []string{"public"},
+		"id_token_signing_alg_values_supported":          []string{"RS256"},
+		"scopes_supported":                               supportedScopes,
+		"claims_supported":                               []string{"sub", "name", "updated_at", "email", "email_verified", "auth_time", "nonce"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"prompt_values_supported":                        []string{"none", "login", "consent"},
+		"authorization_response_iss_parameter_supported": true,
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/passwords"
+)
+
+// hasher returns the pool hashing passwords, one with the default settings when Hasher is unset
+func (s *UserService) hasher() *hashing.Pool {
+	s.hasherOnce.Do(func() {
+		if s.Hasher == nil {
+			s.Hasher = &hashing.Pool{Metrics: s.Metrics}
+		}
+	})
+	return s.Hasher
+}
+
+func (s *UserService) hashPassword(ctx context.Context, password string) (string, error) {
+	return s.hasher().Hash(ctx, password)
+}
+
+// comparePassword also reports whether hash should be upgraded to the configured algorithm
+func (s *UserService) comparePassword(ctx context.Context, hash, password string) (bool, error) {
+	return s.hasher().Compare(ctx, hash, password)
+}
+
+func (s *UserService) passwordPolicy() *passwords.Policy {
+	if s.PasswordPolicy == nil {
+		return &passwords.Policy{}
+	}
+	return s.PasswordPolicy
+}
+
+// checkPassword applies the password policy to a new password of the user with the given name and
+// email. For an existing user, the password must also differ from the current one and the ones in
+// the history, which together make up the last PasswordPolicy.History passwords.
+func (s *UserService) checkPassword(ctx context.Context, password, name, email string, existing *models.User) error {
+	policy := s.passwordPolicy()
+	if err := policy.Check(password, name, email); err != nil {
+		return err
+	}
+	if existing == nil || policy.History <= 0 {
+		return nil
+	}
+
+	hashes := []string{existing.Password}
+	if policy.History > 1 {
+		previous, err := s.Users.PreviousPasswords(ctx, existing.ID, policy.History-1)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, previous...)
+	}
+	for _, hash := range hashes {
+		// Accounts created by an OpenID Connect login have no password to compare with
+		if hash == "" {
+			continue
+		}
+		_, err := s.comparePassword(ctx, hash, password)
+		if err == nil {
+			return &passwords.PolicyError{Problems: []string{fmt.Sprintf("must differ from your last %d passwords", policy.History)}}
+		}
+		if !errors.Is(err, hashing.ErrMismatch) {
+			return err
+		}
+	}
+	return nil
+}
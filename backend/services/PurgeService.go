@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// PurgeService hard-deletes users and addresses once they have been soft-deleted
+// for longer than the retention window, as well as the sessions expired for that long, and drops
+// expired idempotency keys, token revocations, OAuth codes and refresh tokens, and the rate limit buckets that are full again
+type PurgeService struct {
+	DB        *gorm.DB
+	Retention time.Duration
+	Logger    *slog.Logger
+}
+
+type PurgeResult struct {
+	Users            int64
+	Addresses        int64
+	IdempotencyKeys  int64
+	RevokedTokens    int64
+	RateLimitBuckets int64
+	Sessions         int64
+	OAuthCodes       int64
+	OAuthTokens      int64
+}
+
+func (s *PurgeService) retention() time.Duration {
+	if s.Retention <= 0 {
+		return DefaultDeletionGracePeriod
+	}
+	return s.Retention
+}
+
+func (s *PurgeService) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *PurgeService) Purge() (PurgeResult, error) {
+	var result PurgeResult
+	cutoff := time.Now().Add(-s.retention())
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var userIDs []uint
+		if err := tx.Unscoped().Model(&models.User{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Pluck("id", &userIDs).Error; err != nil {
+			return err
+		}
+
+		if len(userIDs) > 0 {
+			// Addresses must go first because of the users foreign key
+			addresses := tx.Unscoped().Where("user_id IN ?", userIDs).Delete(&models.Address{})
+			if addresses.Error != nil {
+				return addresses.Error
+			}
+			result.Addresses += addresses.RowsAffected
+
+			users := tx.Unscoped().Where("id IN ?", userIDs).Delete(&models.User{})
+			if users.Error != nil {
+				return users.Error
+			}
+			result.Users = users.RowsAffected
+		}
+
+		addresses := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Delete(&models.Address{})
+		if addresses.Error != nil {
+			return addresses.Error
+		}
+		result.Addresses += addresses.RowsAffected
+
+		keys := tx.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{})
+		if keys.Error != nil {
+			return keys.Error
+		}
+		result.IdempotencyKeys = keys.RowsAffected
+
+		tokens := tx.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+		if tokens.Error != nil {
+			return tokens.Error
+		}
+		result.RevokedTokens = tokens.RowsAffected
+
+		buckets := tx.Where("full_at < ?", time.Now()).Delete(&models.RateLimitBucket{})
+		if buckets.Error != nil {
+			return buckets.Error
+		}
+		result.RateLimitBuckets = buckets.RowsAffected
+
+		// Expired sessions are kept for a while to recognize the devices of the user
+		sessions := tx.Where("expires_at < ?", cutoff).Delete(&models.Session{})
+		if sessions.Error != nil {
+			return sessions.Error
+		}
+		result.Sessions = sessions.RowsAffected
+
+		codes := tx.Where("expires_at < ?", time.Now()).Delete(&models.OAuthCode{})
+		if codes.Error != nil {
+			return codes.Error
+		}
+		result.OAuthCodes = codes.RowsAffected
+
+		// Replaced refresh tokens stay until they expire, to detect their reuse
+		refreshTokens := tx.Where("expires_at < ?", time.Now()).Delete(&models.OAuthRefreshToken{})
+		if refreshTokens.Error != nil {
+			return refreshTokens.Error
+		}
+		result.OAuthTokens = refreshTokens.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return PurgeResult{}, err
+	}
+	return result, nil
+}
+
+// Run purges on every tick until the context is cancelled
+func (s *PurgeService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := s.Purge()
+		if err != nil {
+			s.logger().ErrorContext(ctx, "purge job failed", "error", err)
+		} else if result.Users > 0 || result.Addresses > 0 {
+			s.logger().InfoContext(ctx, "purge job removed expired data",
+				"users", result.Users, "addresses", result.Addresses,
+				"idempotency_keys", result.IdempotencyKeys, "revoked_tokens", result.RevokedTokens,
+				"rate_limit_buckets", result.RateLimitBuckets, "sessions", result.Sessions,
+				"oauth_codes", result.OAuthCodes, "oauth_tokens", result.OAuthTokens)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
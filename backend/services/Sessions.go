@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arthur-tragante/liven-code-test/mail"
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+// sessionTouchInterval is how stale the last seen time of a session may get, so that it isn't written on every request
+const sessionTouchInterval = time.Minute
+
+// maxUserAgentLength is the size of the sessions.user_agent column
+const maxUserAgentLength = 512
+
+// notificationTimeout bounds the delivery of a notification email
+const notificationTimeout = 30 * time.Second
+
+// Device is where a login comes from
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// startSession records the session of a token issued at now. The user is notified when the session
+// comes from a device that never logged in to the account before, unless it's the account's first session.
+func (s *UserService) startSession(ctx context.Context, user *models.User, tokenID string, now time.Time, device Device) error {
+	if s.Sessions == nil {
+		return nil
+	}
+
+	userAgent := device.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	anySession, knownDevice, err := s.Sessions.Seen(ctx, user.ID, userAgent)
+	if err != nil {
+		return err
+	}
+
+	session := &models.Session{
+		UserID:     user.ID,
+		TokenID:    tokenID,
+		UserAgent:  userAgent,
+		IP:         device.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(TokenLifetime),
+	}
+	if err := s.Sessions.Create(ctx, session); err != nil {
+		return err
+	}
+	if anySession && !knownDevice {
+		s.notifyNewDevice(ctx, user, session)
+	}
+	return nil
+}
+
+// notifyNewDevice emails the user about the session. The login doesn't wait for the mail server,
+// and a failed delivery is only logged.
+func (s *UserService) notifyNewDevice(ctx context.Context, user *models.User, session *models.Session) {
+	if s.Mailer == nil {
+		return
+	}
+	device := session.UserAgent
+	if device == "" {
+		device = "unknown device"
+	}
+	message := mail.Message{
+		To:      user.Email,
+		Subject: "New login to your account",
+		Body: fmt.Sprintf("Hello %s,\n\nYour account was just accessed from a new device:\n\n"+
+			"  Device: %s\n  IP address: %s\n  Time: %s\n\n"+
+			"If this was you, there is nothing to do. Otherwise change your password, which ends every session, "+
+			"or end this one from your list of sessions.\n",
+			user.Name, device, session.IP, session.CreatedAt.UTC().Format(time.RFC1123)),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notificationTimeout)
+		defer cancel()
+		if err := s.Mailer.Send(ctx, message); err != nil {
+			s.logger().ErrorContext(ctx, "new device notification failed", "user", user, "session", session, "error", err)
+		}
+	}()
+}
+
+// ListSessions returns the sessions of the user whose token is still valid, most recently seen first,
+// with Current set on the one of currentTokenID
+func (s *UserService) ListSessions(ctx context.Context, userID uint, currentTokenID string) (_ []models.Session, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ListSessions")
+	defer func() { tracing.End(span, err) }()
+
+	if s.Sessions == nil {
+		return []models.Session{}, nil
+	}
+	user, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.Sessions.ListActive(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Revoking every token of the user, as password changes do, ends the sessions opened before
+	active := sessions[:0]
+	for _, session := range sessions {
+		if revokedByUser(user, session.CreatedAt) {
+			continue
+		}
+		session.Current = session.TokenID == currentTokenID
+		active = append(active, session)
+	}
+	return active, nil
+}
+
+// RevokeSession ends a session of the user, its token is rejected from then on
+func (s *UserService) RevokeSession(ctx context.Context, userID, sessionID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RevokeSession")
+	defer func() { tracing.End(span, err) }()
+
+	if s.Sessions == nil {
+		return repositories.ErrNotFound
+	}
+	session, err := s.Sessions.Revoke(ctx, sessionID, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := s.revokeToken(ctx, session.TokenID, userID, session.ExpiresAt); err != nil {
+		return err
+	}
+	s.Audit.Record(ctx, models.AuditEvent{Action: AuditSessionRevoked, UserID: &userID, TargetType: AuditTargetSession, TargetID: auditID(sessionID)})
+	return nil
+}
+
+// Logout revokes the token and ends its session
+func (s *UserService) Logout(ctx context.Context, userID uint, tokenID string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Logout")
+	defer func() { tracing.End(span, err) }()
+
+	// Without a session the expiry of the token isn't at hand, so the revocation is kept for as long as any token can live
+	expiresAt := time.Now().Add(TokenLifetime)
+	event := userEvent(AuditLogout, userID)
+	if s.Sessions != nil {
+		session, err := s.Sessions.RevokeByToken(ctx, tokenID, time.Now())
+		if err == nil {
+			expiresAt = session.ExpiresAt
+			event.TargetType, event.TargetID = AuditTargetSession, auditID(session.ID)
+		} else if !errors.Is(err, repositories.ErrNotFound) {
+			return err
+		}
+	}
+	if err := s.revokeToken(ctx, tokenID, userID, expiresAt); err != nil {
+		return err
+	}
+	s.Audit.Record(ctx, event)
+	return nil
+}
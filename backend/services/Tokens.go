@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+// TokenLifetime is how long an issued JWT stays valid
+const TokenLifetime = 24 * time.Hour
+
+// issueToken signs a token for the user and returns it with its ID
+func (s *UserService) issueToken(user *models.User, now time.Time) (string, string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	tokenID := hex.EncodeToString(id)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID": user.ID,
+		"jti":    tokenID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(TokenLifetime).Unix(),
+	})
+	signed, err := token.SignedString([]byte(s.JWTSecret))
+	return signed, tokenID, err
+}
+
+// CSRFToken derives the CSRF token of a cookie session from its token ID. Being signed with the JWT
+// secret, it can't be forged by an attacker able to plant cookies for the API's domain.
+func CSRFToken(jwtSecret, tokenID string) string {
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("csrf:" + tokenID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateToken checks that a token with a valid signature hasn't been revoked, either on its own
+// or because its user was deleted, disabled or had every token revoked after it was issued
+func (s *UserService) ValidateToken(ctx context.Context, userID uint, tokenID string, issuedAt time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ValidateToken")
+	defer func() { tracing.End(span, err) }()
+
+	if tokenID != "" {
+		revoked, err := s.Users.IsTokenRevoked(ctx, tokenID)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	user, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ErrTokenRevoked
+		}
+		return err
+	}
+	if user.DisabledAt != nil {
+		return ErrAccountDisabled
+	}
+	if revokedByUser(user, issuedAt) {
+		return ErrTokenRevoked
+	}
+
+	if s.Sessions != nil && tokenID != "" {
+		if err := s.Sessions.Touch(ctx, tokenID, time.Now(), sessionTouchInterval); err != nil {
+			s.logger().WarnContext(ctx, "session last seen update failed", "error", err)
+		}
+	}
+	return nil
+}
+
+// revokedByUser reports whether every token of the user issued at issuedAt has been revoked.
+// iat only has second precision, so a token issued in the same second as the revocation is rejected too.
+func revokedByUser(user *models.User, issuedAt time.Time) bool {
+	return user.TokensRevokedAt != nil && !issuedAt.Truncate(time.Second).After(user.TokensRevokedAt.Truncate(time.Second))
+}
+
+// RevokeToken rejects a single token until its expiry
+func (s *UserService) RevokeToken(ctx context.Context, tokenID string, userID uint, expiresAt time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RevokeToken")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.revokeToken(ctx, tokenID, userID, expiresAt); err != nil {
+		return err
+	}
+	s.Audit.Record(ctx, userEvent(AuditTokenRevoked, userID))
+	return nil
+}
+
+// revokeToken is RevokeToken for the callers recording their own audit event
+func (s *UserService) revokeToken(ctx context.Context, tokenID string, userID uint, expiresAt time.Time) error {
+	return s.Users.RevokeToken(ctx, &models.RevokedToken{
+		TokenID:   tokenID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		RevokedAt: time.Now(),
+	})
+}
+
+// RevokeUserTokens rejects every token issued to the user so far
+func (s *UserService) RevokeUserTokens(ctx context.Context, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.RevokeUserTokens")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now()
+	if err := s.Users.Update(ctx, &models.User{Model: gorm.Model{ID: userID}, TokensRevokedAt: &now}, 0, repositories.UserTokensRevokedAt); err != nil {
+		return err
+	}
+	s.Audit.Record(ctx, userEvent(AuditTokensRevoked, userID))
+	return nil
+}
+
+// ParseToken verifies the signature of a token issued by Login and returns its claims
+func (s *UserService) ParseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(s.JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+type ListUsersOptions = repositories.ListUsersOptions
+
+func (s *UserService) ListUsers(ctx context.Context, options ListUsersOptions) (_ []models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ListUsers")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Users.List(ctx, options)
+}
+
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserByEmail")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Users.FindByEmail(ctx, email, false)
+}
+
+// DisableUser blocks the user from logging in and invalidates the tokens already issued
+func (s *UserService) DisableUser(ctx context.Context, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DisableUser")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now()
+	if err := s.Users.Update(ctx, &models.User{Model: gorm.Model{ID: userID}, DisabledAt: &now}, 0, repositories.UserDisabledAt); err != nil {
+		return err
+	}
+	s.Audit.Record(ctx, userEvent(AuditUserDisabled, userID))
+	return nil
+}
+
+func (s *UserService) EnableUser(ctx context.Context, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.EnableUser")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.Users.Update(ctx, &models.User{Model: gorm.Model{ID: userID}}, 0, repositories.UserDisabledAt); err != nil {
+		return err
+	}
+	s.Audit.Record(ctx, userEvent(AuditUserEnabled, userID))
+	return nil
+}
+
+// ResetPassword sets a new password and revokes every token issued with the old one
+func (s *UserService) ResetPassword(ctx context.Context, userID uint, password string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ResetPassword")
+	defer func() { tracing.End(span, err) }()
+
+	current, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPassword(ctx, password, current.Name, current.Email, current); err != nil {
+		return err
+	}
+	hashedPassword, err := s.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user := &models.User{Model: gorm.Model{ID: userID}, Password: hashedPassword, TokensRevokedAt: &now}
+	if err := s.Users.Update(ctx, user, 0, repositories.UserPassword, repositories.UserTokensRevokedAt); err != nil {
+		return err
+	}
+	event := userEvent(AuditPasswordReset, userID)
+	event.Changes = auditDiff(map[string]string{"password": current.Password}, map[string]string{"password": hashedPassword})
+	s.Audit.Record(ctx, event)
+	return nil
+}
+
+// SetRole makes the user an admin or a regular user
+func (s *UserService) SetRole(ctx context.Context, userID uint, role string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetRole")
+	defer func() { tracing.End(span, err) }()
+
+	if role != models.RoleUser && role != models.RoleAdmin {
+		return fmt.Errorf("role must be %s or %s", models.RoleUser, models.RoleAdmin)
+	}
+	current, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if current.Role == role {
+		return nil
+	}
+	if err := s.Users.Update(ctx, &models.User{Model: gorm.Model{ID: userID}, Role: role}, 0, repositories.UserRole); err != nil {
+		return err
+	}
+	event := userEvent(AuditRoleChanged, userID)
+	event.Changes = auditDiff(map[string]string{"role": current.Role}, map[string]string{"role": role})
+	s.Audit.Record(ctx, event)
+	return nil
+}
+
+// HasRole reports whether the user has the role
+func (s *UserService) HasRole(ctx context.Context, userID uint, role string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.HasRole")
+	defer func() { tracing.End(span, err) }()
+
+	user, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.Role == role, nil
+}
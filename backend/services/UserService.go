@@ -1,91 +1,272 @@
 package services
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/mail"
+	"github.com/arthur-tragante/liven-code-test/metrics"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/passwords"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/tracing"
 )
 
+// DefaultDeletionGracePeriod is how long a deleted account can still be recovered by logging back in
+const DefaultDeletionGracePeriod = 30 * 24 * time.Hour
+
 type UserService struct {
-	DB        *gorm.DB
-	JWTSecret string
+	Users               repositories.UserRepository
+	JWTSecret           string
+	DeletionGracePeriod time.Duration
+	Logger              *slog.Logger
+	Metrics             *metrics.Metrics
+	// Hasher bounds the concurrent hashing operations, a pool with the default settings is used when nil
+	Hasher *hashing.Pool
+	// PasswordPolicy applies to every new password, the zero Policy is used when nil
+	PasswordPolicy *passwords.Policy
+	// Sessions records a session per login, logins aren't tracked when nil
+	Sessions repositories.SessionRepository
+	// Mailer sends the new device notifications, none are sent when nil
+	Mailer mail.Sender
+	// Audit records logins and account changes, nothing is recorded when nil
+	Audit *AuditService
+	// Identities links users to their OpenID Connect accounts, required by LoginWithIdentity
+	Identities repositories.IdentityRepository
+
+	hasherOnce sync.Once
+}
+
+func (s *UserService) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *UserService) gracePeriod() time.Duration {
+	if s.DeletionGracePeriod <= 0 {
+		return DefaultDeletionGracePeriod
+	}
+	return s.DeletionGracePeriod
 }
 
 // function to handle the registrations of the user
 
-func (s *UserService) Register(user *models.User) error {
-	// Using bcrypt to hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+func (s *UserService) Register(ctx context.Context, user *models.User) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Register")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.checkPassword(ctx, user.Password, user.Name, user.Email, nil); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hashPassword(ctx, user.Password)
 	if err != nil {
-		fmt.Println("Password hashing error:", err)
+		s.logger().ErrorContext(ctx, "password hashing failed", "error", err)
 		return err
 	}
-	user.Password = string(hashedPassword)
-	return s.DB.Create(user).Error
+	user.Password = hashedPassword
+	// Admins are only made with the CLI
+	user.Role = models.RoleUser
+	if err := s.Users.Create(ctx, user); err != nil {
+		return err
+	}
+	s.Metrics.UserRegistered()
+
+	event := userEvent(AuditUserRegistered, user.ID)
+	event.ActorID = &user.ID
+	event.Changes = auditDiff(nil, userAuditFields(user))
+	s.Audit.Record(ctx, event)
+	return nil
 }
 
-func (s *UserService) Login(email, password string) (string, error) {
-	var user models.User
-	if err := s.DB.Where("email = ?", email).First(&user).Error; err != nil {
-		fmt.Println("Email lookup error:", err)
-		return "", errors.New("invalid email or password")
+// Login checks the credentials and opens a session for the device
+func (s *UserService) Login(ctx context.Context, email, password string, device Device) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Login")
+	defer func() { tracing.End(span, err) }()
+
+	user, err := s.Authenticate(ctx, email, password)
+	if err != nil {
+		return "", err
+	}
+	return s.openSession(ctx, user, device)
+}
+
+// Authenticate checks the credentials of a login and returns the user, restoring an account inside its
+// deletion grace period. Failures are recorded like failed logins.
+func (s *UserService) Authenticate(ctx context.Context, email, password string) (*models.User, error) {
+	// Including deleted users so that accounts still inside their deletion grace period can log back in
+	user, err := s.Users.FindByEmail(ctx, email, true)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			s.Metrics.LoginAttempt(metrics.LoginFailure)
+			s.logger().InfoContext(ctx, "login rejected", "reason", "unknown email")
+			s.Audit.Record(ctx, models.AuditEvent{Action: AuditLoginFailed, TargetType: AuditTargetUser})
+		} else {
+			s.logger().ErrorContext(ctx, "login lookup failed", "error", err)
+		}
+		return nil, errors.New("invalid email or password")
+	}
+
+	if user.DeletedAt.Valid && time.Since(user.DeletedAt.Time) > s.gracePeriod() {
+		s.Metrics.LoginAttempt(metrics.LoginFailure)
+		s.logger().InfoContext(ctx, "login rejected", "reason", "account deleted", "user", user)
+		s.Audit.Record(ctx, userEvent(AuditLoginFailed, user.ID))
+		return nil, errors.New("invalid email or password")
 	}
 
 	// Comparing the password in database with the password received in the request
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	rehash, err := s.comparePassword(ctx, user.Password, password)
+	if errors.Is(err, ErrHashingBusy) || ctx.Err() != nil {
+		return nil, err
+	}
 	if err != nil {
-		fmt.Println("Password comparison failed:", err)
-		return "", errors.New("invalid email or password")
+		s.Metrics.LoginAttempt(metrics.LoginFailure)
+		s.logger().InfoContext(ctx, "login rejected", "reason", "wrong password", "user", user)
+		s.Audit.Record(ctx, userEvent(AuditLoginFailed, user.ID))
+		return nil, errors.New("invalid email or password")
+	}
+
+	if user.DisabledAt != nil {
+		s.Metrics.LoginAttempt(metrics.LoginDisabled)
+		s.logger().InfoContext(ctx, "login rejected", "reason", "account disabled", "user", user)
+		s.Audit.Record(ctx, userEvent(AuditLoginFailed, user.ID))
+		return nil, ErrAccountDisabled
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID": user.ID,
-		"exp":    time.Now().Add(time.Hour * 24).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(s.JWTSecret))
+	// Logging back in cancels a pending deletion, together with the addresses removed along with the account.
+	// Addresses the user had already moved to the trash before deleting the account stay there.
+	if user.DeletedAt.Valid {
+		if err := s.Users.Restore(ctx, user.ID); err != nil {
+			s.logger().ErrorContext(ctx, "account restore failed", "user", user, "error", err)
+			return nil, err
+		}
+		s.logger().InfoContext(ctx, "deleted account restored by login", "user", user)
+		user.DeletedAt = gorm.DeletedAt{}
+		event := userEvent(AuditUserRestored, user.ID)
+		event.ActorID = &user.ID
+		s.Audit.Record(ctx, event)
+	}
+
+	if rehash {
+		s.upgradePasswordHash(ctx, user, password)
+	}
+
+	return user, nil
+}
+
+// openSession issues the token of a user whose login succeeded and records the session
+func (s *UserService) openSession(ctx context.Context, user *models.User, device Device) (string, error) {
+	now := time.Now()
+	tokenString, tokenID, err := s.issueToken(user, now)
 	if err != nil {
-		fmt.Println("Token signing error:", err)
+		s.logger().ErrorContext(ctx, "token signing failed", "error", err)
+		return "", err
+	}
+	if err := s.startSession(ctx, user, tokenID, now, device); err != nil {
+		s.logger().ErrorContext(ctx, "session creation failed", "user", user, "error", err)
 		return "", err
 	}
 
+	s.Metrics.LoginAttempt(metrics.LoginSuccess)
+	event := userEvent(AuditLogin, user.ID)
+	event.ActorID = &user.ID
+	s.Audit.Record(ctx, event)
 	return tokenString, nil
 }
 
-func (s *UserService) GetUserByID(userID uint) (*models.User, error) {
-	var user models.User
-	if err := s.DB.Preload("Addresses").Where("id = ?", userID).First(&user).Error; err != nil {
-		return nil, err
+// upgradePasswordHash rehashes a password checked against a hash made with an older algorithm or
+// weaker parameters. Failing only costs the upgrade, which is tried again on the next login.
+func (s *UserService) upgradePasswordHash(ctx context.Context, user *models.User, password string) {
+	hashed, err := s.hashPassword(ctx, password)
+	if err == nil {
+		err = s.Users.ReplacePasswordHash(ctx, user.ID, user.Password, hashed)
 	}
-	return &user, nil
+	if err != nil {
+		s.logger().WarnContext(ctx, "password hash upgrade failed", "user", user, "error", err)
+		return
+	}
+	s.logger().InfoContext(ctx, "password hash upgraded", "user", user)
+	user.Password = hashed
+}
+
+func (s *UserService) GetUserByID(ctx context.Context, userID uint) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserByID")
+	defer func() { tracing.End(span, err) }()
+
+	return s.Users.FindWithAddresses(ctx, userID)
 }
 
-func (s *UserService) UpdateUser(userID uint, updatedData *models.User) error {
-	var user models.User
-	if err := s.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+// UpdateUser replaces the user's name and email and, when given, the password. A new password
+// revokes every token issued so far, the caller's included. A non-zero updatedData.Version makes
+// the write conditional and returns ErrVersionConflict on mismatch.
+func (s *UserService) UpdateUser(ctx context.Context, userID uint, updatedData *models.User) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateUser")
+	defer func() { tracing.End(span, err) }()
+
+	user, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
 		return err
 	}
+	if updatedData.Version != 0 && updatedData.Version != user.Version {
+		return ErrVersionConflict
+	}
 
+	before := userAuditFields(user)
 	user.Name = updatedData.Name
 	user.Email = updatedData.Email
+	columns := []string{repositories.UserName, repositories.UserEmail}
 
 	if updatedData.Password != "" {
-		// Same logic for previous password hashing
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(updatedData.Password), bcrypt.DefaultCost)
+		if err := s.checkPassword(ctx, updatedData.Password, updatedData.Name, updatedData.Email, user); err != nil {
+			return err
+		}
+		hashedPassword, err := s.hashPassword(ctx, updatedData.Password)
 		if err != nil {
 			return err
 		}
-		user.Password = string(hashedPassword)
+		now := time.Now()
+		user.Password = hashedPassword
+		user.TokensRevokedAt = &now
+		columns = append(columns, repositories.UserPassword, repositories.UserTokensRevokedAt)
+	}
+
+	if err := s.Users.Update(ctx, user, user.Version, columns...); err != nil {
+		return err
 	}
+	if changes := auditDiff(before, userAuditFields(user)); changes != nil {
+		event := userEvent(AuditUserUpdated, userID)
+		event.Changes = changes
+		s.Audit.Record(ctx, event)
+	}
+	return nil
+}
 
-	return s.DB.Save(&user).Error
+// DeleteUser soft-deletes the user together with its active addresses. The account
+// stays recoverable for the grace period and is hard-deleted afterwards by PurgeService.
+// A non-zero version makes the delete conditional like in UpdateUser.
+func (s *UserService) DeleteUser(ctx context.Context, userID, version uint) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeleteUser")
+	defer func() { tracing.End(span, err) }()
+
+	err = s.Users.SoftDelete(ctx, userID, version, time.Now())
+	if errors.Is(err, repositories.ErrNotFound) && version == 0 {
+		return nil
+	}
+	if err == nil {
+		s.Audit.Record(ctx, userEvent(AuditUserDeleted, userID))
+	}
+	return err
 }
 
-func (s *UserService) DeleteUser(userID uint) error {
-	return s.DB.Where("id = ?", userID).Delete(&models.User{}).Error
+// DeletionDeadline returns when a soft-deleted account stops being recoverable
+func (s *UserService) DeletionDeadline(deletedAt time.Time) time.Time {
+	return deletedAt.Add(s.gracePeriod())
 }
@@ -1,38 +1,40 @@
 package services_test
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/arthur-tragante/liven-code-test/hashing"
+	"github.com/arthur-tragante/liven-code-test/logging"
 	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/passwords"
+	"github.com/arthur-tragante/liven-code-test/repositories"
 	"github.com/arthur-tragante/liven-code-test/services"
 	"github.com/arthur-tragante/liven-code-test/testutils"
 )
 
 type UserServiceTestSuite struct {
 	suite.Suite
-	TestDBSetup *testutils.TestDBSetup
 	UserService *services.UserService
 	DB          *gorm.DB
 }
 
-func (suite *UserServiceTestSuite) SetupSuite() {
-	suite.TestDBSetup = testutils.SetupTestDB(assert.New(suite.T()))
-	suite.DB = suite.TestDBSetup.DB
+// SetupTest gives every test its own empty database
+func (suite *UserServiceTestSuite) SetupTest() {
+	suite.DB = testutils.NewTestDB(suite.T())
 	suite.UserService = &services.UserService{
-		DB:        suite.DB,
+		Users:     &repositories.GormUserRepository{DB: suite.DB},
 		JWTSecret: "testsecret",
 	}
 }
 
-func (suite *UserServiceTestSuite) TearDownSuite() {
-	suite.TestDBSetup.TearDown(assert.New(suite.T()))
-}
-
 func (suite *UserServiceTestSuite) TestRegister() {
 	user := &models.User{
 		Name:     "John Doe",
@@ -40,7 +42,7 @@ func (suite *UserServiceTestSuite) TestRegister() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	var createdUser models.User
@@ -61,18 +63,62 @@ func (suite *UserServiceTestSuite) TestLogin() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
-	token, err := suite.UserService.Login("jane.doe@example.com", "password123")
+	token, err := suite.UserService.Login(context.Background(), "jane.doe@example.com", "password123", services.Device{})
 	assert.NoError(suite.T(), err)
 	assert.NotEmpty(suite.T(), token)
 
-	token, err = suite.UserService.Login("jane.doe@example.com", "wrongpassword")
+	token, err = suite.UserService.Login(context.Background(), "jane.doe@example.com", "wrongpassword", services.Device{})
 	assert.Error(suite.T(), err)
 	assert.Empty(suite.T(), token)
 }
 
+// A hash made by an older algorithm is replaced on the next successful login, without bumping the version
+func (suite *UserServiceTestSuite) TestLoginUpgradesPasswordHash() {
+	user := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	suite.UserService.Hasher = &hashing.Pool{Hasher: &hashing.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}}
+
+	_, err := suite.UserService.Login(context.Background(), user.Email, "wrong", services.Device{})
+	assert.Error(suite.T(), err)
+	stored, err := suite.UserService.Users.FindByID(context.Background(), user.ID)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), user.Password, stored.Password)
+
+	_, err = suite.UserService.Login(context.Background(), user.Email, testutils.Password, services.Device{})
+	suite.Require().NoError(err)
+	stored, err = suite.UserService.Users.FindByID(context.Background(), user.ID)
+	suite.Require().NoError(err)
+	assert.True(suite.T(), strings.HasPrefix(stored.Password, "$argon2id$v=19$m=64,t=1,p=1$"))
+	assert.Equal(suite.T(), user.Version, stored.Version)
+
+	_, err = suite.UserService.Login(context.Background(), user.Email, testutils.Password, services.Device{})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *UserServiceTestSuite) TestPasswordPolicy() {
+	suite.UserService.PasswordPolicy = &passwords.Policy{History: 2}
+	ctx := context.Background()
+
+	err := suite.UserService.Register(ctx, &models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "1"})
+	assert.ErrorIs(suite.T(), err, services.ErrPasswordRejected)
+
+	user := &models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "first-password"}
+	suite.Require().NoError(suite.UserService.Register(ctx, user))
+
+	update := func(password string) error {
+		return suite.UserService.UpdateUser(ctx, user.ID, &models.User{Name: user.Name, Email: user.Email, Password: password})
+	}
+	assert.ErrorIs(suite.T(), update("first-password"), services.ErrPasswordRejected)
+	assert.ErrorIs(suite.T(), update("johndoe-rules"), services.ErrPasswordRejected)
+	suite.Require().NoError(update("second-password"))
+	// The current and the previous passwords are the last 2
+	assert.ErrorIs(suite.T(), suite.UserService.ResetPassword(ctx, user.ID, "first-password"), services.ErrPasswordRejected)
+	suite.Require().NoError(update("third-password"))
+	assert.NoError(suite.T(), suite.UserService.ResetPassword(ctx, user.ID, "first-password"))
+}
+
 func (suite *UserServiceTestSuite) TestGetUserByID() {
 	user := &models.User{
 		Name:     "Jack Doe",
@@ -80,19 +126,19 @@ func (suite *UserServiceTestSuite) TestGetUserByID() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	var createdUser models.User
 	err = suite.DB.First(&createdUser, "email = ?", "jack.doe@example.com").Error
 	assert.NoError(suite.T(), err)
 
-	fetchedUser, err := suite.UserService.GetUserByID(createdUser.ID)
+	fetchedUser, err := suite.UserService.GetUserByID(context.Background(), createdUser.ID)
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), createdUser.Email, fetchedUser.Email)
 	assert.Equal(suite.T(), createdUser.Name, fetchedUser.Name)
 
-	fetchedUser, err = suite.UserService.GetUserByID(9999)
+	fetchedUser, err = suite.UserService.GetUserByID(context.Background(), 9999)
 	assert.Error(suite.T(), err)
 	assert.Nil(suite.T(), fetchedUser)
 }
@@ -104,7 +150,7 @@ func (suite *UserServiceTestSuite) TestUpdateUser() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	var createdUser models.User
@@ -117,7 +163,7 @@ func (suite *UserServiceTestSuite) TestUpdateUser() {
 		Password: "newpassword",
 	}
 
-	err = suite.UserService.UpdateUser(createdUser.ID, updatedData)
+	err = suite.UserService.UpdateUser(context.Background(), createdUser.ID, updatedData)
 	assert.NoError(suite.T(), err)
 
 	var updatedUser models.User
@@ -137,14 +183,14 @@ func (suite *UserServiceTestSuite) TestDeleteUser() {
 		Password: "password123",
 	}
 
-	err := suite.UserService.Register(user)
+	err := suite.UserService.Register(context.Background(), user)
 	assert.NoError(suite.T(), err)
 
 	var createdUser models.User
 	err = suite.DB.First(&createdUser, "email = ?", "jim.doe@example.com").Error
 	assert.NoError(suite.T(), err)
 
-	err = suite.UserService.DeleteUser(createdUser.ID)
+	err = suite.UserService.DeleteUser(context.Background(), createdUser.ID, 0)
 	assert.NoError(suite.T(), err)
 
 	var deletedUser models.User
@@ -152,6 +198,220 @@ func (suite *UserServiceTestSuite) TestDeleteUser() {
 	assert.Error(suite.T(), err)
 }
 
+func (suite *UserServiceTestSuite) TestDisableUser() {
+	user := &models.User{
+		Name:     "Jade Doe",
+		Email:    "jade.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	err = suite.UserService.DisableUser(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.UserService.Login(context.Background(), "jade.doe@example.com", "password123", services.Device{})
+	assert.ErrorIs(suite.T(), err, services.ErrAccountDisabled)
+	assert.ErrorIs(suite.T(), suite.UserService.ValidateToken(context.Background(), user.ID, "", time.Now()), services.ErrAccountDisabled)
+
+	err = suite.UserService.EnableUser(context.Background(), user.ID)
+	assert.NoError(suite.T(), err)
+
+	token, err := suite.UserService.Login(context.Background(), "jade.doe@example.com", "password123", services.Device{})
+	assert.NoError(suite.T(), err)
+	assert.NotEmpty(suite.T(), token)
+}
+
+func (suite *UserServiceTestSuite) TestTokenRevocation() {
+	user := &models.User{
+		Name:     "Joan Doe",
+		Email:    "joan.doe@example.com",
+		Password: "password123",
+	}
+
+	err := suite.UserService.Register(context.Background(), user)
+	assert.NoError(suite.T(), err)
+
+	token, err := suite.UserService.Login(context.Background(), "joan.doe@example.com", "password123", services.Device{})
+	assert.NoError(suite.T(), err)
+
+	claims, err := suite.UserService.ParseToken(token)
+	assert.NoError(suite.T(), err)
+	tokenID := claims["jti"].(string)
+	issuedAt := time.Unix(int64(claims["iat"].(float64)), 0)
+	assert.NoError(suite.T(), suite.UserService.ValidateToken(context.Background(), user.ID, tokenID, issuedAt))
+
+	err = suite.UserService.RevokeToken(context.Background(), tokenID, user.ID, time.Now().Add(time.Hour))
+	assert.NoError(suite.T(), err)
+	assert.ErrorIs(suite.T(), suite.UserService.ValidateToken(context.Background(), user.ID, tokenID, issuedAt), services.ErrTokenRevoked)
+
+	// Tokens issued before a password reset stop working
+	assert.NoError(suite.T(), suite.UserService.ValidateToken(context.Background(), user.ID, "other", issuedAt))
+	err = suite.UserService.ResetPassword(context.Background(), user.ID, "newpassword123")
+	assert.NoError(suite.T(), err)
+	assert.ErrorIs(suite.T(), suite.UserService.ValidateToken(context.Background(), user.ID, "other", issuedAt), services.ErrTokenRevoked)
+
+	_, err = suite.UserService.Login(context.Background(), "joan.doe@example.com", "newpassword123", services.Device{})
+	assert.NoError(suite.T(), err)
+
+	// So do the ones issued before the user changes the password, unlike other changes
+	other := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	issuedAt = time.Now().Add(-time.Minute)
+	err = suite.UserService.UpdateUser(context.Background(), other.ID, &models.User{Name: "Renamed", Email: other.Email})
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), suite.UserService.ValidateToken(context.Background(), other.ID, "other", issuedAt))
+	err = suite.UserService.UpdateUser(context.Background(), other.ID, &models.User{Name: "Renamed", Email: other.Email, Password: "changedpassword123"})
+	assert.NoError(suite.T(), err)
+	assert.ErrorIs(suite.T(), suite.UserService.ValidateToken(context.Background(), other.ID, "other", issuedAt), services.ErrTokenRevoked)
+}
+
+func (suite *UserServiceTestSuite) TestSessions() {
+	mailbox := &testutils.Mailbox{}
+	suite.UserService.Sessions = &repositories.GormSessionRepository{DB: suite.DB}
+	suite.UserService.Mailer = mailbox
+	user := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	ctx := context.Background()
+	firefox := services.Device{UserAgent: "Mozilla/5.0 Firefox/130.0", IP: "192.0.2.1"}
+	chrome := services.Device{UserAgent: "Mozilla/5.0 Chrome/129.0", IP: "198.51.100.7"}
+	login := func(device services.Device) string {
+		token, err := suite.UserService.Login(ctx, user.Email, testutils.Password, device)
+		suite.Require().NoError(err)
+		claims, err := suite.UserService.ParseToken(token)
+		suite.Require().NoError(err)
+		return claims["jti"].(string)
+	}
+
+	// Neither the first login of the account nor a known device are worth an email
+	firstToken := login(firefox)
+	login(firefox)
+	chromeToken := login(chrome)
+	assert.Eventually(suite.T(), func() bool { return len(mailbox.Messages()) == 1 }, time.Second, 10*time.Millisecond)
+	message := mailbox.Messages()[0]
+	assert.Equal(suite.T(), user.Email, message.To)
+	assert.Contains(suite.T(), message.Body, "Chrome/129.0")
+	assert.Contains(suite.T(), message.Body, "198.51.100.7")
+
+	sessions, err := suite.UserService.ListSessions(ctx, user.ID, chromeToken)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 3)
+	assert.True(suite.T(), sessions[0].Current)
+	assert.Equal(suite.T(), chrome.UserAgent, sessions[0].UserAgent)
+	assert.False(suite.T(), sessions[2].Current)
+	first := sessions[2]
+
+	other := testutils.CreateUser(suite.T(), suite.UserService.Users)
+	assert.ErrorIs(suite.T(), suite.UserService.RevokeSession(ctx, other.ID, first.ID), repositories.ErrNotFound)
+	assert.NoError(suite.T(), suite.UserService.RevokeSession(ctx, user.ID, first.ID))
+	assert.ErrorIs(suite.T(), suite.UserService.ValidateToken(ctx, user.ID, firstToken, first.CreatedAt), services.ErrTokenRevoked)
+	assert.ErrorIs(suite.T(), suite.UserService.RevokeSession(ctx, user.ID, first.ID), repositories.ErrNotFound)
+
+	assert.NoError(suite.T(), suite.UserService.Logout(ctx, user.ID, chromeToken))
+	sessions, err = suite.UserService.ListSessions(ctx, user.ID, "")
+	suite.Require().NoError(err)
+	assert.Len(suite.T(), sessions, 1)
+
+	// A password reset ends the remaining ones
+	assert.NoError(suite.T(), suite.UserService.ResetPassword(ctx, user.ID, "another-password-123"))
+	sessions, err = suite.UserService.ListSessions(ctx, user.ID, "")
+	suite.Require().NoError(err)
+	assert.Empty(suite.T(), sessions)
+}
+
+func (suite *UserServiceTestSuite) TestAuditLog() {
+	audit := &repositories.GormAuditRepository{DB: suite.DB}
+	suite.UserService.Audit = &services.AuditService{Events: audit}
+	ctx := logging.WithClientIP(logging.WithRequestID(context.Background(), "req-42"), "203.0.113.9")
+
+	user := &models.User{Name: "Jill Doe", Email: "jill.doe@example.com", Password: "password123", Role: models.RoleAdmin}
+	suite.Require().NoError(suite.UserService.Register(ctx, user))
+	assert.Equal(suite.T(), models.RoleUser, user.Role)
+	_, err := suite.UserService.Login(ctx, user.Email, "wrong-password", services.Device{})
+	assert.Error(suite.T(), err)
+	_, err = suite.UserService.Login(ctx, user.Email, "password123", services.Device{})
+	suite.Require().NoError(err)
+
+	// Changes made through the API are attributed to the authenticated user
+	authenticated := logging.WithUserID(ctx, user.ID)
+	suite.Require().NoError(suite.UserService.UpdateUser(authenticated, user.ID, &models.User{Name: "Jill Smith", Email: user.Email, Password: "new-password-123"}))
+	suite.Require().NoError(suite.UserService.SetRole(ctx, user.ID, models.RoleAdmin))
+	assert.Error(suite.T(), suite.UserService.SetRole(ctx, user.ID, "root"))
+
+	events, err := audit.List(ctx, repositories.AuditFilter{UserID: user.ID}, 10)
+	suite.Require().NoError(err)
+	actions := []string{}
+	for _, event := range events {
+		actions = append(actions, event.Action)
+		assert.Equal(suite.T(), "203.0.113.9", event.IP)
+		assert.Equal(suite.T(), "req-42", event.RequestID)
+	}
+	assert.Equal(suite.T(), []string{services.AuditRoleChanged, services.AuditUserUpdated, services.AuditLogin, services.AuditLoginFailed, services.AuditUserRegistered}, actions)
+
+	roleChanged, updated, login, failed := events[0], events[1], events[2], events[3]
+	assert.Nil(suite.T(), roleChanged.ActorID)
+	assert.Equal(suite.T(), models.AuditChanges{"role": {Before: models.RoleUser, After: models.RoleAdmin}}, roleChanged.Changes)
+	assert.Equal(suite.T(), user.ID, *updated.ActorID)
+	assert.Equal(suite.T(), models.AuditChanges{
+		"name":     {Before: "Jill Doe", After: "Jill Smith"},
+		"password": {Before: "[REDACTED]", After: "[REDACTED]"},
+	}, updated.Changes)
+	assert.Equal(suite.T(), user.ID, *login.ActorID)
+	assert.Nil(suite.T(), failed.ActorID)
+	assert.NotContains(suite.T(), events[4].Changes, "role")
+	assert.Equal(suite.T(), "[REDACTED]", events[4].Changes["password"].After)
+}
+
 func TestUserServiceTestSuite(t *testing.T) {
 	suite.Run(t, new(UserServiceTestSuite))
 }
+
+func (suite *UserServiceTestSuite) TestLoginWithIdentity() {
+	ctx := context.Background()
+	suite.UserService.Identities = &repositories.GormIdentityRepository{DB: suite.DB}
+	existing := &models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "password123"}
+	suite.Require().NoError(suite.UserService.Register(ctx, existing))
+
+	// An unverified email can't be trusted to pick the account
+	_, err := suite.UserService.LoginWithIdentity(ctx, services.ExternalIdentity{Provider: "google", Subject: "1", Email: existing.Email}, services.Device{})
+	assert.ErrorIs(suite.T(), err, services.ErrEmailNotVerified)
+
+	// A verified email links the identity to the account with that email
+	google := services.ExternalIdentity{Provider: "google", Subject: "1", Email: existing.Email, EmailVerified: true}
+	token, err := suite.UserService.LoginWithIdentity(ctx, google, services.Device{})
+	suite.Require().NoError(err)
+	claims, err := suite.UserService.ParseToken(token)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), float64(existing.ID), claims["userID"])
+
+	// Once linked, the subject is what matters: the email at the provider may change or lose its verification
+	token, err = suite.UserService.LoginWithIdentity(ctx, services.ExternalIdentity{Provider: "google", Subject: "1", Email: "john@other.example.com"}, services.Device{})
+	suite.Require().NoError(err)
+	claims, _ = suite.UserService.ParseToken(token)
+	assert.Equal(suite.T(), float64(existing.ID), claims["userID"])
+
+	// An unknown email gets a new account, which has no usable password
+	jane := services.ExternalIdentity{Provider: "corp", Subject: "j", Email: "jane.doe@example.com", EmailVerified: true, Name: "Jane Doe"}
+	_, err = suite.UserService.LoginWithIdentity(ctx, jane, services.Device{})
+	suite.Require().NoError(err)
+	created, err := suite.UserService.Users.FindByEmail(ctx, jane.Email, false)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), "Jane Doe", created.Name)
+	assert.Equal(suite.T(), models.RoleUser, created.Role)
+	_, err = suite.UserService.Login(ctx, jane.Email, "", services.Device{})
+	assert.Error(suite.T(), err)
+
+	// The password history has nothing to compare a first password with
+	suite.UserService.PasswordPolicy = &passwords.Policy{History: 3}
+	suite.Require().NoError(suite.UserService.UpdateUser(ctx, created.ID, &models.User{Name: created.Name, Email: created.Email, Password: "first-password"}))
+	_, err = suite.UserService.Login(ctx, jane.Email, "first-password", services.Device{})
+	suite.Require().NoError(err)
+	suite.UserService.PasswordPolicy = nil
+
+	suite.Require().NoError(suite.UserService.DisableUser(ctx, created.ID))
+	_, err = suite.UserService.LoginWithIdentity(ctx, jane, services.Device{})
+	assert.ErrorIs(suite.T(), err, services.ErrAccountDisabled)
+
+	suite.Require().NoError(suite.UserService.DeleteUser(ctx, existing.ID, 0))
+	_, err = suite.UserService.LoginWithIdentity(ctx, google, services.Device{})
+	assert.ErrorIs(suite.T(), err, services.ErrAccountDeleted)
+}
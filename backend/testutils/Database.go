@@ -0,0 +1,158 @@
+package testutils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/database"
+	"github.com/arthur-tragante/liven-code-test/migrations"
+)
+
+// postgresContainerName is shared by every test binary, so packages tested in parallel
+// reuse one container instead of starting their own
+const postgresContainerName = "liven-code-test-postgres"
+
+// server is the Postgres server shared by every test of the process
+var server struct {
+	once  sync.Once
+	dsn   string
+	admin *gorm.DB
+	err   error
+}
+
+// NewTestDB returns an empty, migrated database that only the calling test sees and that is dropped
+// when the test ends. It is a SQLite file by default, which needs nothing installed, or a schema of a
+// shared Postgres server when TEST_DB_DRIVER=postgres: TEST_DATABASE_URL when set, a container otherwise.
+func NewTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	if os.Getenv("TEST_DB_DRIVER") == config.DriverPostgres {
+		return newPostgresTestDB(t)
+	}
+	return newSQLiteTestDB(t)
+}
+
+func newSQLiteTestDB(t testing.TB) *gorm.DB {
+	db, err := database.Open(config.Database{Driver: config.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")}, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { closeDB(db) })
+
+	migrate(t, db)
+	return db
+}
+
+// newPostgresTestDB isolates the test in its own schema, which is cheaper than a database per test
+// and, unlike a rolled back transaction, lets the code under test open transactions and run concurrently
+func newPostgresTestDB(t testing.TB) *gorm.DB {
+	server.once.Do(startPostgres)
+	require.NoError(t, server.err)
+
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	require.NoError(t, err)
+	schema := "test_" + hex.EncodeToString(suffix)
+	require.NoError(t, server.admin.Exec("CREATE SCHEMA "+schema).Error)
+
+	db, err := gorm.Open(postgres.Open(withSearchPath(server.dsn, schema)), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		closeDB(db)
+		server.admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	migrate(t, db)
+	return db
+}
+
+func startPostgres() {
+	server.dsn = os.Getenv("TEST_DATABASE_URL")
+	if server.dsn == "" {
+		server.dsn, server.err = startPostgresContainer()
+		if server.err != nil {
+			return
+		}
+	}
+	server.admin, server.err = gorm.Open(postgres.Open(server.dsn), &gorm.Config{})
+}
+
+// startPostgresContainer starts the shared container or attaches to the one another test binary started,
+// Docker must be running. The container is removed by the testcontainers reaper once the tests are done.
+func startPostgresContainer() (string, error) {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Name:         postgresContainerName,
+			Image:        "postgres:13",
+			ExposedPorts: []string{"5432/tcp"},
+			Env: map[string]string{
+				"POSTGRES_PASSWORD": "password",
+				"POSTGRES_DB":       "testdb",
+			},
+			WaitingFor: wait.ForListeningPort("5432/tcp").WithStartupTimeout(5 * time.Minute),
+		},
+		Started: true,
+		Reuse:   true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		return "", err
+	}
+	port, err := container.MappedPort(ctx, "5432")
+	if err != nil {
+		return "", err
+	}
+
+	return config.Database{
+		Driver:   config.DriverPostgres,
+		Host:     host,
+		Port:     port.Port(),
+		User:     "postgres",
+		Password: "password",
+		Name:     "testdb",
+		SSLMode:  "disable",
+	}.DSN(), nil
+}
+
+// withSearchPath makes every connection of the DSN resolve unqualified tables in schema
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			query := u.Query()
+			query.Set("search_path", schema)
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+func migrate(t testing.TB, db *gorm.DB) {
+	migrator, err := migrations.New(db)
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
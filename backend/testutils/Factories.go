@@ -0,0 +1,86 @@
+package testutils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/arthur-tragante/liven-code-test/models"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+)
+
+// Password is the plain text password of every user created by CreateUser
+const Password = "password123"
+
+// JWTSecret signs the tokens of IssueToken and AuthenticatedRequest
+const JWTSecret = "testsecret"
+
+var sequence atomic.Uint64
+
+// CreateUser stores a user with a unique email and Password as password, overrides run before it is stored
+func CreateUser(t testing.TB, users repositories.UserRepository, overrides ...func(*models.User)) *models.User {
+	t.Helper()
+	// The minimum cost keeps factories fast, Login accepts any cost
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	n := sequence.Add(1)
+	user := &models.User{
+		Name:     fmt.Sprintf("Test User %d", n),
+		Email:    fmt.Sprintf("test.user%d@example.com", n),
+		Password: string(hash),
+	}
+	for _, override := range overrides {
+		override(user)
+	}
+	require.NoError(t, users.Create(context.Background(), user))
+	return user
+}
+
+// CreateAddress stores an address of the user, overrides run before it is stored
+func CreateAddress(t testing.TB, addresses repositories.AddressRepository, userID uint, overrides ...func(*models.Address)) *models.Address {
+	t.Helper()
+	n := sequence.Add(1)
+	address := &models.Address{
+		UserID:     userID,
+		Street:     fmt.Sprintf("%d Test St", n),
+		Number:     "1",
+		Complement: "Apt 1",
+		City:       "Test City",
+		State:      "Test State",
+		Zipcode:    "12345",
+		Country:    "Test Country",
+	}
+	for _, override := range overrides {
+		override(address)
+	}
+	require.NoError(t, addresses.Create(context.Background(), address))
+	return address
+}
+
+// IssueToken signs a token for the user with the claims Login puts in its tokens
+func IssueToken(t testing.TB, user *models.User, secret string) string {
+	t.Helper()
+	tokenID := make([]byte, 16)
+	_, err := rand.Read(tokenID)
+	require.NoError(t, err)
+
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID": user.ID,
+		"jti":    hex.EncodeToString(tokenID),
+		"iat":    now.Unix(),
+		"exp":    now.Add(services.TokenLifetime).Unix(),
+	}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
@@ -0,0 +1,28 @@
+package testutils
+
+import (
+	"context"
+	"sync"
+
+	"github.com/arthur-tragante/liven-code-test/mail"
+)
+
+// Mailbox is a mail.Sender keeping the messages instead of sending them
+type Mailbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *Mailbox) Send(ctx context.Context, message mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (m *Mailbox) Messages() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mail.Message(nil), m.messages...)
+}
@@ -0,0 +1,26 @@
+package testutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var oauthKey struct {
+	once sync.Once
+	key  *rsa.PrivateKey
+	err  error
+}
+
+// OAuthSigningKey returns an RSA key for the OpenID Connect provider, generated once per test binary
+func OAuthSigningKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+	oauthKey.once.Do(func() {
+		oauthKey.key, oauthKey.err = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	require.NoError(t, oauthKey.err)
+	return oauthKey.key
+}
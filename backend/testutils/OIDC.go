@@ -0,0 +1,239 @@
+package testutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/oidc"
+)
+
+// mockOIDCKeyID is the kid of the mock provider's signing key
+const mockOIDCKeyID = "mock-key"
+
+// OIDCUser is the account logging in at the mock OpenID Connect provider
+type OIDCUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// MockOIDCProvider is a local OpenID Connect provider serving discovery, the JWKS, an authorization
+// endpoint logging User in without a login page, and a token endpoint checking PKCE and the client credentials
+type MockOIDCProvider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+	key          *rsa.PrivateKey
+
+	mu          sync.Mutex
+	user        OIDCUser
+	extraClaims jwt.MapClaims
+	grants      map[string]mockOIDCGrant
+}
+
+type mockOIDCGrant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        OIDCUser
+}
+
+// NewMockOIDCProvider starts a provider with a single registered client, stopped with the test
+func NewMockOIDCProvider(t testing.TB, clientID, clientSecret string) *MockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &MockOIDCProvider{ClientID: clientID, ClientSecret: clientSecret, key: key, grants: map[string]mockOIDCGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Server.Close)
+	return m
+}
+
+// Issuer is the issuer URL of the provider
+func (m *MockOIDCProvider) Issuer() string {
+	return m.Server.URL
+}
+
+// Provider returns a client of the mock provider redirecting to redirectURL
+func (m *MockOIDCProvider) Provider(name, redirectURL string) *oidc.Provider {
+	return &oidc.Provider{
+		Name:         name,
+		Issuer:       m.Issuer(),
+		ClientID:     m.ClientID,
+		ClientSecret: m.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// SetUser selects who logs in on the next authorizations, a zero user refuses them with access_denied
+func (m *MockOIDCProvider) SetUser(user OIDCUser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.user = user
+}
+
+// SetExtraClaims adds claims to the next ID tokens, overriding the regular ones, e.g. another audience
+func (m *MockOIDCProvider) SetExtraClaims(claims jwt.MapClaims) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.extraClaims = claims
+}
+
+// Authorize does what the browser does at the provider: it opens authURL and returns the URL the
+// provider redirects back to, carrying the code and state
+func (m *MockOIDCProvider) Authorize(t testing.TB, authURL string) *url.URL {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := resp.Location()
+	require.NoError(t, err)
+	return location
+}
+
+// SignIDToken signs claims with the provider's key, for tokens the flow wouldn't issue
+func (m *MockOIDCProvider) SignIDToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockOIDCKeyID
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (m *MockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                m.Issuer(),
+		"authorization_endpoint":                m.Issuer() + "/authorize",
+		"token_endpoint":                        m.Issuer() + "/token",
+		"jwks_uri":                              m.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *MockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": mockOIDCKeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
+
+func (m *MockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != m.ClientID || query.Get("redirect_uri") == "" ||
+		query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	user := m.user
+	values := redirect.Query()
+	values.Set("state", query.Get("state"))
+	if user == (OIDCUser{}) {
+		values.Set("error", "access_denied")
+	} else {
+		code, _ := oidc.RandomString()
+		m.grants[code] = mockOIDCGrant{
+			redirectURI: query.Get("redirect_uri"),
+			challenge:   query.Get("code_challenge"),
+			nonce:       query.Get("nonce"),
+			user:        user,
+		}
+		values.Set("code", code)
+	}
+	m.mu.Unlock()
+
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *MockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != m.ClientID || clientSecret != m.ClientSecret {
+		writeMockJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	m.mu.Lock()
+	code := r.PostFormValue("code")
+	grant, ok := m.grants[code]
+	// Codes are single use, even when the redemption fails
+	delete(m.grants, code)
+	extraClaims := m.extraClaims
+	m.mu.Unlock()
+
+	if !ok || grant.redirectURI != r.PostFormValue("redirect_uri") || oidc.CodeChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.Issuer(),
+		"sub":            grant.user.Subject,
+		"aud":            m.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          grant.nonce,
+		"email":          grant.user.Email,
+		"email_verified": grant.user.EmailVerified,
+		"name":           grant.user.Name,
+	}
+	for name, value := range extraClaims {
+		claims[name] = value
+	}
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     m.SignIDToken(claims),
+	})
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,34 @@
+package testutils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/models"
+)
+
+// AuthenticatedRequest builds a gin test context for a request of the user carrying a token signed with
+// JWTSecret. The context goes through AuthMiddleware, so it holds the same keys a handler sees in production.
+func AuthenticatedRequest(t testing.TB, user *models.User, method, path string, body io.Reader) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	request, err := http.NewRequest(method, path, body)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+IssueToken(t, user, JWTSecret))
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	c.Request = request
+
+	middlewares.AuthMiddleware(JWTSecret, nil)(c)
+	require.False(t, c.IsAborted(), "authentication failed: %s", w.Body.String())
+	return c, w
+}
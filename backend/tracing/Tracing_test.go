@@ -0,0 +1,94 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/arthur-tragante/liven-code-test/config"
+	"github.com/arthur-tragante/liven-code-test/controllers"
+	"github.com/arthur-tragante/liven-code-test/logging"
+	"github.com/arthur-tragante/liven-code-test/middlewares"
+	"github.com/arthur-tragante/liven-code-test/repositories"
+	"github.com/arthur-tragante/liven-code-test/services"
+	"github.com/arthur-tragante/liven-code-test/testutils"
+	"github.com/arthur-tragante/liven-code-test/tracing"
+)
+
+const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestRequestTrace(t *testing.T) {
+	var logs bytes.Buffer
+	logger := logging.New(&logs, slog.LevelInfo)
+	db := testutils.NewTestDB(t)
+	userService := &services.UserService{Users: &repositories.GormUserRepository{DB: db}, JWTSecret: "testsecret", Logger: logger}
+	user := testutils.CreateUser(t, userService.Users)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(middlewares.TracingMiddleware(), middlewares.RequestIDMiddleware())
+	r.POST("/login", (&controllers.UserController{UserService: userService}).LoginUser)
+
+	body := `{"email":"` + user.Email + `","password":"wrong"}`
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String(), span.Name())
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "POST /login")
+	require.Contains(t, spans, "UserService.Login")
+	require.Contains(t, spans, "password.compare")
+	require.Contains(t, spans, "gorm.query")
+
+	login := spans["UserService.Login"]
+	assert.Equal(t, spans["POST /login"].SpanContext().SpanID(), login.Parent().SpanID())
+	assert.Equal(t, login.SpanContext().SpanID(), spans["password.compare"].Parent().SpanID())
+	assert.Equal(t, login.SpanContext().SpanID(), spans["gorm.query"].Parent().SpanID())
+
+	var statement string
+	for _, attr := range spans["gorm.query"].Attributes() {
+		if attr.Key == "db.statement" {
+			statement = attr.Value.AsString()
+		}
+	}
+	assert.Contains(t, statement, "email = ?")
+	assert.NotContains(t, statement, user.Email)
+
+	assert.Contains(t, logs.String(), `"trace_id":"`+traceID+`"`)
+}
+
+func TestSetup(t *testing.T) {
+	var out bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), config.Tracing{Exporter: config.TracingStdout, SampleRatio: 1}, &out)
+	require.NoError(t, err)
+
+	_, span := tracing.Start(context.Background(), "manual")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Contains(t, out.String(), `"Name":"manual"`)
+	assert.Contains(t, out.String(), tracing.ServiceName)
+}